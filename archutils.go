@@ -5,9 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	c "github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/hardware"
+	"github.com/fcarp10/archutils/internal/scripts"
 	"github.com/fcarp10/archutils/internal/tui"
 )
 
@@ -23,6 +27,12 @@ func main() {
 	showVersion := flag.Bool("version", false, "Print version and exit")
 	showHelp := flag.Bool("help", false, "Print this help message")
 	flag.BoolVar(showHelp, "h", false, "Print this help message (shorthand)")
+	aurHelper := flag.String("aur-helper", "", "AUR helper to use: paru, yay or pikaur")
+	targetRoot := flag.String("root", "", "Configure the system mounted at this path instead of the running one")
+	targetUser := flag.String("user", "", "User that user-scoped tasks apply to")
+	profile := flag.String("profile", "", "Profile that conditional blocks of the lists are evaluated against")
+	convert := flag.String("convert", "", "Print a .txt category file converted to --format and exit")
+	format := flag.String("format", c.FormatTOML, "Format of --convert: toml or yaml")
 	flag.Parse()
 
 	if *showVersion {
@@ -37,23 +47,120 @@ Usage:
   archutils [flags]
 
 Flags:
-  --version   Print version and exit
-  --help, -h  Print this help message
+  --version            Print version and exit
+  --help, -h           Print this help message
+  --aur-helper <name>  AUR helper to use: %s (default: auto-detect)
+  --root <path>        Configure the system mounted at <path> (e.g. /mnt from
+                       the live ISO) instead of the running one
+  --user <name>        User for user-scoped tasks (default: the invoking user,
+                       SUDO_USER under sudo; required with --root)
+  --profile <name>     Profile for "@if profile=<name>" blocks of the lists,
+                       e.g. niri or hyprland (default: ARCHUTILS_PROFILE)
+  --convert <file>     Print a .txt category file as a .toml or .yaml one
+                       and exit, e.g. to migrate configs/packages/01-wm.txt
+  --format <format>    Format of --convert: toml or yaml (default: toml)
 
 The TUI guides you through installing Arch Linux packages, VSCode
-extensions, and system configurations interactively.
+extensions, Flatpak applications, pipx, cargo, npm and go tools, and
+system configurations interactively.
+
+When run as root, repository packages are installed with pacman directly
+and AUR packages are built as the unprivileged "archutils-build" user,
+which is created on first use. With --root the same applies inside the
+target: packages are installed with pacstrap or arch-chroot, files under
+/etc are written below the target root and services are enabled with
+systemctl --root.
+
+Lists may keep or drop blocks of lines with "@if <condition>", "@elif",
+"@else" and "@end", on hostname=<name>, profile=<name>, env:<VAR>[=<value>]
+or detected hardware (battery, gpu=<vendor>, cpu=<vendor>, bluetooth, wifi,
+printer), and pull shared fragments in with "@include <path>". Categories
+can also be .toml or .yaml files, which add descriptions, tags, per-item
+options and a source key installing items through another directory's
+source, e.g. source = "flatpak"; --convert migrates a .txt one.
 
 Environment variables:
-  ARCHUTILS_EDITOR   Editor binary for extension management (default: codium)
-`)
+  ARCHUTILS_EDITOR      Editor binary for extension management (default: codium)
+  ARCHUTILS_PRIVILEGE   Privilege tool: sudo, doas or run0 (default: auto-detect).
+                        Installs and upgrades need cached credentials: with
+                        run0, or doas without "persist", configure the
+                        passwordless rule from the menu first
+  ARCHUTILS_AUR_HELPER  AUR helper: paru, yay or pikaur, overridden by --aur-helper (default: auto-detect)
+  ARCHUTILS_PROFILE     Profile, overridden by --profile
+`, strings.Join(scripts.AURHelperNames(), ", "))
+		os.Exit(0)
+	}
+
+	if *convert != "" {
+		data, err := os.ReadFile(*convert)
+		if err == nil {
+			// The kind is the directory name, e.g. packages.
+			data, err = c.Convert(data, filepath.Base(filepath.Dir(*convert)), *format)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot convert %s: %v\n", *convert, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
 		os.Exit(0)
 	}
 
+	if *aurHelper != "" {
+		if _, ok := scripts.LookupAURHelper(*aurHelper); !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown AUR helper %q (expected one of: %s)\n", *aurHelper, strings.Join(scripts.AURHelperNames(), ", "))
+			os.Exit(2)
+		}
+	}
+	if helper := os.Getenv("ARCHUTILS_AUR_HELPER"); helper != "" {
+		if _, ok := scripts.LookupAURHelper(helper); !ok {
+			fmt.Fprintf(os.Stderr, "Error: unknown AUR helper %q in ARCHUTILS_AUR_HELPER (expected one of: %s)\n", helper, strings.Join(scripts.AURHelperNames(), ", "))
+			os.Exit(2)
+		}
+	}
+
+	if tool := os.Getenv("ARCHUTILS_PRIVILEGE"); tool != "" && !scripts.IsPrivilegeTool(tool) {
+		fmt.Fprintf(os.Stderr, "Error: unknown privilege tool %q in ARCHUTILS_PRIVILEGE (expected one of: %s)\n", tool, strings.Join(scripts.PrivilegeToolNames(), ", "))
+		os.Exit(2)
+	}
+
+	if *targetRoot != "" {
+		if info, err := os.Stat(*targetRoot); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Error: --root %q is not a directory\n", *targetRoot)
+			os.Exit(2)
+		}
+	}
+
+	if *profile == "" {
+		*profile = os.Getenv("ARCHUTILS_PROFILE")
+	}
+
+	hw := hardware.Detect()
 	c.Init(configFS)
+	c.SetEnv(c.Env{
+		Hostname: hostname(*targetRoot),
+		Profile:  *profile,
+		Hardware: hw,
+		Getenv:   os.Getenv,
+	})
 
-	p := tea.NewProgram(tui.InitialModel())
+	runner := scripts.Runner{AURHelper: *aurHelper, Root: *targetRoot, User: *targetUser}
+	p := tea.NewProgram(tui.InitialModel(runner, hw))
 	if _, err := p.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// hostname returns the hostname of the system being configured: the one in
+// /etc/hostname of the target with --root, which is not the running one.
+func hostname(root string) string {
+	if root == "" {
+		name, _ := os.Hostname()
+		return name
+	}
+	data, err := os.ReadFile(filepath.Join(root, "etc", "hostname"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
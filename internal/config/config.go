@@ -1,13 +1,18 @@
 package config
 
 import (
-	"bufio"
+	"cmp"
 	"embed"
 	"fmt"
 	"io"
 	"io/fs"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/fcarp10/archutils/internal/hardware"
 )
 
 // fsys is the filesystem interface used to read embedded and test configs.
@@ -22,6 +27,7 @@ var configFS fsys
 var configDir = "configs"
 var pkgsDir = configDir + "/packages"
 var extDir = configDir + "/vscode"
+var flatpakDir = configDir + "/flatpak"
 
 // Init sets the filesystem used for reading configuration files.
 // In production this is an embed.FS; in tests it can be a fstest.MapFS.
@@ -37,6 +43,58 @@ func ExtDir() string {
 	return extDir
 }
 
+func FlatpakDir() string {
+	return flatpakDir
+}
+
+// SourceDir returns the category directory of an item source kind, e.g.
+// "packages" → "configs/packages".
+func SourceDir(kind string) string {
+	return configDir + "/" + kind
+}
+
+// SourceKind returns the item source kind of a category directory.
+func SourceKind(dir string) string {
+	return path.Base(dir)
+}
+
+// sourceAnnotation routes an item to the source of another kind than the
+// one of its category directory, e.g. "org.gimp.GIMP [source:flatpak]" in a
+// packages category.
+const sourceAnnotation = "source:"
+
+// ItemSource returns the source kind of an item line of a kind's category:
+// the kind its [source:<kind>] annotation names, or kind itself. The line is
+// returned without the annotation, as the source expects it.
+func ItemSource(line, kind string) (string, string) {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if source, ok := strings.CutPrefix(field, "["+sourceAnnotation); ok && strings.HasSuffix(source, "]") {
+			return strings.TrimSuffix(source, "]"), strings.Join(slices.Delete(fields, i, i+1), " ")
+		}
+	}
+	return kind, line
+}
+
+// checkSourceKind returns an error unless kind is the kind of one of the
+// category directories.
+func checkSourceKind(kind string) error {
+	entries, err := configFS.ReadDir(configDir)
+	if err != nil {
+		return err
+	}
+	var kinds []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if entry.Name() == kind {
+				return nil
+			}
+			kinds = append(kinds, entry.Name())
+		}
+	}
+	return fmt.Errorf("unknown source %q (expected one of: %s)", kind, strings.Join(kinds, ", "))
+}
+
 func ConfigDir() string {
 	return configDir
 }
@@ -51,17 +109,23 @@ func ReadFile(name string) ([]byte, error) {
 }
 
 // readCategoryFile opens a file once and returns the category name (from ### header)
-// and the filtered lines (skipping empty lines and ## comments).
+// and the filtered lines (skipping empty lines and ## comments). Conditions on
+// a ## header are appended to the lines below it, up to the next ## header.
+// Includes and conditional blocks are resolved first, see expandLines.
 func readCategoryFile(filePath string) (categoryName string, items []string, err error) {
-	file, err := configFS.Open(filePath)
+	lines, err := expandLines(filePath, nil)
 	if err != nil {
 		return "", nil, err
 	}
-	defer file.Close()
+	categoryName, items = parseCategoryLines(lines)
+	return categoryName, items, nil
+}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		text := strings.TrimSpace(scanner.Text())
+// parseCategoryLines returns the category name and the item lines of the
+// lines of a .txt category file.
+func parseCategoryLines(lines []string) (categoryName string, items []string) {
+	var section []string
+	for _, text := range lines {
 		if text == "" {
 			continue
 		}
@@ -70,58 +134,223 @@ func readCategoryFile(filePath string) (categoryName string, items []string, err
 			continue
 		}
 		if strings.HasPrefix(text, "##") {
+			section = conditionFields(text)
 			continue
 		}
+		if len(section) > 0 {
+			text += " " + strings.Join(section, " ")
+		}
 		items = append(items, text)
 	}
-
-	if err := scanner.Err(); err != nil {
-		return "", nil, err
-	}
-
-	return categoryName, items, nil
+	return categoryName, items
 }
 
 type Item struct {
 	Name        string
 	Description string
+	// Tags are extra search terms, set in structured category files.
+	Tags []string
+	// Conditions are the hardware conditions of the item and its section.
+	Conditions []Condition
 }
 
 type Category struct {
-	Name  string
-	Key   string
-	Items []Item
+	Name string
+	// Description is set in structured category files.
+	Description string
+	Key         string
+	Items       []Item
+	// Conditions are the hardware conditions of the whole category.
+	Conditions []Condition
+}
+
+// Condition annotations of items, ## sections and ### headers, e.g.
+// "intel-ucode [when:cpu=intel]" or "### Laptop [only:battery]".
+const (
+	// whenAnnotation items are only preselected when the condition holds.
+	whenAnnotation = "when:"
+	// onlyAnnotation items are hidden when the condition does not hold.
+	onlyAnnotation = "only:"
+)
+
+// Condition is a hardware condition, see the hardware package for the
+// features and vendors.
+type Condition struct {
+	// Feature is the detected feature, e.g. battery or gpu.
+	Feature string
+	// Value is the vendor a gpu or cpu must have, or "" for any.
+	Value string
+	// Negate makes the condition hold when the feature is absent.
+	Negate bool
+	// Hide hides what does not meet the condition instead of only not
+	// preselecting it.
+	Hide bool
+}
+
+func (c Condition) String() string {
+	s := whenAnnotation
+	if c.Hide {
+		s = onlyAnnotation
+	}
+	if c.Negate {
+		s += "!"
+	}
+	s += c.Feature
+	if c.Value != "" {
+		s += "=" + c.Value
+	}
+	return "[" + s + "]"
+}
+
+// conditionFields returns the condition annotations of a line.
+func conditionFields(line string) []string {
+	var fields []string
+	for _, field := range strings.Fields(line) {
+		if _, ok := parseCondition(field); ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// parseCondition parses a [when:...] or [only:...] annotation.
+func parseCondition(field string) (Condition, bool) {
+	content, ok := strings.CutPrefix(field, "[")
+	if !ok {
+		return Condition{}, false
+	}
+	if content, ok = strings.CutSuffix(content, "]"); !ok {
+		return Condition{}, false
+	}
+	var c Condition
+	if rest, ok := strings.CutPrefix(content, onlyAnnotation); ok {
+		c.Hide = true
+		content = rest
+	} else if content, ok = strings.CutPrefix(content, whenAnnotation); !ok {
+		return Condition{}, false
+	}
+	content, c.Negate = strings.CutPrefix(content, "!")
+	c.Feature, c.Value, _ = strings.Cut(content, "=")
+	if c.Feature == "" {
+		return Condition{}, false
+	}
+	return c, true
+}
+
+// checkConditions reports conditions on features the hardware package does
+// not detect.
+func checkConditions(conditions []Condition) error {
+	for _, c := range conditions {
+		if _, known := (hardware.Info{}).Has(c.Feature, c.Value); !known {
+			return fmt.Errorf("undefined condition %s, expected one of %s", c, strings.Join(hardwareKeys, ", "))
+		}
+	}
+	return nil
+}
+
+// splitConditions removes the condition annotations from a line and returns
+// them. Lines without any are returned unchanged.
+func splitConditions(line string) (string, []Condition) {
+	var rest []string
+	var conditions []Condition
+	for _, field := range strings.Fields(line) {
+		if c, ok := parseCondition(field); ok {
+			conditions = append(conditions, c)
+		} else {
+			rest = append(rest, field)
+		}
+	}
+	if len(conditions) == 0 {
+		return line, nil
+	}
+	return strings.Join(rest, " "), conditions
 }
 
+// ReadCategories reads the .txt, .toml and .yaml category files of dir,
+// ordered by their order key, or else by the number their name starts with.
 func ReadCategories(dir string) ([]Category, error) {
+	if configFS == nil {
+		return nil, fmt.Errorf("config filesystem not initialized")
+	}
 	var categories []Category
+	var orders []int
 	subFiles, err := configFS.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("error reading directory %s: %v", dir, err)
 	}
+	files := make(map[string]string)
 	for _, subFile := range subFiles {
 		if subFile.IsDir() {
 			continue
 		}
-		if filepath.Ext(subFile.Name()) != ".txt" {
+		ext := filepath.Ext(subFile.Name())
+		format, ok := categoryExts[ext]
+		if !ok {
 			continue
 		}
-		filePath := filepath.Join(dir, subFile.Name())
-		categoryName, itemNames, err := readCategoryFile(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("error reading file %s: %v", filePath, err)
-		}
-		category := Category{
-			Name: categoryName,
-			Key:  strings.TrimSuffix(subFile.Name(), ".txt"),
+		key := strings.TrimSuffix(subFile.Name(), ext)
+		if other, ok := files[key]; ok {
+			return nil, fmt.Errorf("category %s is defined by both %s and %s", key, other, subFile.Name())
 		}
-		for _, name := range itemNames {
-			category.Items = append(category.Items, Item{Name: name})
+		files[key] = subFile.Name()
+		filePath := filepath.Join(dir, subFile.Name())
+		order := fileOrder(key)
+
+		var category Category
+		if format != "" {
+			var explicit int
+			category, explicit, err = readStructuredFile(filePath, format, SourceKind(dir))
+			if err != nil {
+				return nil, fmt.Errorf("error reading file %s: %v", filePath, err)
+			}
+			if explicit != 0 {
+				order = explicit
+			}
+		} else {
+			categoryName, itemNames, err := readCategoryFile(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("error reading file %s: %v", filePath, err)
+			}
+			category.Name, category.Conditions = splitConditions(categoryName)
+			if err := checkConditions(category.Conditions); err != nil {
+				return nil, fmt.Errorf("error reading file %s: %v", filePath, err)
+			}
+			for _, line := range itemNames {
+				name, conditions := splitConditions(line)
+				if err := checkConditions(conditions); err != nil {
+					return nil, fmt.Errorf("error reading file %s: item %s: %v", filePath, name, err)
+				}
+				if source, _ := ItemSource(name, ""); source != "" {
+					if err := checkSourceKind(source); err != nil {
+						return nil, fmt.Errorf("error reading file %s: item %s: %v", filePath, name, err)
+					}
+				}
+				category.Items = append(category.Items, Item{Name: name, Conditions: conditions})
+			}
 		}
+		category.Key = key
 		categories = append(categories, category)
+		orders = append(orders, order)
 	}
 
-	return categories, nil
+	indices := make([]int, len(categories))
+	for i := range indices {
+		indices[i] = i
+	}
+	slices.SortStableFunc(indices, func(a, b int) int { return cmp.Compare(orders[a], orders[b]) })
+	sorted := make([]Category, len(categories))
+	for i, index := range indices {
+		sorted[i] = categories[index]
+	}
+	return sorted, nil
+}
+
+// fileOrder returns the number a category file name starts with, e.g. 1 for
+// "01-wm", or 0.
+func fileOrder(key string) int {
+	digits := key[:len(key)-len(strings.TrimLeft(key, "0123456789"))]
+	order, _ := strconv.Atoi(digits)
+	return order
 }
 
 func CategoryNames(categories []Category) []string {
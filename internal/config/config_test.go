@@ -1,8 +1,12 @@
 package config
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 	"testing/fstest"
+
+	"github.com/fcarp10/archutils/internal/hardware"
 )
 
 func testFS() fstest.MapFS {
@@ -217,3 +221,315 @@ func TestDirPaths(t *testing.T) {
 		t.Errorf("expected 'configs', got %q", ConfigDir())
 	}
 }
+
+func TestReadCategories_Conditions(t *testing.T) {
+	configFS = fstest.MapFS{
+		"configs/packages/01-laptop.txt": {
+			Data: []byte("### Laptop [when:battery]\ntlp\n## Bluetooth [when:bluetooth]\nbluez [bluetooth]\n# blueberry\n## Other\nintel-ucode [only:cpu=intel] [when:!gpu=nvidia]\n"),
+		},
+	}
+	categories, err := ReadCategories("configs/packages")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	category := categories[0]
+	if category.Name != "Laptop" || len(category.Conditions) != 1 || category.Conditions[0] != (Condition{Feature: "battery"}) {
+		t.Errorf("unexpected category %q with conditions %v", category.Name, category.Conditions)
+	}
+	want := []struct {
+		name       string
+		conditions string
+	}{
+		{"tlp", ""},
+		{"bluez [bluetooth]", "[when:bluetooth]"},
+		{"# blueberry", "[when:bluetooth]"},
+		{"intel-ucode", "[only:cpu=intel] [when:!gpu=nvidia]"},
+	}
+	if len(category.Items) != len(want) {
+		t.Fatalf("expected %d items, got %v", len(want), category.Items)
+	}
+	for i, w := range want {
+		item := category.Items[i]
+		var conditions []string
+		for _, c := range item.Conditions {
+			conditions = append(conditions, c.String())
+		}
+		if item.Name != w.name || strings.Join(conditions, " ") != w.conditions {
+			t.Errorf("item %d: got %q %v, want %q %s", i, item.Name, conditions, w.name, w.conditions)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		field string
+		want  Condition
+		ok    bool
+	}{
+		{"[when:gpu=amd]", Condition{Feature: "gpu", Value: "amd"}, true},
+		{"[only:!battery]", Condition{Feature: "battery", Negate: true, Hide: true}, true},
+		{"[when:]", Condition{}, false},
+		{"[bluetooth]", Condition{}, false},
+		{"when:wifi", Condition{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseCondition(tt.field)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseCondition(%q) = %+v, %v, want %+v, %v", tt.field, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReadCategories_Directives(t *testing.T) {
+	defer SetEnv(Env{})
+	SetEnv(Env{
+		Hostname: "laptop",
+		Profile:  "niri",
+		Hardware: hardware.Info{GPUs: []string{hardware.AMD}, WiFi: true},
+		Getenv: func(name string) string {
+			if name == "XDG_SESSION_TYPE" {
+				return "wayland"
+			}
+			return ""
+		},
+	})
+	configFS = fstest.MapFS{
+		"configs/packages/01-wm.txt": {
+			Data: []byte("### Window Managers\n" +
+				"@if profile=hyprland\nhyprland\n@elif profile=niri,sway\nniri\n@if hostname=laptop env:XDG_SESSION_TYPE=wayland\nbrightnessctl\n@end\n@else\ni3-wm\n@end\n" +
+				"@if !gpu=nvidia wifi\n@include shared/wayland.txt\n@end\n" +
+				"@if env:DISPLAY\nxorg-server\n@elif hostname=desktop\n@include missing.txt\n@else\n# xorg-xinit\n@end\n"),
+		},
+		"configs/packages/shared/wayland.txt": {Data: []byte("## Wayland\nxwayland-satellite\n")},
+	}
+	categories, err := ReadCategories("configs/packages")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(categories) != 1 {
+		t.Fatalf("expected the fragment directory to be skipped, got %d categories", len(categories))
+	}
+	var names []string
+	for _, item := range categories[0].Items {
+		names = append(names, item.Name)
+	}
+	want := "niri brightnessctl xwayland-satellite # xorg-xinit"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("got items %q, want %q", got, want)
+	}
+}
+
+func TestReadCategories_DirectiveErrors(t *testing.T) {
+	defer SetEnv(Env{})
+	SetEnv(Env{})
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"undefined condition", "### A\n@if desktop\npkg\n@end\n", `01-a.txt:2: undefined condition "desktop"`},
+		{"undefined vendor check", "### A\n@if sound=intel\n@end\n", `undefined condition "sound=intel"`},
+		{"undefined after a false term", "### A\n@if profile=niri batery\n@end\n", `01-a.txt:2: undefined condition "batery"`},
+		{"undefined in a dropped block", "### A\n@if profile=niri\n@if batery\n@end\n@end\n", `01-a.txt:3: undefined condition "batery"`},
+		{"undefined in a dropped elif", "### A\n@if env:NONE\n@elif profile=niri\n@elif batery\n@end\n", `01-a.txt:4: undefined condition "batery"`},
+		{"missing value", "### A\n@if profile\n@end\n", `condition "profile" needs a value`},
+		{"missing condition", "### A\n@if\n@end\n", "01-a.txt:2: missing condition"},
+		{"unknown directive", "### A\n@ifdef x\n", "01-a.txt:2: unknown directive @ifdef"},
+		{"unterminated block", "### A\n@if wifi\npkg\n", "01-a.txt:2: @if without @end"},
+		{"stray end", "### A\n@end\n", "01-a.txt:2: @end without @if"},
+		{"elif after else", "### A\n@if wifi\n@else\n@elif battery\n@end\n", "@elif after @else of the block at line 2"},
+		{"include cycle", "### A\n@include shared/b.txt\n", "include cycle: configs/packages/01-a.txt -> configs/packages/shared/b.txt -> configs/packages/01-a.txt"},
+		{"header in include", "### A\n@include shared/c.txt\n", "included file shared/c.txt has a ### header"},
+		{"missing include", "### A\n@include shared/none.txt\n", "01-a.txt:2: open configs/packages/shared/none.txt"},
+	}
+	for _, tt := range tests {
+		configFS = fstest.MapFS{
+			"configs/packages/01-a.txt":     {Data: []byte(tt.data)},
+			"configs/packages/shared/b.txt": {Data: []byte("pkg\n@include ../01-a.txt\n")},
+			"configs/packages/shared/c.txt": {Data: []byte("### C\npkg\n")},
+		}
+		_, err := ReadCategories("configs/packages")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReadCategories_UndefinedCondition(t *testing.T) {
+	for data, want := range map[string]string{
+		"### A [when:laptop]\npkg\n":       "01-a.txt: undefined condition [when:laptop]",
+		"### A\npkg [only:!sound=intel]\n": "01-a.txt: item pkg: undefined condition [only:!sound=intel]",
+		"### A\npkg [source:snap]\n":       `01-a.txt: item pkg [source:snap]: unknown source "snap" (expected one of: packages)`,
+	} {
+		configFS = fstest.MapFS{"configs/packages/01-a.txt": {Data: []byte(data)}}
+		_, err := ReadCategories("configs/packages")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	}
+}
+
+func TestReadCategories_Structured(t *testing.T) {
+	configFS = fstest.MapFS{
+		"configs/packages/01-wm.txt": {Data: []byte("### Window Managers\nniri\n")},
+		"configs/packages/02-devops.toml": {Data: []byte(`name = "DevOps"
+description = "Containers and clusters"
+order = 5
+only = ["!battery"]
+
+[[items]]
+name = "docker"
+description = "Container runtime"
+services = ["docker"]
+options = ["group:docker"]
+tags = ["containers"]
+
+[[items]]
+name = "kubectl"
+selected = false
+when = ["cpu=intel"]
+
+[[items]]
+name = "io.podman_desktop.PodmanDesktop"
+source = "flatpak"
+`)},
+		"configs/packages/04-gui.yaml": {Data: []byte(`name: GUI
+source: flatpak
+items:
+  - name: org.gimp.GIMP
+  - name: gimp
+    source: packages
+`)},
+		"configs/flatpak/01-apps.txt": {Data: []byte("### Apps\norg.gimp.GIMP\n")},
+		"configs/packages/03-audio.yaml": {Data: []byte(`name: Audio
+items:
+  - name: pipewire
+    services: [pipewire.socket]
+    options: [user]
+  - name: pavucontrol
+    selected: true
+`)},
+	}
+	categories, err := ReadCategories("configs/packages")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var keys []string
+	for _, c := range categories {
+		keys = append(keys, c.Key)
+	}
+	if got := strings.Join(keys, " "); got != "01-wm 03-audio 04-gui 02-devops" {
+		t.Fatalf("expected the order key to move 02-devops last, got %s", got)
+	}
+
+	devops := categories[3]
+	if devops.Name != "DevOps" || devops.Description != "Containers and clusters" {
+		t.Errorf("unexpected category %q: %q", devops.Name, devops.Description)
+	}
+	if len(devops.Conditions) != 1 || devops.Conditions[0] != (Condition{Feature: "battery", Negate: true, Hide: true}) {
+		t.Errorf("unexpected category conditions %v", devops.Conditions)
+	}
+	docker, kubectl, podman := devops.Items[0], devops.Items[1], devops.Items[2]
+	if docker.Name != "docker [docker] [group:docker]" || docker.Description != "Container runtime" || strings.Join(docker.Tags, ",") != "containers" {
+		t.Errorf("unexpected item %+v", docker)
+	}
+	if kubectl.Name != "# kubectl" || len(kubectl.Conditions) != 1 || kubectl.Conditions[0] != (Condition{Feature: "cpu", Value: "intel"}) {
+		t.Errorf("unexpected item %+v", kubectl)
+	}
+
+	if podman.Name != "io.podman_desktop.PodmanDesktop [source:flatpak]" {
+		t.Errorf("expected the item to be routed to flatpak, got %q", podman.Name)
+	}
+	gui := categories[2]
+	if len(gui.Items) != 2 || gui.Items[0].Name != "org.gimp.GIMP [source:flatpak]" || gui.Items[1].Name != "gimp" {
+		t.Errorf("expected the category source to apply unless the item names its own, got %+v", gui.Items)
+	}
+
+	audio := categories[1]
+	if len(audio.Items) != 2 || audio.Items[0].Name != "pipewire [pipewire.socket] [user]" || audio.Items[1].Name != "pavucontrol" {
+		t.Errorf("unexpected items %+v", audio.Items)
+	}
+}
+
+func TestReadCategories_StructuredErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"unknown toml key", fstest.MapFS{"configs/packages/01-a.toml": {Data: []byte("name = \"A\"\n[[items]]\nname = \"x\"\nservice = [\"y\"]\n")}},
+			"01-a.toml: unknown key items.service"},
+		{"unknown yaml key", fstest.MapFS{"configs/packages/01-a.yaml": {Data: []byte("name: A\nitems:\n  - name: x\n    enabled: true\n")}},
+			"field enabled not found"},
+		{"missing name", fstest.MapFS{"configs/packages/01-a.yaml": {Data: []byte("items:\n  - name: x\n")}},
+			"missing category name"},
+		{"invalid item name", fstest.MapFS{"configs/packages/01-a.yaml": {Data: []byte("name: A\nitems:\n  - name: x y\n")}},
+			`item 1: invalid name "x y"`},
+		{"unknown item source", fstest.MapFS{"configs/packages/01-a.toml": {Data: []byte("name = \"A\"\n[[items]]\nname = \"x\"\nsource = \"snap\"\n")}},
+			`01-a.toml: item x: unknown source "snap" (expected one of: packages)`},
+		{"unknown category source", fstest.MapFS{"configs/packages/01-a.yaml": {Data: []byte("name: A\nsource: orphans\n")}},
+			`unknown source "orphans"`},
+		{"invalid condition", fstest.MapFS{"configs/packages/01-a.yaml": {Data: []byte("name: A\nwhen: ['']\n")}},
+			`invalid condition "when:"`},
+		{"undefined condition", fstest.MapFS{"configs/packages/01-a.yaml": {Data: []byte("name: A\nitems:\n  - name: x\n    only: [laptop]\n")}},
+			"item x: undefined condition [only:laptop], expected one of battery"},
+		{"duplicate key", fstest.MapFS{
+			"configs/packages/01-a.txt":  {Data: []byte("### A\nx\n")},
+			"configs/packages/01-a.toml": {Data: []byte("name = \"A\"\n")},
+		}, "category 01-a is defined by both 01-a.toml and 01-a.txt"},
+	}
+	for _, tt := range tests {
+		configFS = tt.files
+		_, err := ReadCategories("configs/packages")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestItemSource(t *testing.T) {
+	for line, want := range map[string][2]string{
+		"org.gimp.GIMP [source:flatpak] [user]": {"flatpak", "org.gimp.GIMP [user]"},
+		"docker [docker]":                       {"packages", "docker [docker]"},
+	} {
+		if kind, rest := ItemSource(line, "packages"); kind != want[0] || rest != want[1] {
+			t.Errorf("%q: got %q %q, want %q", line, kind, rest, want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	txt := "### Audio [when:battery]\n## Audio\nwireplumber [wireplumber] [user]\n# docker [docker] [group:docker]\n# intel-ucode [only:cpu=intel]\ncom.saivert.pwvucontrol [source:flatpak]\n\n## Bluetooth [when:bluetooth]\nbluez [bluetooth]\n"
+	flatpak := &fstest.MapFile{Data: []byte("### Apps\n")}
+	configFS = fstest.MapFS{"configs/packages/01-audio.txt": {Data: []byte(txt)}, "configs/flatpak/01-apps.txt": flatpak}
+	want, err := ReadCategories("configs/packages")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, format := range []string{FormatTOML, FormatYAML} {
+		data, err := Convert([]byte(txt), "packages", format)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		configFS = fstest.MapFS{"configs/packages/01-audio." + format: {Data: data}, "configs/flatpak/01-apps.txt": flatpak}
+		got, err := ReadCategories("configs/packages")
+		if err != nil {
+			t.Fatalf("%s: converted file does not read back: %v\n%s", format, err, data)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: converted file reads as %+v, want %+v\n%s", format, got, want, data)
+		}
+	}
+
+	data, err := Convert([]byte("### Development\n# staticcheck [2024.1.1]\n"), "go", FormatTOML)
+	if err != nil || !strings.Contains(string(data), `options = ["2024.1.1"]`) {
+		t.Errorf("expected annotations of other sources to be options, got %v\n%s", err, data)
+	}
+	if _, err := Convert([]byte("### A\n@if wifi\nx\n@end\n"), "packages", FormatTOML); err == nil || !strings.Contains(err.Error(), "line 2: @if wifi cannot be converted") {
+		t.Errorf("expected directives to be refused, got %v", err)
+	}
+	if _, err := Convert([]byte("### A\n"), "packages", "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/fcarp10/archutils/internal/hardware"
+)
+
+// Env is what the conditional blocks of category files are evaluated
+// against.
+type Env struct {
+	Hostname string
+	// Profile is the profile selected with --profile or ARCHUTILS_PROFILE.
+	Profile  string
+	Hardware hardware.Info
+	// Getenv looks up environment variables; nil looks up none.
+	Getenv func(string) string
+}
+
+var env Env
+
+// SetEnv sets what conditional blocks are evaluated against.
+func SetEnv(e Env) {
+	env = e
+}
+
+// Directives of category files. Blocks are kept or dropped when the file is
+// read, e.g.
+//
+//	@if profile=hyprland hostname=laptop,desktop
+//	hyprland
+//	@elif env:WAYLAND_DISPLAY
+//	niri
+//	@else
+//	@include shared/x11.txt
+//	@end
+//
+// A condition holds when all its space separated terms do; a term holds when
+// its value is one of the comma separated values, and "!" negates it. An
+// included path is relative to the including file.
+const (
+	directiveIf      = "@if"
+	directiveElif    = "@elif"
+	directiveElse    = "@else"
+	directiveEnd     = "@end"
+	directiveInclude = "@include"
+)
+
+// hardwareKeys lists the hardware condition keys in errors; they are also
+// the features of [when:] and [only:] annotations.
+var hardwareKeys = []string{hardware.Battery, hardware.GPU + "=VENDOR", hardware.CPU + "=VENDOR", hardware.Bluetooth, hardware.WiFi, hardware.Printer}
+
+// conditionKeys lists the condition keys in errors.
+var conditionKeys = slices.Concat([]string{"hostname=NAME", "profile=NAME", "env:VAR", "env:VAR=VALUE"}, hardwareKeys)
+
+// block is an @if block being read.
+type block struct {
+	line int
+	// parentActive is set when the lines around the block are kept.
+	parentActive bool
+	// taken is set once a branch of the block was kept.
+	taken bool
+	// active is set while the lines of the current branch are kept.
+	active bool
+	inElse bool
+}
+
+// expandLines returns the lines of a category file with its includes
+// expanded and its conditional blocks evaluated. stack holds the files
+// including it, to report include cycles.
+func expandLines(filePath string, stack []string) ([]string, error) {
+	if slices.Contains(stack, filePath) {
+		return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), filePath)
+	}
+	file, err := configFS.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stack = append(stack, filePath)
+
+	var lines []string
+	var blocks []block
+	active := func() bool { return len(blocks) == 0 || blocks[len(blocks)-1].active }
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, "@") {
+			if active() {
+				lines = append(lines, text)
+			}
+			continue
+		}
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", filePath, n, fmt.Sprintf(format, args...))
+		}
+		directive, arg, _ := strings.Cut(text, " ")
+		arg = strings.TrimSpace(arg)
+		switch directive {
+		case directiveIf:
+			// The condition is checked even inside a dropped block, so that
+			// typos fail on every machine.
+			holds, err := env.holds(arg)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			b := block{line: n, parentActive: active()}
+			b.active = b.parentActive && holds
+			b.taken = b.active
+			blocks = append(blocks, b)
+		case directiveElif, directiveElse:
+			if len(blocks) == 0 {
+				return nil, errorf("%s without @if", directive)
+			}
+			b := &blocks[len(blocks)-1]
+			if b.inElse {
+				return nil, errorf("%s after @else of the block at line %d", directive, b.line)
+			}
+			holds := true
+			if directive == directiveElif {
+				var err error
+				if holds, err = env.holds(arg); err != nil {
+					return nil, errorf("%v", err)
+				}
+			} else if arg != "" {
+				return nil, errorf("@else takes no condition")
+			}
+			b.inElse = directive == directiveElse
+			b.active = b.parentActive && !b.taken && holds
+			b.taken = b.taken || b.active
+		case directiveEnd:
+			if len(blocks) == 0 {
+				return nil, errorf("@end without @if")
+			}
+			blocks = blocks[:len(blocks)-1]
+		case directiveInclude:
+			if arg == "" {
+				return nil, errorf("@include needs a path")
+			}
+			if !active() {
+				continue
+			}
+			included, err := expandLines(path.Join(path.Dir(filePath), arg), stack)
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+			for _, line := range included {
+				if strings.HasPrefix(line, "###") {
+					return nil, errorf("included file %s has a ### header", arg)
+				}
+			}
+			lines = append(lines, included...)
+		default:
+			return nil, errorf("unknown directive %s", directive)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(blocks) > 0 {
+		return nil, fmt.Errorf("%s:%d: @if without @end", filePath, blocks[len(blocks)-1].line)
+	}
+	return lines, nil
+}
+
+// holds evaluates the condition of an @if or @elif directive. Every term is
+// checked, also after one that does not hold.
+func (e Env) holds(condition string) (bool, error) {
+	terms := strings.Fields(condition)
+	if len(terms) == 0 {
+		return false, fmt.Errorf("missing condition, expected one of %s", strings.Join(conditionKeys, ", "))
+	}
+	all := true
+	for _, term := range terms {
+		holds, err := e.holdsTerm(term)
+		if err != nil {
+			return false, err
+		}
+		all = all && holds
+	}
+	return all, nil
+}
+
+func (e Env) holdsTerm(term string) (bool, error) {
+	negated, negate := strings.CutPrefix(term, "!")
+	key, value, hasValue := strings.Cut(negated, "=")
+	values := strings.Split(value, ",")
+	if hasValue && slices.Contains(values, "") {
+		return false, fmt.Errorf("empty value in condition %q", term)
+	}
+
+	var holds bool
+	switch {
+	case key == "hostname" || key == "profile":
+		if !hasValue {
+			return false, fmt.Errorf("condition %q needs a value, e.g. %s=NAME", term, key)
+		}
+		actual := e.Hostname
+		if key == "profile" {
+			actual = e.Profile
+		}
+		holds = slices.Contains(values, actual)
+	case strings.HasPrefix(key, "env:"):
+		name := strings.TrimPrefix(key, "env:")
+		if name == "" {
+			return false, fmt.Errorf("condition %q needs a variable name, e.g. env:VAR", term)
+		}
+		var actual string
+		if e.Getenv != nil {
+			actual = e.Getenv(name)
+		}
+		holds = actual != ""
+		if hasValue {
+			holds = slices.Contains(values, actual)
+		}
+	default:
+		if !hasValue {
+			values = []string{""}
+		}
+		for _, v := range values {
+			has, known := e.Hardware.Has(key, v)
+			if !known {
+				return false, fmt.Errorf("undefined condition %q, expected one of %s", term, strings.Join(conditionKeys, ", "))
+			}
+			holds = holds || has
+		}
+	}
+	return holds != negate, nil
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of structured category files, by extension.
+const (
+	FormatTOML = "toml"
+	FormatYAML = "yaml"
+)
+
+// categoryExts maps the extensions of category files to their formats; ""
+// is the ###/##/# text format.
+var categoryExts = map[string]string{
+	".txt":  "",
+	".toml": FormatTOML,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+}
+
+// categoryFile is the schema of .toml and .yaml category files, e.g.
+//
+//	name = "Containers"
+//	description = "Container runtimes and tools"
+//	order = 6
+//
+//	[[items]]
+//	name = "docker"
+//	description = "Pack, ship and run applications as containers"
+//	services = ["docker"]
+//	options = ["group:docker"]
+//	tags = ["containers"]
+//	selected = false
+//
+//	[[items]]
+//	name = "io.podman_desktop.PodmanDesktop"
+//	source = "flatpak"
+//
+// Items install through the source of the directory the file is in, unless
+// source names the kind of another one, for the whole category or an item.
+//
+// when and only hold the conditions of [when:...] and [only:...]
+// annotations, e.g. when = ["battery", "!gpu=nvidia"].
+type categoryFile struct {
+	Name        string      `toml:"name" yaml:"name"`
+	Description string      `toml:"description,omitempty" yaml:"description,omitempty"`
+	Order       int         `toml:"order,omitzero" yaml:"order,omitempty"`
+	Source      string      `toml:"source,omitempty" yaml:"source,omitempty"`
+	When        []string    `toml:"when,omitempty" yaml:"when,omitempty"`
+	Only        []string    `toml:"only,omitempty" yaml:"only,omitempty"`
+	Items       []itemEntry `toml:"items" yaml:"items"`
+}
+
+type itemEntry struct {
+	Name        string `toml:"name" yaml:"name"`
+	Description string `toml:"description,omitempty" yaml:"description,omitempty"`
+	// Services are enabled after the item is installed, like [service]
+	// annotations of package lines.
+	Services []string `toml:"services,omitempty" yaml:"services,omitempty"`
+	// Options are the other annotations the source interprets, e.g. user,
+	// asdeps, group:docker or a version.
+	Options []string `toml:"options,omitempty" yaml:"options,omitempty"`
+	Tags    []string `toml:"tags,omitempty" yaml:"tags,omitempty"`
+	// Selected is whether the item is preselected, true when unset.
+	Selected *bool `toml:"selected,omitempty" yaml:"selected,omitempty"`
+	// Source is the kind of the source installing the item, the category's
+	// when unset.
+	Source string   `toml:"source,omitempty" yaml:"source,omitempty"`
+	When   []string `toml:"when,omitempty" yaml:"when,omitempty"`
+	Only   []string `toml:"only,omitempty" yaml:"only,omitempty"`
+}
+
+// optionAnnotations are the annotations of package lines that are not
+// services, see scripts.parsePackageLine. Prefixes end with ":".
+var optionAnnotations = []string{"user", "asdeps", "replace:", "provider:", "group:"}
+
+// readStructuredFile reads a .toml or .yaml category file of a kind's
+// directory.
+func readStructuredFile(filePath, format, kind string) (Category, int, error) {
+	file, err := configFS.Open(filePath)
+	if err != nil {
+		return Category{}, 0, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return Category{}, 0, err
+	}
+
+	var cf categoryFile
+	switch format {
+	case FormatTOML:
+		md, err := toml.Decode(string(data), &cf)
+		if err != nil {
+			return Category{}, 0, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return Category{}, 0, fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cf); err != nil && err != io.EOF {
+			return Category{}, 0, err
+		}
+	}
+	category, err := cf.category(kind)
+	return category, cf.Order, err
+}
+
+// category validates the file of a kind's directory and returns its
+// category, with the items as the lines of a .txt file would give them.
+// Items of another source get a [source:<kind>] annotation, see ItemSource.
+func (cf categoryFile) category(kind string) (Category, error) {
+	if strings.TrimSpace(cf.Name) == "" {
+		return Category{}, fmt.Errorf("missing category name")
+	}
+	if cf.Source != "" {
+		if err := checkSourceKind(cf.Source); err != nil {
+			return Category{}, err
+		}
+	}
+	conditions, err := parseConditionLists(cf.When, cf.Only)
+	if err != nil {
+		return Category{}, err
+	}
+	category := Category{Name: cf.Name, Description: cf.Description, Conditions: conditions}
+	for i, entry := range cf.Items {
+		if len(strings.Fields(entry.Name)) != 1 || strings.ContainsAny(entry.Name, "#[]") {
+			return Category{}, fmt.Errorf("item %d: invalid name %q", i+1, entry.Name)
+		}
+		conditions, err := parseConditionLists(entry.When, entry.Only)
+		if err != nil {
+			return Category{}, fmt.Errorf("item %s: %v", entry.Name, err)
+		}
+		source := cmp.Or(entry.Source, cf.Source, kind)
+		if entry.Source != "" {
+			if err := checkSourceKind(entry.Source); err != nil {
+				return Category{}, fmt.Errorf("item %s: %v", entry.Name, err)
+			}
+		}
+		fields := []string{entry.Name}
+		if source != kind {
+			fields = append(fields, "["+sourceAnnotation+source+"]")
+		}
+		for _, annotation := range slices.Concat(entry.Services, entry.Options) {
+			if annotation == "" || strings.ContainsAny(annotation, " []") {
+				return Category{}, fmt.Errorf("item %s: invalid service or option %q", entry.Name, annotation)
+			}
+			fields = append(fields, "["+annotation+"]")
+		}
+		line := strings.Join(fields, " ")
+		if entry.Selected != nil && !*entry.Selected {
+			line = "# " + line
+		}
+		category.Items = append(category.Items, Item{
+			Name:        line,
+			Description: entry.Description,
+			Tags:        entry.Tags,
+			Conditions:  conditions,
+		})
+	}
+	return category, nil
+}
+
+// parseConditionLists parses the when and only lists of a structured file.
+func parseConditionLists(when, only []string) ([]Condition, error) {
+	var conditions []Condition
+	for _, list := range []struct {
+		annotation string
+		values     []string
+	}{{whenAnnotation, when}, {onlyAnnotation, only}} {
+		for _, value := range list.values {
+			c, ok := parseCondition("[" + list.annotation + value + "]")
+			if !ok || strings.ContainsAny(value, " []") {
+				return nil, fmt.Errorf("invalid condition %q", list.annotation+value)
+			}
+			conditions = append(conditions, c)
+		}
+	}
+	return conditions, checkConditions(conditions)
+}
+
+// Convert converts a .txt category file of the kind's directory to format.
+// ## comments are dropped, and files with @ directives are refused as
+// structured files have none.
+func Convert(data []byte, kind, format string) ([]byte, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "@") {
+			return nil, fmt.Errorf("line %d: %s cannot be converted, structured files have no directives", n, text)
+		}
+		lines = append(lines, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	header, itemLines := parseCategoryLines(lines)
+
+	var cf categoryFile
+	var conditions []Condition
+	cf.Name, conditions = splitConditions(header)
+	cf.When, cf.Only = conditionLists(conditions)
+	for _, line := range itemLines {
+		line, conditions := splitConditions(line)
+		var entry itemEntry
+		if rest, ok := strings.CutPrefix(line, "#"); ok {
+			line = strings.TrimSpace(rest)
+			entry.Selected = new(bool)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry.Name = fields[0]
+		for _, field := range fields[1:] {
+			annotation := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
+			if source, ok := strings.CutPrefix(annotation, sourceAnnotation); ok {
+				if source != kind {
+					entry.Source = source
+				}
+				continue
+			}
+			if kind == "packages" && !isOption(annotation) {
+				entry.Services = append(entry.Services, annotation)
+			} else {
+				entry.Options = append(entry.Options, annotation)
+			}
+		}
+		entry.When, entry.Only = conditionLists(conditions)
+		cf.Items = append(cf.Items, entry)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatTOML:
+		encoder := toml.NewEncoder(&buf)
+		encoder.Indent = ""
+		if err := encoder.Encode(cf); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(cf); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q (expected %s or %s)", format, FormatTOML, FormatYAML)
+	}
+	return buf.Bytes(), nil
+}
+
+func isOption(annotation string) bool {
+	for _, option := range optionAnnotations {
+		if annotation == option || (strings.HasSuffix(option, ":") && strings.HasPrefix(annotation, option)) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionLists is the inverse of parseConditionLists.
+func conditionLists(conditions []Condition) (when, only []string) {
+	for _, c := range conditions {
+		value := strings.TrimSuffix(strings.TrimPrefix(c.String(), "["), "]")
+		if c.Hide {
+			only = append(only, strings.TrimPrefix(value, onlyAnnotation))
+		} else {
+			when = append(when, strings.TrimPrefix(value, whenAnnotation))
+		}
+	}
+	return when, only
+}
@@ -0,0 +1,233 @@
+// Package hardware detects the hardware of the machine from sysfs and procfs,
+// so that items only useful on some machines are recommended there only.
+package hardware
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// root is where sysfs and procfs are looked up. Tests point it at a fixture.
+var root = "/"
+
+// SetRoot overrides the directory /sys and /proc are read from.
+func SetRoot(path string) {
+	root = path
+}
+
+// Root returns the directory /sys and /proc are read from.
+func Root() string {
+	return root
+}
+
+// Detectable features.
+const (
+	Battery   = "battery"
+	GPU       = "gpu"
+	CPU       = "cpu"
+	Bluetooth = "bluetooth"
+	WiFi      = "wifi"
+	Printer   = "printer"
+)
+
+// Vendors of GPUs and CPUs.
+const (
+	AMD    = "amd"
+	Intel  = "intel"
+	Nvidia = "nvidia"
+)
+
+// pciVendors maps PCI vendor IDs to vendors.
+var pciVendors = map[string]string{
+	"0x1002": AMD,
+	"0x1022": AMD,
+	"0x8086": Intel,
+	"0x10de": Nvidia,
+}
+
+// featureNames are the features as written in messages.
+var featureNames = map[string]string{
+	Battery:   "battery",
+	GPU:       "GPU",
+	CPU:       "CPU",
+	Bluetooth: "Bluetooth adapter",
+	WiFi:      "Wi-Fi adapter",
+	Printer:   "USB printer",
+}
+
+// vendorNames are the vendors as written in messages.
+var vendorNames = map[string]string{
+	AMD:    "AMD",
+	Intel:  "Intel",
+	Nvidia: "NVIDIA",
+}
+
+// cpuVendors maps the vendor_id of /proc/cpuinfo to vendors.
+var cpuVendors = map[string]string{
+	"GenuineIntel": Intel,
+	"AuthenticAMD": AMD,
+}
+
+// Info is the detected hardware.
+type Info struct {
+	Battery bool
+	// GPUs are the vendors of the display controllers, without duplicates.
+	GPUs []string
+	// CPU is the CPU vendor, or "" when unknown.
+	CPU       string
+	Bluetooth bool
+	WiFi      bool
+	// Printer is set when a USB printer is connected.
+	Printer bool
+}
+
+// Detect reads the hardware from sysfs and procfs. What cannot be read is
+// reported as absent.
+func Detect() Info {
+	return Info{
+		Battery:   detectBattery(),
+		GPUs:      detectGPUs(),
+		CPU:       detectCPU(),
+		Bluetooth: len(entries("/sys/class/bluetooth")) > 0,
+		WiFi:      detectWiFi(),
+		Printer:   detectPrinter(),
+	}
+}
+
+// Has reports whether the hardware has the feature, of the vendor value when
+// it is not "". known is false for features that are not detected.
+func (i Info) Has(feature, value string) (has, known bool) {
+	switch feature {
+	case Battery:
+		return i.Battery, true
+	case GPU:
+		if value == "" {
+			return len(i.GPUs) > 0, true
+		}
+		return slices.Contains(i.GPUs, value), true
+	case CPU:
+		if value == "" {
+			return i.CPU != "", true
+		}
+		return i.CPU == value, true
+	case Bluetooth:
+		return i.Bluetooth, true
+	case WiFi:
+		return i.WiFi, true
+	case Printer:
+		return i.Printer, true
+	}
+	return false, false
+}
+
+// Describe names a feature in messages, e.g. "AMD GPU" or "battery".
+func Describe(feature, value string) string {
+	name, ok := featureNames[feature]
+	if !ok {
+		name = feature
+	}
+	if value == "" {
+		return name
+	}
+	vendor, ok := vendorNames[value]
+	if !ok {
+		vendor = value
+	}
+	return vendor + " " + name
+}
+
+// path maps an absolute path to the detection root.
+func path(p string) string {
+	return filepath.Join(root, p)
+}
+
+// entries returns the names in a directory, or nil when it cannot be read.
+func entries(dir string) []string {
+	list, err := os.ReadDir(path(dir))
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(list))
+	for i, entry := range list {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+// readAttr returns the trimmed content of a sysfs attribute, or "".
+func readAttr(p string) string {
+	data, err := os.ReadFile(path(p))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// detectBattery looks for a system battery. Batteries of peripherals such as
+// wireless mice have the Device scope.
+func detectBattery() bool {
+	for _, supply := range entries("/sys/class/power_supply") {
+		dir := "/sys/class/power_supply/" + supply
+		if readAttr(dir+"/type") == "Battery" && readAttr(dir+"/scope") != "Device" {
+			return true
+		}
+	}
+	return false
+}
+
+// detectGPUs returns the vendors of the PCI display controllers, class 0x03.
+func detectGPUs() []string {
+	var vendors []string
+	for _, dev := range entries("/sys/bus/pci/devices") {
+		dir := "/sys/bus/pci/devices/" + dev
+		if !strings.HasPrefix(readAttr(dir+"/class"), "0x03") {
+			continue
+		}
+		if vendor, ok := pciVendors[readAttr(dir+"/vendor")]; ok && !slices.Contains(vendors, vendor) {
+			vendors = append(vendors, vendor)
+		}
+	}
+	return vendors
+}
+
+func detectCPU() string {
+	f, err := os.Open(path("/proc/cpuinfo"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "vendor_id" {
+			return cpuVendors[strings.TrimSpace(value)]
+		}
+	}
+	return ""
+}
+
+// detectWiFi looks for network interfaces with a wireless extension or
+// a cfg80211 phy.
+func detectWiFi() bool {
+	for _, iface := range entries("/sys/class/net") {
+		for _, sub := range []string{"wireless", "phy80211"} {
+			if _, err := os.Stat(path("/sys/class/net/" + iface + "/" + sub)); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detectPrinter looks for USB interfaces of the printer class, 07.
+func detectPrinter() bool {
+	for _, dev := range entries("/sys/bus/usb/devices") {
+		if readAttr("/sys/bus/usb/devices/"+dev+"/bInterfaceClass") == "07" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package hardware
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeFixture creates files under a temporary root and points detection at
+// it. Paths ending in / are created as directories.
+func writeFixture(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prev := Root()
+	SetRoot(dir)
+	t.Cleanup(func() { SetRoot(prev) })
+}
+
+func TestDetect_Laptop(t *testing.T) {
+	writeFixture(t, map[string]string{
+		"sys/class/power_supply/AC/type":              "Mains\n",
+		"sys/class/power_supply/BAT0/type":            "Battery\n",
+		"sys/bus/pci/devices/0000:00:02.0/class":      "0x030000\n",
+		"sys/bus/pci/devices/0000:00:02.0/vendor":     "0x8086\n",
+		"sys/bus/pci/devices/0000:01:00.0/class":      "0x030200\n",
+		"sys/bus/pci/devices/0000:01:00.0/vendor":     "0x10de\n",
+		"sys/bus/pci/devices/0000:00:14.0/class":      "0x0c0330\n",
+		"sys/bus/pci/devices/0000:00:14.0/vendor":     "0x1002\n",
+		"sys/class/net/wlan0/wireless/":               "",
+		"sys/class/net/lo/type":                       "772\n",
+		"sys/class/bluetooth/hci0/":                   "",
+		"sys/bus/usb/devices/1-1:1.0/bInterfaceClass": "03\n",
+		"proc/cpuinfo": "processor\t: 0\nvendor_id\t: GenuineIntel\ncpu family\t: 6\n",
+	})
+	hw := Detect()
+	want := Info{Battery: true, GPUs: []string{Intel, Nvidia}, CPU: Intel, Bluetooth: true, WiFi: true}
+	if hw.Battery != want.Battery || !slices.Equal(hw.GPUs, want.GPUs) || hw.CPU != want.CPU ||
+		hw.Bluetooth != want.Bluetooth || hw.WiFi != want.WiFi || hw.Printer {
+		t.Errorf("Detect() = %+v, want %+v", hw, want)
+	}
+}
+
+func TestDetect_Desktop(t *testing.T) {
+	writeFixture(t, map[string]string{
+		"sys/class/power_supply/hidpp_battery_0/type":  "Battery\n",
+		"sys/class/power_supply/hidpp_battery_0/scope": "Device\n",
+		"sys/bus/pci/devices/0000:03:00.0/class":       "0x030000\n",
+		"sys/bus/pci/devices/0000:03:00.0/vendor":      "0x1002\n",
+		"sys/class/net/enp4s0/type":                    "1\n",
+		"sys/bus/usb/devices/3-2:1.0/bInterfaceClass":  "07\n",
+		"proc/cpuinfo": "vendor_id\t: AuthenticAMD\n",
+	})
+	hw := Detect()
+	if hw.Battery {
+		t.Error("expected the battery of a peripheral not to count")
+	}
+	if !slices.Equal(hw.GPUs, []string{AMD}) || hw.CPU != AMD || hw.WiFi || hw.Bluetooth || !hw.Printer {
+		t.Errorf("unexpected hardware %+v", hw)
+	}
+}
+
+func TestDetect_Empty(t *testing.T) {
+	writeFixture(t, nil)
+	if hw := Detect(); hw.Battery || hw.GPUs != nil || hw.CPU != "" || hw.WiFi || hw.Bluetooth || hw.Printer {
+		t.Errorf("expected nothing to be detected, got %+v", hw)
+	}
+}
+
+func TestHas(t *testing.T) {
+	hw := Info{GPUs: []string{AMD}, CPU: Intel, WiFi: true}
+	tests := []struct {
+		feature, value string
+		has, known     bool
+	}{
+		{GPU, "", true, true},
+		{GPU, AMD, true, true},
+		{GPU, Nvidia, false, true},
+		{CPU, Intel, true, true},
+		{CPU, AMD, false, true},
+		{WiFi, "", true, true},
+		{Battery, "", false, true},
+		{"tpm", "", false, false},
+	}
+	for _, tt := range tests {
+		if has, known := hw.Has(tt.feature, tt.value); has != tt.has || known != tt.known {
+			t.Errorf("Has(%q, %q) = %v, %v, want %v, %v", tt.feature, tt.value, has, known, tt.has, tt.known)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := map[[2]string]string{
+		{GPU, AMD}:    "AMD GPU",
+		{GPU, Nvidia}: "NVIDIA GPU",
+		{CPU, Intel}:  "Intel CPU",
+		{Battery, ""}: "battery",
+		{WiFi, ""}:    "Wi-Fi adapter",
+		{"tpm", "v2"}: "v2 tpm",
+	}
+	for in, want := range tests {
+		if got := Describe(in[0], in[1]); got != want {
+			t.Errorf("Describe(%q, %q) = %q, want %q", in[0], in[1], got, want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package history reads pacman's transaction log and ties its transactions
+// to the archutils runs that caused them.
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fcarp10/archutils/internal/session"
+)
+
+// logPath is pacman's log file. Tests point it at a fixture.
+var logPath = "/var/log/pacman.log"
+
+// SetLogPath overrides the location of pacman's log file.
+func SetLogPath(path string) {
+	logPath = path
+}
+
+// LogPath returns the location of pacman's log file.
+func LogPath() string {
+	return logPath
+}
+
+// Package change actions, as pacman logs them.
+const (
+	Installed   = "installed"
+	Upgraded    = "upgraded"
+	Downgraded  = "downgraded"
+	Reinstalled = "reinstalled"
+	Removed     = "removed"
+)
+
+// Change is a package a transaction installed, upgraded or removed.
+type Change struct {
+	Action string
+	Name   string
+	// From is the previous version of upgrades and downgrades.
+	From string
+	// To is the version installed, or the version removed.
+	To string
+}
+
+func (c Change) String() string {
+	if c.From != "" {
+		return fmt.Sprintf("%s %s -> %s", c.Name, c.From, c.To)
+	}
+	return fmt.Sprintf("%s %s", c.Name, c.To)
+}
+
+// Transaction is a pacman transaction and the command that ran it.
+type Transaction struct {
+	Time    time.Time
+	Command string
+	Changes []Change
+}
+
+var (
+	logLineRe = regexp.MustCompile(`^\[([^\]]+)\] \[([^\]]+)\] (.*)$`)
+	changeRe  = regexp.MustCompile(`^(installed|upgraded|downgraded|reinstalled|removed) (\S+) \((.*)\)$`)
+)
+
+// logTimeLayouts are the timestamp formats of pacman's log: ISO 8601 with
+// seconds since pacman 5.2, minutes in local time before.
+var logTimeLayouts = []string{"2006-01-02T15:04:05-0700", "2006-01-02 15:04"}
+
+func parseLogTime(s string) (time.Time, bool) {
+	for _, layout := range logTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parse reads pacman log lines into transactions, oldest first. Transactions
+// that changed no package are dropped.
+func Parse(r io.Reader) ([]Transaction, error) {
+	var transactions []Transaction
+	var current *Transaction
+	var command string
+	finish := func() {
+		if current != nil && len(current.Changes) > 0 {
+			transactions = append(transactions, *current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		match := logLineRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		when, ok := parseLogTime(match[1])
+		if !ok {
+			continue
+		}
+		source, message := match[2], match[3]
+		switch {
+		case source == "PACMAN" && strings.HasPrefix(message, "Running '"):
+			command = strings.TrimSuffix(strings.TrimPrefix(message, "Running '"), "'")
+		case source != "ALPM":
+			// Hook output and other sources are not package changes.
+		case message == "transaction started":
+			finish()
+			current = &Transaction{Time: when, Command: command}
+		case message == "transaction completed" || message == "transaction failed":
+			finish()
+		default:
+			change := changeRe.FindStringSubmatch(message)
+			if change == nil {
+				continue
+			}
+			if current == nil {
+				// Logs of old pacman versions have no transaction markers.
+				current = &Transaction{Time: when, Command: command}
+			}
+			c := Change{Action: change[1], Name: change[2], To: change[3]}
+			if from, to, ok := strings.Cut(change[3], " -> "); ok {
+				c.From, c.To = from, to
+			}
+			current.Changes = append(current.Changes, c)
+		}
+	}
+	finish()
+	return transactions, scanner.Err()
+}
+
+// Load reads pacman's log on the system mounted at root, or the running one
+// when root is empty.
+func Load(root string) ([]Transaction, error) {
+	path := logPath
+	if root != "" {
+		path = filepath.Join(root, logPath)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Entry is an archutils run with its transactions, or a single transaction
+// archutils did not start.
+type Entry struct {
+	// Session is the archutils run, nil for other transactions.
+	Session      *session.Record
+	Transactions []Transaction
+}
+
+// Time returns when the entry started.
+func (e Entry) Time() time.Time {
+	if e.Session != nil {
+		return e.Session.StartedAt
+	}
+	return e.Transactions[0].Time
+}
+
+// Title names the entry in lists, e.g. "2026-10-19 14:02 archutils" or
+// "2026-10-19 15:30 pacman -Syu", shortening long commands.
+func (e Entry) Title() string {
+	when := e.Time().Local().Format("2006-01-02 15:04")
+	if e.Session != nil {
+		return when + " archutils"
+	}
+	command := strings.Fields(e.Transactions[0].Command)
+	if len(command) > 2 {
+		command = append(command[:2], "…")
+	}
+	return strings.TrimSpace(when + " " + strings.Join(command, " "))
+}
+
+// Changes returns the package changes of the entry's transactions in order.
+func (e Entry) Changes() []Change {
+	var changes []Change
+	for _, t := range e.Transactions {
+		changes = append(changes, t.Changes...)
+	}
+	return changes
+}
+
+// NewlyInstalled returns the packages the entry installed that were not
+// installed before it, leaving out upgrades and packages it removed again.
+func (e Entry) NewlyInstalled() []string {
+	var pkgs []string
+	for _, c := range e.Changes() {
+		switch c.Action {
+		case Installed:
+			if !slices.Contains(pkgs, c.Name) {
+				pkgs = append(pkgs, c.Name)
+			}
+		case Removed:
+			pkgs = slices.DeleteFunc(pkgs, func(p string) bool { return p == c.Name })
+		}
+	}
+	return pkgs
+}
+
+// sessionSlack widens session windows by the minute precision of older logs
+// and the time between a run's last transaction and its end being recorded.
+const sessionSlack = time.Minute
+
+// Group ties the transactions to the archutils runs that launched them and
+// returns the entries newest first. Runs without transactions, such as
+// Flatpak installs, are left out.
+func Group(transactions []Transaction, records []session.Record) []Entry {
+	var entries []Entry
+	sessions := make(map[int]int)
+	for _, t := range transactions {
+		idx := -1
+		for i, r := range records {
+			start := r.StartedAt.Truncate(time.Minute)
+			if !t.Time.Before(start) && !t.Time.After(r.FinishedAt.Add(sessionSlack)) && launchedBy(t, r) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			entries = append(entries, Entry{Transactions: []Transaction{t}})
+			continue
+		}
+		if e, ok := sessions[idx]; ok {
+			entries[e].Transactions = append(entries[e].Transactions, t)
+			continue
+		}
+		sessions[idx] = len(entries)
+		entries = append(entries, Entry{Session: &records[idx], Transactions: []Transaction{t}})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time().After(entries[j].Time())
+	})
+	return entries
+}
+
+// launchedBy reports whether the logged command of the transaction is one
+// the run launched: a system upgrade for upgrades, or a command naming one of
+// the run's packages. Other transactions during the run, e.g. from another
+// terminal, are not the run's.
+func launchedBy(t Transaction, r session.Record) bool {
+	args := strings.Fields(t.Command)
+	if len(args) == 0 {
+		return false
+	}
+	if r.Source == session.SourceUpgrade && sysupgrade(args[1:]) {
+		return true
+	}
+	pkgs := make(map[string]bool)
+	for _, result := range r.Results {
+		fields := strings.Fields(result.Item)
+		if len(fields) == 0 {
+			continue
+		}
+		pkgs[fields[0]] = true
+		// A chosen provider is installed in place of the item.
+		for _, field := range fields[1:] {
+			if provider, ok := strings.CutPrefix(field, "[provider:"); ok {
+				pkgs[strings.TrimSuffix(provider, "]")] = true
+			}
+		}
+	}
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") && pkgs[operandPackage(arg)] {
+			return true
+		}
+	}
+	return false
+}
+
+// sysupgrade reports whether pacman arguments run a system upgrade, -Su.
+func sysupgrade(args []string) bool {
+	var sync, upgrade bool
+	for _, arg := range args {
+		switch {
+		case arg == "--sync":
+			sync = true
+		case arg == "--sysupgrade":
+			upgrade = true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			sync = sync || strings.Contains(arg, "S")
+			upgrade = upgrade || strings.Contains(arg, "u")
+		}
+	}
+	return sync && upgrade
+}
+
+// operandPackage returns the package name of a pacman operand: a package
+// name, repo/name, or a package file such as the ones AUR helpers build,
+// e.g. /tmp/yay/yay-12.3.5-1-x86_64.pkg.tar.zst.
+func operandPackage(arg string) string {
+	name := path.Base(arg)
+	file, _, isFile := strings.Cut(name, ".pkg.tar")
+	if !isFile {
+		return name
+	}
+	// Drop the version, release and architecture.
+	fields := strings.Split(file, "-")
+	if len(fields) <= 3 {
+		return file
+	}
+	return strings.Join(fields[:len(fields)-3], "-")
+}
@@ -0,0 +1,165 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcarp10/archutils/internal/session"
+)
+
+const fixture = `[2019-03-01 10:00] [PACMAN] Running 'pacman -S vim'
+[2019-03-01 10:00] [ALPM] installed vim (8.1.0-1)
+[2026-10-19T14:02:10+0000] [PACMAN] Running 'pacman -S --noconfirm --needed ripgrep'
+[2026-10-19T14:02:11+0000] [ALPM] transaction started
+[2026-10-19T14:02:12+0000] [ALPM] installed pcre2 (10.44-1)
+[2026-10-19T14:02:12+0000] [ALPM] installed ripgrep (14.1.1-1)
+[2026-10-19T14:02:12+0000] [ALPM-SCRIPTLET] some hook output
+[2026-10-19T14:02:13+0000] [ALPM] transaction completed
+[2026-10-19T14:03:00+0000] [PACMAN] Running 'pacman -S --noconfirm --needed fd'
+[2026-10-19T14:03:01+0000] [ALPM] transaction started
+[2026-10-19T14:03:02+0000] [ALPM] upgraded pcre2 (10.44-1 -> 10.45-1)
+[2026-10-19T14:03:02+0000] [ALPM] installed fd (10.2.0-1)
+[2026-10-19T14:03:03+0000] [ALPM] transaction completed
+[2026-10-19T15:30:00+0000] [PACMAN] Running 'pacman -Rns fd'
+[2026-10-19T15:30:01+0000] [ALPM] transaction started
+[2026-10-19T15:30:02+0000] [ALPM] removed fd (10.2.0-1)
+[2026-10-19T15:30:03+0000] [ALPM] transaction completed
+[2026-10-19T16:00:00+0000] [PACMAN] Running 'pacman -Syu'
+[2026-10-19T16:00:01+0000] [ALPM] transaction started
+[2026-10-19T16:00:02+0000] [ALPM] transaction failed
+`
+
+func TestParse(t *testing.T) {
+	transactions, err := Parse(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 4 {
+		t.Fatalf("expected 4 transactions with changes, got %d", len(transactions))
+	}
+	if got := transactions[0]; got.Command != "pacman -S vim" || got.Time.Year() != 2019 || got.Changes[0].String() != "vim 8.1.0-1" {
+		t.Errorf("unexpected transaction of the old log format %+v", got)
+	}
+	if got := transactions[1].Changes; len(got) != 2 || got[1].Name != "ripgrep" || got[1].Action != Installed {
+		t.Errorf("expected the hook output to be skipped, got %+v", got)
+	}
+	upgrade := transactions[2].Changes[0]
+	if upgrade.Action != Upgraded || upgrade.From != "10.44-1" || upgrade.To != "10.45-1" {
+		t.Errorf("unexpected upgrade %+v", upgrade)
+	}
+	if got := upgrade.String(); got != "pcre2 10.44-1 -> 10.45-1" {
+		t.Errorf("Change.String() = %q", got)
+	}
+	if got := transactions[3].Changes[0]; got.Action != Removed || got.To != "10.2.0-1" {
+		t.Errorf("unexpected removal %+v", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+	prev := LogPath()
+	SetLogPath("/var/log/pacman.log")
+	defer SetLogPath(prev)
+	path := filepath.Join(root, LogPath())
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	transactions, err := Load(root)
+	if err != nil || len(transactions) != 4 {
+		t.Fatalf("Load() = %d transactions, %v", len(transactions), err)
+	}
+	if _, err := Load(t.TempDir()); err == nil {
+		t.Error("expected a missing log to fail")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	transactions, _ := Parse(strings.NewReader(fixture))
+	started := time.Date(2026, 10, 19, 14, 2, 5, 0, time.UTC)
+	records := []session.Record{{
+		Source:     "packages",
+		StartedAt:  started,
+		FinishedAt: started.Add(50 * time.Second),
+		Results:    []session.Result{{Item: "ripgrep", Success: true}, {Item: "fd-find [provider:fd]", Success: true}},
+	}}
+	entries := Group(transactions, records)
+	if len(entries) != 3 {
+		t.Fatalf("expected the session and two other transactions, got %d entries", len(entries))
+	}
+	if entries[0].Session != nil || entries[0].Transactions[0].Command != "pacman -Rns fd" {
+		t.Errorf("expected the newest entry first, got %+v", entries[0])
+	}
+	session := entries[1]
+	if session.Session == nil || len(session.Transactions) != 2 {
+		t.Fatalf("expected both transactions within the session window to be grouped, got %+v", session)
+	}
+	if got, want := session.NewlyInstalled(), []string{"pcre2", "ripgrep", "fd"}; !slices.Equal(got, want) {
+		t.Errorf("NewlyInstalled() = %v, want %v", got, want)
+	}
+	if got := session.Title(); !strings.HasSuffix(got, " archutils") {
+		t.Errorf("unexpected session title %q", got)
+	}
+	if got := entries[0].Title(); !strings.HasSuffix(got, " pacman -Rns …") {
+		t.Errorf("unexpected transaction title %q", got)
+	}
+}
+
+func TestGroup_OnlyLaunchedCommands(t *testing.T) {
+	at := time.Date(2026, 10, 19, 14, 2, 0, 0, time.UTC)
+	transactions := []Transaction{
+		{Time: at, Command: "pacman -S --needed --noconfirm ripgrep", Changes: []Change{{Action: Installed, Name: "ripgrep"}}},
+		{Time: at.Add(5 * time.Second), Command: "pacman -S htop", Changes: []Change{{Action: Installed, Name: "htop"}}},
+		{Time: at.Add(10 * time.Second), Command: "pacman -U /home/u/.cache/paru/clone/paru-bin/paru-bin-2.0.4-1-x86_64.pkg.tar.zst", Changes: []Change{{Action: Installed, Name: "paru-bin"}}},
+		{Time: at.Add(20 * time.Second), Command: "pacman -Syu --noconfirm", Changes: []Change{{Action: Upgraded, Name: "linux"}}},
+		{Time: at.Add(25 * time.Second), Command: "pacman -S --noconfirm fd", Changes: []Change{{Action: Installed, Name: "fd"}}},
+	}
+	records := []session.Record{
+		{Source: "packages", StartedAt: at, FinishedAt: at.Add(15 * time.Second), Results: []session.Result{{Item: "ripgrep"}, {Item: "paru-bin [asdeps]"}}},
+		{Source: session.SourceUpgrade, StartedAt: at.Add(15 * time.Second), FinishedAt: at.Add(30 * time.Second)},
+	}
+	var got []string
+	for _, e := range Group(transactions, records) {
+		var pkgs []string
+		for _, c := range e.Changes() {
+			pkgs = append(pkgs, c.Name)
+		}
+		got = append(got, fmt.Sprintf("%v %s", e.Session != nil, strings.Join(pkgs, ",")))
+	}
+	want := []string{"false fd", "true linux", "false htop", "true ripgrep,paru-bin"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Group() = %q, want %q", got, want)
+	}
+}
+
+func TestOperandPackage(t *testing.T) {
+	for arg, want := range map[string]string{
+		"ripgrep":       "ripgrep",
+		"extra/ripgrep": "ripgrep",
+		"/tmp/yay/yay-12.3.5-1-x86_64.pkg.tar.zst":       "yay",
+		"visual-studio-code-bin-1.95.0-1-x86_64.pkg.tar": "visual-studio-code-bin",
+	} {
+		if got := operandPackage(arg); got != want {
+			t.Errorf("operandPackage(%q) = %q, want %q", arg, got, want)
+		}
+	}
+}
+
+func TestNewlyInstalled_RemovedAgain(t *testing.T) {
+	e := Entry{Transactions: []Transaction{{Changes: []Change{
+		{Action: Installed, Name: "a"},
+		{Action: Upgraded, Name: "b"},
+		{Action: Installed, Name: "c"},
+		{Action: Removed, Name: "a"},
+	}}}}
+	if got := e.NewlyInstalled(); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("NewlyInstalled() = %v, want [c]", got)
+	}
+}
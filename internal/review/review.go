@@ -0,0 +1,172 @@
+package review
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// storeDir holds one directory per package with the build files as last
+// approved. Tests point it at a temp dir.
+var storeDir = defaultDir()
+
+// defaultDir returns $XDG_DATA_HOME/archutils/reviews, falling back to
+// ~/.local/share when XDG_DATA_HOME is unset.
+func defaultDir() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "archutils", "reviews")
+}
+
+// SetDir overrides the location of the approval store.
+func SetDir(dir string) {
+	storeDir = dir
+}
+
+// Approved returns the build files of pkg as last approved, or nil if the
+// package was never reviewed.
+func Approved(pkg string) (map[string]string, error) {
+	entries, err := os.ReadDir(filepath.Join(storeDir, pkg))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(storeDir, pkg, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files, nil
+}
+
+// Approve records files as the approved build files of pkg, replacing any
+// earlier approval.
+func Approve(pkg string, files map[string]string) error {
+	dir := filepath.Join(storeDir, pkg)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(name)), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Op marks a diff line as unchanged, added or removed.
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Insert Op = '+'
+	Delete Op = '-'
+)
+
+// Line is one line of a diff.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Diff returns a line diff turning old into new, based on the longest common
+// subsequence of lines. Build files are small, so the quadratic table is fine.
+func Diff(old, new string) []Line {
+	a := splitLines(old)
+	b := splitLines(new)
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Delete, a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, Line{Delete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, Line{Insert, b[j]})
+	}
+	return lines
+}
+
+// Changed reports whether a diff contains any added or removed lines.
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// FileNames returns the union of file names in both sets, sorted with
+// PKGBUILD first.
+func FileNames(old, new map[string]string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, files := range []map[string]string{old, new} {
+		for name := range files {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "PKGBUILD" || names[j] == "PKGBUILD" {
+			return names[i] == "PKGBUILD"
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,74 @@
+package review
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := "pkgname=foo\npkgver=1.0\nbuild() {\n  make\n}\n"
+	new := "pkgname=foo\npkgver=1.1\nbuild() {\n  make\n}\npackage() {\n}\n"
+
+	lines := Diff(old, new)
+	var got string
+	for _, l := range lines {
+		got += string(l.Op) + l.Text + "\n"
+	}
+	want := " pkgname=foo\n-pkgver=1.0\n+pkgver=1.1\n build() {\n   make\n }\n+package() {\n+}\n"
+	if got != want {
+		t.Errorf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+	if !Changed(lines) {
+		t.Error("expected diff to report a change")
+	}
+	if Changed(Diff(old, old)) {
+		t.Error("expected identical input to report no change")
+	}
+}
+
+func TestDiff_NewFile(t *testing.T) {
+	lines := Diff("", "a\nb\n")
+	if len(lines) != 2 || lines[0].Op != Insert || lines[1].Op != Insert {
+		t.Errorf("expected two inserted lines, got %+v", lines)
+	}
+}
+
+func TestApproveAndLoad(t *testing.T) {
+	orig := storeDir
+	SetDir(t.TempDir())
+	defer SetDir(orig)
+
+	files, err := Approved("foo")
+	if err != nil || files != nil {
+		t.Fatalf("expected no approval yet, got %v, %v", files, err)
+	}
+
+	if err := Approve("foo", map[string]string{"PKGBUILD": "v1", "foo.install": "post_install() { :; }"}); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if err := Approve("foo", map[string]string{"PKGBUILD": "v2"}); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	files, err = Approved("foo")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(files) != 1 || files["PKGBUILD"] != "v2" {
+		t.Errorf("expected only the latest approval, got %v", files)
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	names := FileNames(
+		map[string]string{"foo.install": "", "PKGBUILD": ""},
+		map[string]string{"PKGBUILD": "", "bar.install": ""},
+	)
+	want := []string{"PKGBUILD", "bar.install", "foo.install"}
+	if len(names) != len(want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("expected %v, got %v", want, names)
+		}
+	}
+}
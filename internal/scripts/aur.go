@@ -0,0 +1,99 @@
+package scripts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// installVarPattern matches the install= variable of a PKGBUILD, quoted or not.
+var installVarPattern = regexp.MustCompile(`(?m)^\s*install=["']?([^"'\s]+)["']?`)
+
+// aurCloneURL returns the git URL of an AUR package base.
+func aurCloneURL(pkg string) string {
+	return fmt.Sprintf("https://aur.archlinux.org/%s.git", pkg)
+}
+
+// readBuildFiles returns the PKGBUILD of a cloned AUR repository together with
+// every .install script it references or ships.
+func readBuildFiles(dir string) (map[string]string, error) {
+	pkgbuild, err := os.ReadFile(filepath.Join(dir, "PKGBUILD"))
+	if err != nil {
+		return nil, fmt.Errorf("no PKGBUILD found: %w", err)
+	}
+	files := map[string]string{"PKGBUILD": string(pkgbuild)}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.install"))
+	for _, match := range installVarPattern.FindAllStringSubmatch(string(pkgbuild), -1) {
+		names = append(names, filepath.Join(dir, filepath.Base(match[1])))
+	}
+	for _, path := range names {
+		name := filepath.Base(path)
+		if _, ok := files[name]; ok {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// install= may use a variable we can't expand; the glob above
+			// still picks up the real file.
+			continue
+		}
+		files[name] = string(data)
+	}
+	return files, nil
+}
+
+// FetchBuildFiles clones the AUR repository of pkg into a temporary directory
+// and returns its PKGBUILD and .install files for review, with the commit
+// they are at. Approving them pins the build to that commit, see
+// WithApproval.
+func (r Runner) FetchBuildFiles(pkg string) (map[string]string, string, error) {
+	line, ok := parsePackageLine(pkg)
+	if !ok {
+		return nil, "", fmt.Errorf("invalid package string")
+	}
+	dir, err := os.MkdirTemp("", "archutils-review-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("git", "clone", "--depth", "1", aurCloneURL(line.name), dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("git clone failed: %v\n%s", err, strings.TrimSpace(string(output)))
+	}
+	files, err := readBuildFiles(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s is not an AUR package: %w", line.name, err)
+	}
+	commit, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("git rev-parse failed: %v", err)
+	}
+	return files, strings.TrimSpace(string(commit)), nil
+}
+
+// approvedAnnotation pins an AUR package line to the commit whose build files
+// were approved in the PKGBUILD review.
+const approvedAnnotation = "approved:"
+
+// WithApproval returns the package line pinned to the approved commit, so
+// that the build uses the reviewed build files rather than whatever the AUR
+// serves at install time.
+func WithApproval(item, commit string) string {
+	var fields []string
+	for _, field := range strings.Fields(item) {
+		if !strings.HasPrefix(field, "["+approvedAnnotation) {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(append(fields, "["+approvedAnnotation+commit+"]"), " ")
+}
+
+// validCommit reports whether s is a full git commit hash.
+func validCommit(s string) bool {
+	return len(s) == 40 && strings.Trim(s, "0123456789abcdef") == ""
+}
@@ -0,0 +1,323 @@
+package scripts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// AURHelper describes an AUR helper backend: how to invoke it for installs
+// and how to bootstrap it from the AUR when it is missing.
+type AURHelper struct {
+	Name string
+	// sudoFlag selects the privilege tool the helper calls, if it has one.
+	sudoFlag string
+	// buildDeps are the repo packages needed to build the helper with makepkg.
+	buildDeps []string
+	// hasBin is set when the AUR also carries a prebuilt <name>-bin package.
+	hasBin bool
+	// aurUpdatesArgs list the pending AUR updates, if the helper can.
+	aurUpdatesArgs []string
+	// localBuildArgs build and install the PKGBUILD in the current
+	// directory, resolving its dependencies like -S does.
+	localBuildArgs []string
+}
+
+// aurHelpers lists the supported helpers in auto-detection order.
+var aurHelpers = []AURHelper{
+	{Name: "paru", sudoFlag: "--sudo", buildDeps: []string{"base-devel", "git", "rust"}, hasBin: true, aurUpdatesArgs: []string{"-Qua"},
+		localBuildArgs: []string{"-Ui"}},
+	{Name: "yay", sudoFlag: "--sudo", buildDeps: []string{"base-devel", "git", "go"}, hasBin: true, aurUpdatesArgs: []string{"-Qua"},
+		localBuildArgs: []string{"-Bi", "."}},
+	{Name: "pikaur", buildDeps: []string{"base-devel", "git", "pyalpm", "python-markdown-it-py",
+		"python-build", "python-installer", "python-setuptools", "python-wheel"},
+		localBuildArgs: []string{"-P", "-i", "PKGBUILD"}},
+}
+
+// AURHelperNames returns the names of the supported helpers.
+func AURHelperNames() []string {
+	names := make([]string, len(aurHelpers))
+	for i, h := range aurHelpers {
+		names[i] = h.Name
+	}
+	return names
+}
+
+// LookupAURHelper returns the helper with the given name.
+func LookupAURHelper(name string) (AURHelper, bool) {
+	for _, h := range aurHelpers {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return AURHelper{}, false
+}
+
+// detectAURHelper picks the helper to use. An explicit name wins, then the
+// ARCHUTILS_AUR_HELPER environment variable, which main validates at
+// startup, then the first helper found on PATH. paru is the default when
+// none is installed yet.
+func detectAURHelper(name string) AURHelper {
+	if name == "" {
+		name = os.Getenv("ARCHUTILS_AUR_HELPER")
+	}
+	if h, ok := LookupAURHelper(name); ok {
+		return h
+	}
+	for _, h := range aurHelpers {
+		if _, err := exec.LookPath(h.Name); err == nil {
+			return h
+		}
+	}
+	return aurHelpers[0]
+}
+
+// HelperTitle capitalises a helper name for menu titles, e.g. "paru" → "Paru".
+func HelperTitle(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Installed reports whether the helper binary is on PATH.
+func (h AURHelper) Installed() bool {
+	_, err := exec.LookPath(h.Name)
+	return err == nil
+}
+
+// installedOn reports whether the helper is installed on the target.
+func (h AURHelper) installedOn(t target) bool {
+	if !t.chrooted() {
+		return h.Installed()
+	}
+	_, err := os.Stat(t.path("/usr/bin/" + h.Name))
+	return err == nil
+}
+
+// installCmd returns the non-interactive command installing the targets with
+// the helper on the target, see packageLine.targets. As root or in a mounted
+// target the build user builds the AUR packages instead of the helper, see
+// buildUserInstallCmd.
+func (h AURHelper) installCmd(t target, targets ...string) *exec.Cmd {
+	args := []string{"-S", "--needed", "--noconfirm"}
+	if t.usesBuildUser() {
+		asDeps := len(targets) > 0 && targets[0] == "--asdeps"
+		if asDeps {
+			targets = targets[1:]
+		}
+		return t.buildUserInstallCmd(targets, asDeps)
+	}
+	if tool := privilegeTool(); tool != PrivilegeSudo && h.sudoFlag != "" {
+		args = append(args, h.sudoFlag, tool)
+	}
+	return exec.Command(h.Name, append(args, targets...)...)
+}
+
+// approvedBuildCmd returns the command building and installing pkg from its
+// AUR repository checked out at the approved commit. The helper resolves the
+// dependencies; the build files are the ones approved in the review. As root
+// or in a mounted target the build user builds it, see buildUserApprovedCmd.
+func (h AURHelper) approvedBuildCmd(t target, pkg, commit string, asDeps bool) *exec.Cmd {
+	if t.usesBuildUser() {
+		return t.buildUserApprovedCmd(pkg, commit, asDeps)
+	}
+	args := append(slices.Clone(h.localBuildArgs), "--noconfirm")
+	if asDeps {
+		args = append(args, "--asdeps")
+	}
+	if tool := privilegeTool(); tool != PrivilegeSudo && h.sudoFlag != "" {
+		args = append(args, h.sudoFlag, tool)
+	}
+	script := fmt.Sprintf(`dir=$(mktemp -d) && trap 'rm -rf "$dir"' EXIT && git clone -q %s "$dir" && cd "$dir" && git checkout -q %s && %s %s`,
+		aurCloneURL(pkg), commit, h.Name, strings.Join(args, " "))
+	return exec.Command("sh", "-c", script)
+}
+
+// Bootstrap strategies for installing the AUR helper itself.
+const (
+	// BootstrapSource builds the helper from its AUR PKGBUILD.
+	BootstrapSource = "source"
+	// BootstrapBinary builds the prebuilt <helper>-bin AUR package.
+	BootstrapBinary = "binary"
+	// BootstrapLocalFile installs a local .pkg.tar.* file with pacman -U.
+	BootstrapLocalFile = "local file"
+	// BootstrapLocalRepo installs the helper from a repository in pacman.conf.
+	BootstrapLocalRepo = "local repo"
+)
+
+// BootstrapOptions selects how the AUR helper is installed.
+type BootstrapOptions struct {
+	Strategy string
+	// Path is the package file for BootstrapLocalFile.
+	Path string
+	// Repo optionally names the repository for BootstrapLocalRepo.
+	Repo string
+	// Commit pins AUR builds to the commit approved in the PKGBUILD review.
+	Commit string
+}
+
+// Validate checks that the options are complete for the chosen strategy.
+func (o BootstrapOptions) Validate(h AURHelper) error {
+	if o.Commit != "" && !validCommit(o.Commit) {
+		return fmt.Errorf("invalid approved commit %q", o.Commit)
+	}
+	switch o.Strategy {
+	case BootstrapSource, "":
+	case BootstrapBinary:
+		if !h.hasBin {
+			return fmt.Errorf("%s has no prebuilt -bin package", h.Name)
+		}
+	case BootstrapLocalFile:
+		if !strings.Contains(filepath.Base(o.Path), ".pkg.tar") {
+			return fmt.Errorf("%q is not a package file (*.pkg.tar.*)", o.Path)
+		}
+		if _, err := os.Stat(o.Path); err != nil {
+			return fmt.Errorf("cannot read package file: %v", err)
+		}
+	case BootstrapLocalRepo:
+		if strings.ContainsAny(o.Repo, "/ ") {
+			return fmt.Errorf("invalid repository name %q", o.Repo)
+		}
+	default:
+		return fmt.Errorf("unknown strategy %q", o.Strategy)
+	}
+	return nil
+}
+
+// BootstrapStrategies returns the strategies available for the helper.
+func (h AURHelper) BootstrapStrategies() []string {
+	strategies := []string{BootstrapSource}
+	if h.hasBin {
+		strategies = append(strategies, BootstrapBinary)
+	}
+	return append(strategies, BootstrapLocalFile, BootstrapLocalRepo)
+}
+
+// BootstrapPackage returns the AUR package built by the strategy, which is
+// also the package whose PKGBUILD can be reviewed. Local strategies build
+// nothing and return "".
+func (h AURHelper) BootstrapPackage(opts BootstrapOptions) string {
+	switch opts.Strategy {
+	case BootstrapSource, "":
+		return h.Name
+	case BootstrapBinary:
+		return h.Name + "-bin"
+	default:
+		return ""
+	}
+}
+
+// bootstrapStep is one resumable step of a helper installation.
+type bootstrapStep struct {
+	name string
+	cmd  func() *exec.Cmd
+}
+
+// bootstrapSteps returns the steps installing the helper on the target with
+// the given strategy. AUR builds clone the package, build it with makepkg and
+// install it as root; local strategies install directly. As root or in a
+// mounted target, cloning and building run as the build user, which is
+// prepared first.
+func (h AURHelper) bootstrapSteps(t target, opts BootstrapOptions) []bootstrapStep {
+	removeOld := bootstrapStep{
+		name: "Removing old " + h.Name,
+		cmd: func() *exec.Cmd {
+			variants := fmt.Sprintf("%s %s-bin %s-git", h.Name, h.Name, h.Name)
+			return t.shell(fmt.Sprintf(
+				"pkgs=$(pacman -Qq %s 2>/dev/null); if [ -n \"$pkgs\" ]; then pacman -Rns --noconfirm $pkgs; fi",
+				variants))
+		},
+	}
+
+	switch opts.Strategy {
+	case BootstrapLocalFile:
+		if !t.chrooted() {
+			return []bootstrapStep{removeOld, {
+				name: "Installing " + filepath.Base(opts.Path),
+				cmd:  func() *exec.Cmd { return privileged("pacman", "-U", "--noconfirm", opts.Path) },
+			}}
+		}
+		// The file lives on the host, so it is copied into the target's
+		// package cache before pacman inside the chroot can see it.
+		cached := "/var/cache/pacman/pkg/" + filepath.Base(opts.Path)
+		return []bootstrapStep{removeOld,
+			{
+				name: "Copying " + filepath.Base(opts.Path) + " into " + t.root,
+				cmd:  func() *exec.Cmd { return privileged("cp", opts.Path, t.path(cached)) },
+			},
+			{
+				name: "Installing " + filepath.Base(opts.Path),
+				cmd:  func() *exec.Cmd { return t.run("pacman", "-U", "--noconfirm", cached) },
+			},
+		}
+	case BootstrapLocalRepo:
+		target := h.Name
+		if opts.Repo != "" {
+			target = opts.Repo + "/" + h.Name
+		}
+		return []bootstrapStep{removeOld, {
+			name: "Installing " + target,
+			cmd:  func() *exec.Cmd { return t.run("pacman", "-S", "--needed", "--noconfirm", target) },
+		}}
+	}
+
+	pkg := h.BootstrapPackage(opts)
+	deps := h.buildDeps
+	if opts.Strategy == BootstrapBinary {
+		deps = []string{"base-devel", "git"}
+	}
+	dir := "/tmp/" + pkg
+	// unprivileged runs the clone and build steps as the invoking user, or
+	// as the build user as root and in a mounted target.
+	unprivileged := exec.Command
+	if t.usesBuildUser() {
+		dir = buildUserHome + "/" + pkg
+		unprivileged = t.asBuildUser
+	}
+
+	steps := []bootstrapStep{
+		removeOld,
+		{
+			name: "Installing build dependencies",
+			cmd: func() *exec.Cmd {
+				return t.run("pacman", append([]string{"-S", "--needed", "--noconfirm"}, deps...)...)
+			},
+		},
+	}
+	if t.usesBuildUser() {
+		steps = append(steps, bootstrapStep{
+			name: "Preparing build user " + buildUser,
+			cmd:  func() *exec.Cmd { return t.shell(buildUserSetupScript()) },
+		})
+	}
+	return append(steps,
+		bootstrapStep{
+			name: "Cloning repository",
+			cmd: func() *exec.Cmd {
+				script := fmt.Sprintf("rm -rf %s && git clone %s %s", dir, aurCloneURL(pkg), dir)
+				if opts.Commit != "" {
+					script += fmt.Sprintf(" && git -C %s checkout -q %s", dir, opts.Commit)
+				}
+				return unprivileged("sh", "-c", script)
+			},
+		},
+		bootstrapStep{
+			name: "Building " + pkg,
+			cmd: func() *exec.Cmd {
+				// Build only; installing runs as root in the next step instead
+				// of through makepkg's own sudo call.
+				return unprivileged("sh", "-c", fmt.Sprintf("cd %s && makepkg -f --noconfirm", dir))
+			},
+		},
+		bootstrapStep{
+			name: "Installing " + pkg,
+			cmd: func() *exec.Cmd {
+				return t.shell(fmt.Sprintf("pacman -U --noconfirm %s/%s-[0-9]*.pkg.tar.*", dir, pkg))
+			},
+		},
+	)
+}
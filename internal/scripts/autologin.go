@@ -0,0 +1,127 @@
+package scripts
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Session types offered by the autologin form. An empty type writes no
+// XDG_SESSION_TYPE line to the drop-in.
+var AutologinSessionTypes = []string{"wayland", "x11", "tty", ""}
+
+var (
+	ttyPattern     = regexp.MustCompile(`^tty[0-9]+$`)
+	envLinePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=[^\n]*$`)
+)
+
+// AutologinOptions describes the getty drop-in written by EnableAutologin.
+type AutologinOptions struct {
+	TTY         string
+	User        string
+	SessionType string
+	Environment []string
+}
+
+// Validate checks the options before anything is written to /etc.
+func (o AutologinOptions) Validate() error {
+	if !ttyPattern.MatchString(o.TTY) {
+		return fmt.Errorf("invalid tty %q (expected e.g. tty1)", o.TTY)
+	}
+	if o.User == "" {
+		return fmt.Errorf("no user selected")
+	}
+	for _, env := range o.Environment {
+		if !envLinePattern.MatchString(env) {
+			return fmt.Errorf("invalid environment line %q (expected KEY=VALUE)", env)
+		}
+	}
+	return nil
+}
+
+// ParseEnvironment splits the environment field of the autologin form into
+// KEY=VALUE entries. Entries are separated by whitespace; single or double
+// quotes keep spaces in a value, e.g. FOO="a b" BAR=c, and a backslash
+// escapes the next character outside single quotes.
+func ParseEnvironment(field string) ([]string, error) {
+	var entries []string
+	var entry strings.Builder
+	var quote rune
+	inEntry, escaped := false, false
+	for _, r := range field {
+		switch {
+		case escaped:
+			entry.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inEntry = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			entry.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inEntry = r, true
+		case r == ' ' || r == '\t':
+			if inEntry {
+				entries = append(entries, entry.String())
+				entry.Reset()
+				inEntry = false
+			}
+		default:
+			entry.WriteRune(r)
+			inEntry = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in environment %q", field)
+	}
+	if inEntry {
+		entries = append(entries, entry.String())
+	}
+	return entries, nil
+}
+
+// environmentLine returns the systemd Environment= line setting env. Values
+// with spaces, quotes or backslashes are quoted, and % is escaped since
+// systemd expands specifiers in the line.
+func environmentLine(env string) string {
+	env = strings.ReplaceAll(env, "%", "%%")
+	if strings.ContainsAny(env, " \t\"'\\") {
+		env = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(env) + `"`
+	}
+	return "Environment=" + env
+}
+
+// autologinDropInDir returns the getty drop-in directory for the given tty.
+func autologinDropInDir(tty string) string {
+	return fmt.Sprintf("/etc/systemd/system/getty@%s.service.d", tty)
+}
+
+// autologinDropInGlob matches every autologin drop-in archutils may have written.
+var autologinDropInGlob = filepath.Join("/etc/systemd/system", "getty@tty*.service.d", "autologin.conf")
+
+// renderAutologinConf fills the $TTY, $USER and $ENVIRONMENT placeholders of
+// the autologin.conf template. The $ENVIRONMENT line is dropped when there is
+// nothing to set.
+func renderAutologinConf(template string, opts AutologinOptions) string {
+	var envLines []string
+	if opts.SessionType != "" {
+		envLines = append(envLines, "Environment=XDG_SESSION_TYPE="+opts.SessionType)
+	}
+	for _, env := range opts.Environment {
+		envLines = append(envLines, environmentLine(env))
+	}
+
+	var out []string
+	for _, line := range strings.Split(template, "\n") {
+		if strings.TrimSpace(line) == "$ENVIRONMENT" {
+			out = append(out, envLines...)
+			continue
+		}
+		line = strings.ReplaceAll(line, "$TTY", opts.TTY)
+		line = strings.ReplaceAll(line, "$USER", opts.User)
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
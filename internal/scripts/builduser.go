@@ -0,0 +1,138 @@
+package scripts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// makepkg refuses to run as root, so when archutils itself runs as root AUR
+// builds are delegated to a dedicated system account. The account has no
+// privileges: it only clones and builds, while dependencies and the built
+// packages are installed by pacman running as root.
+var (
+	buildUser     = "archutils-build"
+	buildUserHome = "/var/lib/archutils-build"
+	// buildUserSudoers is the rule older versions granted the build user;
+	// the setup script removes it.
+	buildUserSudoers = "/etc/sudoers.d/archutils-build"
+)
+
+// geteuid is swapped out by tests to simulate root mode.
+var geteuid = os.Geteuid
+
+// runningAsRoot reports whether archutils runs as root, in which case
+// privileged commands run directly and AUR builds use the build user.
+func runningAsRoot() bool {
+	return geteuid() == 0
+}
+
+// buildUserSetupScript returns a shell script that creates the build user if
+// needed and removes the pacman sudoers rule earlier versions installed for
+// it. The script is idempotent.
+func buildUserSetupScript() string {
+	return fmt.Sprintf(`set -e
+if ! id -u %[1]s >/dev/null 2>&1; then
+	useradd --system --create-home --home-dir %[2]s --shell /usr/bin/nologin %[1]s
+fi
+rm -f %[3]s
+`, buildUser, buildUserHome, buildUserSudoers)
+}
+
+// preparedBuildUsers records the targets whose build user is set up, by
+// root, so that it is prepared once per run rather than once per package.
+var preparedBuildUsers = struct {
+	sync.Mutex
+	roots map[string]bool
+}{roots: map[string]bool{}}
+
+// ensureBuildUser prepares the build user on the target, returning the
+// script output on failure.
+func ensureBuildUser(t target) error {
+	preparedBuildUsers.Lock()
+	defer preparedBuildUsers.Unlock()
+	if preparedBuildUsers.roots[t.root] {
+		return nil
+	}
+	if output, err := t.shell(buildUserSetupScript()).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to prepare build user %s: %v\n%s", buildUser, err, output)
+	}
+	preparedBuildUsers.roots[t.root] = true
+	return nil
+}
+
+// aurBuildFunctions defines the shell functions of the root scripts building
+// AUR packages. as_build runs a command as the build user. aur_build clones
+// $1, checked out at $2 when set, installs its missing repo dependencies,
+// builds it as the build user and installs the package with pacman -U and the
+// flags in $3. Dependencies only found in the AUR have to be installed first.
+func aurBuildFunctions() string {
+	return fmt.Sprintf(`as_build() {
+	runuser -u %[1]s -- env HOME=%[2]s USER=%[1]s LOGNAME=%[1]s "$@"
+}
+aur_build() {
+	as_build mkdir -p %[2]s/build
+	dir=%[2]s/build/$1
+	rm -rf "$dir"
+	as_build git clone -q https://aur.archlinux.org/"$1".git "$dir"
+	cd "$dir"
+	if [ -n "$2" ]; then as_build git checkout -q "$2"; fi
+	deps=$(as_build makepkg --printsrcinfo | sed -n "s/^\t\(make\|check\)\{0,1\}depends\(_$(uname -m)\)\{0,1\} = \([^<>=]*\).*/\3/p")
+	missing=
+	if [ -n "$deps" ]; then missing=$(pacman -T $deps || true); fi
+	if [ -n "$missing" ]; then pacman -S --needed --asdeps --noconfirm $missing; fi
+	as_build makepkg -f --noconfirm
+	pkgfile=$(as_build makepkg --packagelist | grep "/$1-[^-/]*-[^-/]*-[^-/]*\.pkg\.tar") || { echo "$1: no package was built" >&2; return 1; }
+	pacman -U --noconfirm $3 $pkgfile
+	cd /
+	rm -rf "$dir"
+}
+`, buildUser, buildUserHome)
+}
+
+// buildUserInstallCmd returns the root command installing pkgs on the target
+// as root or in a mounted target: sync packages with pacman -S, the others
+// built from the AUR by the build user.
+func (t target) buildUserInstallCmd(pkgs []string, asDeps bool) *exec.Cmd {
+	script := "set -e\n" + aurBuildFunctions() + `flags=$1
+shift
+for pkg in "$@"; do
+	if pacman -Si "$pkg" >/dev/null 2>&1; then
+		pacman -S --needed --noconfirm $flags "$pkg"
+	else
+		aur_build "$pkg" "" "$flags"
+	fi
+done
+`
+	return t.run("sh", append([]string{"-c", script, "sh", installFlags(asDeps)}, pkgs...)...)
+}
+
+// buildUserApprovedCmd returns the root command building pkg from its AUR
+// repository checked out at the approved commit with the build user.
+func (t target) buildUserApprovedCmd(pkg, commit string, asDeps bool) *exec.Cmd {
+	script := "set -e\n" + aurBuildFunctions() + `aur_build "$1" "$2" "$3"` + "\n"
+	return t.run("sh", "-c", script, "sh", pkg, commit, installFlags(asDeps))
+}
+
+// installFlags returns the pacman flags marking packages as dependencies.
+func installFlags(asDeps bool) string {
+	if asDeps {
+		return "--asdeps"
+	}
+	return ""
+}
+
+// buildUserUpgradeCmd returns the root command upgrading the target with
+// pacman and rebuilding the AUR updates the helper lists as the build user.
+func (t target) buildUserUpgradeCmd(h AURHelper) *exec.Cmd {
+	script := "set -e\n" + aurBuildFunctions() + "pacman -Syu --noconfirm\n"
+	if len(h.aurUpdatesArgs) > 0 {
+		script += fmt.Sprintf(`for pkg in $(as_build %s %s | cut -d' ' -f1); do
+	aur_build "$pkg" "" ""
+done
+`, h.Name, strings.Join(h.aurUpdatesArgs, " "))
+	}
+	return t.run("sh", "-c", script)
+}
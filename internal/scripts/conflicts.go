@@ -0,0 +1,116 @@
+package scripts
+
+import (
+	"fmt"
+	"slices"
+)
+
+// replaceAnnotation prefixes installed packages the item is installed in
+// place of, e.g. "tlp [replace:power-profiles-daemon]". They are removed in
+// the install transaction, or reinstalled when the install fails.
+const replaceAnnotation = "replace:"
+
+// Conflict is a selected item that cannot be installed next to another
+// selected item or an installed package.
+type Conflict struct {
+	// Item is the selected package line.
+	Item string
+	// With is the other selected package line, or the installed package.
+	With string
+	// Installed reports whether With is an installed package.
+	Installed bool
+	// Reason explains the conflict, e.g. "tlp conflicts with power-profiles-daemon".
+	Reason string
+}
+
+// WithReplaced returns the package line installing it in place of the
+// installed package pkg.
+func WithReplaced(item, pkg string) string {
+	return item + " [" + replaceAnnotation + pkg + "]"
+}
+
+// Conflicts checks the selected package lines against each other and the
+// installed packages using the conflicts and replaces fields of the sync
+// database, or of the local one for packages not in the sync repositories.
+func (r Runner) Conflicts(items []string) []Conflict {
+	t := r.target()
+	var local []packageInfo
+	if output, err := t.query("-Qi").Output(); err == nil {
+		local = parsePackageInfo(string(output))
+	}
+	return findConflicts(items, getSyncInfo(t), local)
+}
+
+func findConflicts(items []string, syncInfos, localInfos []packageInfo) []Conflict {
+	byName := make(map[string]packageInfo)
+	for _, info := range localInfos {
+		byName[info.name] = info
+	}
+	for _, info := range syncInfos {
+		byName[info.name] = info
+	}
+
+	type selected struct {
+		item string
+		line packageLine
+		info packageInfo
+	}
+	var sel []selected
+	selectedNames := make(map[string]bool)
+	for _, item := range items {
+		line, ok := parsePackageLine(item)
+		if !ok {
+			continue
+		}
+		for _, pkg := range line.packages() {
+			selectedNames[pkg] = true
+			if info, ok := byName[pkg]; ok {
+				sel = append(sel, selected{item, line, info})
+			}
+		}
+	}
+
+	var conflicts []Conflict
+	for i, a := range sel {
+		for _, b := range sel[i+1:] {
+			if a.item == b.item {
+				continue
+			}
+			if reason := clash(a.info, b.info); reason != "" {
+				conflicts = append(conflicts, Conflict{Item: a.item, With: b.item, Reason: reason})
+			}
+		}
+	}
+	for _, a := range sel {
+		for _, installed := range localInfos {
+			if selectedNames[installed.name] || slices.Contains(a.line.replaced, installed.name) {
+				continue
+			}
+			if reason := clash(a.info, installed); reason != "" {
+				conflicts = append(conflicts, Conflict{Item: a.item, With: installed.name, Installed: true, Reason: reason})
+			}
+		}
+	}
+	return conflicts
+}
+
+// clash explains why packages a and b cannot be installed together, or
+// returns "" if they can.
+func clash(a, b packageInfo) string {
+	if a.name == b.name {
+		return ""
+	}
+	for _, pair := range [][2]packageInfo{{a, b}, {b, a}} {
+		x, y := pair[0], pair[1]
+		names := append([]string{y.name}, y.provides...)
+		for _, name := range names {
+			if slices.Contains(x.conflicts, name) {
+				return fmt.Sprintf("%s conflicts with %s", x.name, name)
+			}
+			if slices.Contains(x.replaces, name) {
+				return fmt.Sprintf("%s replaces %s", x.name, name)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package scripts
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// flathubRepo is the remote added before installing Flatpak applications.
+const flathubRepo = "https://dl.flathub.org/repo/flathub.flatpakrepo"
+
+var (
+	flatpakCacheOnce sync.Once
+	// flatpakCache maps scope ("user" or "system") to installed application
+	// IDs and their descriptions.
+	flatpakCache map[string]map[string]string
+)
+
+// flatpakLine is a parsed line of a flatpak category file, e.g.
+// "org.mozilla.firefox" or "com.spotify.Client [user]". Applications are
+// installed system-wide unless marked [user].
+type flatpakLine struct {
+	id   string
+	user bool
+}
+
+// parseFlatpakLine splits a flatpak line into its application ID and scope.
+func parseFlatpakLine(line string) (flatpakLine, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return flatpakLine{}, false
+	}
+	parsed := flatpakLine{id: fields[0]}
+	for _, field := range fields[1:] {
+		switch field {
+		case "[user]":
+			parsed.user = true
+		case "[system]":
+			parsed.user = false
+		}
+	}
+	return parsed, true
+}
+
+func (l flatpakLine) scope() string {
+	if l.user {
+		return "user"
+	}
+	return "system"
+}
+
+// flatpakCmd returns the flatpak subcommand for the scope: system-wide
+// operations run as root, per-user ones as user.
+func flatpakCmd(t target, user string, userScope bool, subcommand string, args ...string) *exec.Cmd {
+	if userScope {
+		return t.asUser(user, "flatpak", append([]string{subcommand, "--user"}, args...)...)
+	}
+	return t.run("flatpak", append([]string{subcommand, "--system"}, args...)...)
+}
+
+// parseFlatpakList parses "flatpak list --columns=application,description".
+func parseFlatpakList(output string) map[string]string {
+	apps := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		id, desc, _ := strings.Cut(line, "\t")
+		if id = strings.TrimSpace(id); id != "" {
+			apps[id] = strings.TrimSpace(desc)
+		}
+	}
+	return apps
+}
+
+// installedFlatpaks returns a lazily-loaded map of the installed applications
+// per scope. The result is cached via sync.Once like the extension list.
+func (r Runner) installedFlatpaks() map[string]map[string]string {
+	flatpakCacheOnce.Do(func() {
+		flatpakCache = make(map[string]map[string]string)
+		t := r.target()
+		for _, userScope := range []bool{false, true} {
+			scope := flatpakLine{user: userScope}.scope()
+			// Listing does not need root; only the user scope needs the user.
+			cmd := exec.Command("flatpak", "list", "--system", "--app", "--columns=application,description")
+			if userScope || t.chrooted() {
+				cmd = flatpakCmd(t, r.targetUser(), userScope, "list", "--app", "--columns=application,description")
+			}
+			output, err := cmd.Output()
+			if err != nil {
+				continue
+			}
+			flatpakCache[scope] = parseFlatpakList(string(output))
+		}
+	})
+	return flatpakCache
+}
+
+// InstallFlatpak installs an application from Flathub in the scope given by
+// the line, installing flatpak itself and adding the remote when needed.
+func (r Runner) InstallFlatpak(app string) (bool, string) {
+	line, ok := parseFlatpakLine(app)
+	if !ok {
+		return false, "Invalid application string"
+	}
+	t := r.target()
+	user := r.targetUser()
+	if line.user && user == "" {
+		return false, fmt.Sprintf("%s: %s", line.id, r.missingUserMessage())
+	}
+
+	if ok, msg := r.ensureTool("flatpak", "flatpak"); !ok {
+		return false, fmt.Sprintf("%s: %s", line.id, msg)
+	}
+
+	remote := flatpakCmd(t, user, line.user, "remote-add", "--if-not-exists", "flathub", flathubRepo)
+	if output, err := remote.CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("%s: Failed to add the flathub remote %v\n%s", line.id, err, strings.Trim(string(output), "\n"))
+	}
+
+	cmd := flatpakCmd(t, user, line.user, "install", "--noninteractive", "-y", "flathub", line.id)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, fmt.Sprintf("%s: Failed to install %v\n%s", line.id, err, strings.Trim(string(output), "\n"))
+	}
+	return true, fmt.Sprintf("%s: Installed successfully (%s)", line.id, line.scope())
+}
+
+// GetFlatpakDescription returns the description of an installed application.
+func (r Runner) GetFlatpakDescription(app string) string {
+	line, ok := parseFlatpakLine(app)
+	if !ok {
+		return ""
+	}
+	return r.installedFlatpaks()[line.scope()][line.id]
+}
@@ -1,98 +1,262 @@
 package scripts
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	c "github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/history"
+	"github.com/fcarp10/archutils/internal/session"
 )
 
 type Installer interface {
-	InstallPackage(pkg string) (bool, string)
-	ParuStepCount() int
-	ParuStepCmd(step int) *exec.Cmd
-	InstallVSCodeExtension(extension string) (bool, string)
-	EnableAutologin() (bool, string)
+	Source(kind string) Source
+	AURHelperName() string
+	TargetUser() string
+	WithUser(user string) Installer
+	HelperStrategies() []string
+	ValidateBootstrap(opts BootstrapOptions) error
+	HelperBootstrapPackage(opts BootstrapOptions) string
+	HelperStepNames(opts BootstrapOptions) []string
+	HelperStepCmd(opts BootstrapOptions, step int) *exec.Cmd
+	EnableAutologin(opts AutologinOptions) (bool, string)
+	DisableAutologin() (bool, string)
+	LoginUsers() []string
 	EnablePasswordlessSSH() (bool, string)
-	EnablePasswordlessSudo() (bool, string)
+	EnablePasswordlessPrivilege() (bool, string)
 	AddUserToWheel() (bool, string)
 	WheelGroupCmd() *exec.Cmd
-	GetPackageDescription(item string) string
-	GetExtensionDescription(extension string) string
-	CheckParuInstalled() (bool, string)
-	IsPackageInstalled(pkg string) bool
-	IsExtensionInstalled(extension string) bool
-	SudoValidateCmd() *exec.Cmd
-	GetInstalledPackages() map[string]string
+	CheckHelperInstalled() (bool, string)
+	IsRepoPackage(pkg string) bool
+	OptionalDeps(pkg string) []OptionalDep
+	ProviderChoices(items []string) []ProviderChoice
+	Conflicts(items []string) []Conflict
+	PreflightChecks(source string) []CheckResult
+	PendingUpgrades() ([]PackageUpdate, bool, error)
+	UpgradeCmd() *exec.Cmd
+	Orphans() ([]string, error)
+	StaleCachedPackages(retention CacheRetention) ([]CachedPackage, error)
+	ConfigFiles() ([]ConfigFile, error)
+	ReadConfigFile(path string) (string, error)
+	MergeConfigCmd(file ConfigFile) *exec.Cmd
+	History() ([]history.Entry, error)
+	PlanUndo(pkgs []string) UndoPlan
+	SnapshotTool() string
+	CreateSnapshot(tool, description string) (session.Snapshot, error)
+	FetchBuildFiles(pkg string) (map[string]string, string, error)
+	PrivilegeTool() string
+	PrivilegeValidateCmd() *exec.Cmd
+	RefreshPrivilege() error
+	CheckPrivilegeCache() error
 }
 
 // Runner implements the Installer interface by executing system commands.
-type Runner struct{}
+type Runner struct {
+	// AURHelper names the AUR helper to use (paru, yay or pikaur).
+	// Empty means auto-detect, see detectAURHelper.
+	AURHelper string
+	// Root is the mount point of a target system to configure instead of
+	// the running one, e.g. /mnt during installation. Empty means the host.
+	Root string
+	// User is the account user-scoped tasks apply to. Empty means the
+	// invoking user on the running system; a target system requires it.
+	User string
+}
 
-func (r Runner) InstallPackage(pkg string) (bool, string) {
-	if ok, msg := r.CheckParuInstalled(); !ok {
-		return false, msg
+func (r Runner) helper() AURHelper {
+	return detectAURHelper(r.AURHelper)
+}
+
+func (r Runner) target() target {
+	return target{root: r.Root}
+}
+
+// targetUser returns the user that user-scoped tasks apply to, or "" when it
+// cannot be determined.
+func (r Runner) targetUser() string {
+	if r.User != "" || r.Root != "" {
+		return r.User
 	}
+	return invokingUser()
+}
 
-	fields := strings.Fields(pkg)
-	if len(fields) == 0 {
+// missingUserMessage explains why no target user is available.
+func (r Runner) missingUserMessage() string {
+	if r.Root != "" {
+		return fmt.Sprintf("No user selected for the system at %s, pass --user or use 'Select User'", r.Root)
+	}
+	return "Unable to determine the user, use 'Select User' from the main menu"
+}
+
+// TargetUser returns the user that user-scoped tasks apply to.
+func (r Runner) TargetUser() string {
+	return r.targetUser()
+}
+
+// WithUser returns a copy of the runner whose user-scoped tasks apply to user.
+func (r Runner) WithUser(user string) Installer {
+	r.User = user
+	return r
+}
+
+func (r Runner) InstallPackage(pkg string) (bool, string) {
+	line, ok := parsePackageLine(pkg)
+	if !ok {
 		return false, "Invalid package string"
 	}
-	packageName := fields[0]
-	services := []string{}
-	userLevel := false
-	for i := 1; i < len(fields); i++ {
-		field := fields[i]
-		if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
-			content := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
-			if content == "user" {
-				userLevel = true
-			} else {
-				services = append(services, content)
-			}
+	packageName := line.name
+	// Without the user, the groups could not be joined after the install.
+	if len(line.groups) > 0 && r.targetUser() == "" {
+		return false, fmt.Sprintf("%s: Not installed, [group:%s] needs a user. %s", packageName, line.groups[0], r.missingUserMessage())
+	}
+
+	t := r.target()
+	cmd, err := r.packageInstallCmd(line)
+	if err != nil {
+		return false, fmt.Sprintf("%s: %v", packageName, err)
+	}
+	replaced := strings.Join(line.replaced, ", ")
+	removed := len(line.replaced) > 0
+	var foreign []string
+	if removed {
+		// Only the confirmed replaced packages go, right before the install,
+		// and come back if it fails; pacman still refuses any other conflict.
+		// -dd lets them go even when installed packages depend on them.
+		foreign = foreignPackages(t, line.replaced)
+		args := append([]string{"-Rdd", "--noconfirm"}, line.replaced...)
+		if output, err := t.run("pacman", args...).CombinedOutput(); err != nil {
+			return false, fmt.Sprintf("%s: Failed to remove %s %v\n%s", packageName, replaced, err, strings.Trim(string(output), "\n"))
 		}
 	}
-	cmd := exec.Command("paru", "-S", "--needed", "--noconfirm", packageName)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return false, fmt.Sprintf("%s: Failed to install %v\n%s", packageName, err, strings.Trim(string(output), "\n"))
+		message := fmt.Sprintf("%s: Failed to install %v\n%s", packageName, err, strings.Trim(string(output), "\n"))
+		if removed {
+			message += "\n" + restoreReplaced(t, line.replaced, foreign)
+		}
+		return false, message
 	}
 	message := fmt.Sprintf("%s: Installed successfully", packageName)
-	for _, service := range services {
-		success, enableMsg := enableService(service, userLevel)
+	if len(line.replaced) > 0 {
+		message += fmt.Sprintf("\nReplaced %s", replaced)
+	}
+	for _, service := range line.services {
+		success, enableMsg := enableService(t, service, line.userLevel, r.targetUser())
 		if !success {
 			return false, fmt.Sprintf("%s\n%s", message, enableMsg)
 		}
 		message += "\n" + enableMsg
 	}
+	for _, group := range line.groups {
+		success, groupMsg := addUserToGroup(t, group, r.targetUser())
+		if !success {
+			return false, fmt.Sprintf("%s\n%s", message, groupMsg)
+		}
+		message += "\n" + groupMsg
+	}
 	return true, message
 }
 
-func (r Runner) ParuStepCount() int { return 4 }
-
-func (r Runner) ParuStepCmd(step int) *exec.Cmd {
-	var cmd *exec.Cmd
-	switch step {
-	case 0:
-		cmd = exec.Command("sh", "-c",
-			"if pacman -Qeq paru 2>/dev/null; then sudo pacman -Rns --noconfirm paru; fi")
-	case 1:
-		cmd = exec.Command("sudo", "pacman", "-S", "--needed", "--noconfirm",
-			"base-devel", "git", "rust")
-	case 2:
-		cmd = exec.Command("sh", "-c",
-			"rm -rf /tmp/paru && git clone https://aur.archlinux.org/paru.git /tmp/paru")
-	case 3:
-		cmd = exec.Command("makepkg", "-si", "--noconfirm")
-		cmd.Dir = "/tmp/paru"
-	default:
+// packageInstallCmd returns the command installing the packages of a line:
+// its chosen providers, or the package itself.
+func (r Runner) packageInstallCmd(line packageLine) (*exec.Cmd, error) {
+	t := r.target()
+	helperInstalled, msg := r.CheckHelperInstalled()
+	switch {
+	case r.isRepoLine(line) && (!helperInstalled || t.usesBuildUser()):
+		// Official repo packages don't need an AUR helper, and as root or in
+		// a mounted target they skip the build user entirely.
+		return t.installRepoPackages(line.targets()...), nil
+	case helperInstalled:
+		if t.usesBuildUser() {
+			if err := ensureBuildUser(t); err != nil {
+				return nil, err
+			}
+		}
+		if line.approved != "" {
+			if !validCommit(line.approved) {
+				return nil, fmt.Errorf("invalid approved commit %q", line.approved)
+			}
+			return r.helper().approvedBuildCmd(t, line.name, line.approved, line.asDeps), nil
+		}
+		return r.helper().installCmd(t, line.targets()...), nil
+	}
+	return nil, fmt.Errorf("AUR package cannot be installed: %s", msg)
+}
+
+// foreignPackages returns the packages among pkgs that are installed from
+// outside the sync databases, such as AUR builds.
+func foreignPackages(t target, pkgs []string) []string {
+	output, _ := t.query(append([]string{"-Qqm"}, pkgs...)...).Output()
+	return strings.Fields(string(output))
+}
+
+// restoreReplaced reinstalls the packages removed for a failed install. The
+// foreign ones cannot be reinstalled from the sync databases and are
+// reported instead.
+func restoreReplaced(t target, pkgs, foreign []string) string {
+	var messages []string
+	if len(foreign) > 0 {
+		messages = append(messages, fmt.Sprintf("The replaced %s came from the AUR and was not reinstalled, reinstall it by hand", strings.Join(foreign, ", ")))
+	}
+	var repo []string
+	for _, pkg := range pkgs {
+		if !slices.Contains(foreign, pkg) {
+			repo = append(repo, pkg)
+		}
+	}
+	if len(repo) == 0 {
+		return strings.Join(messages, "\n")
+	}
+	names := strings.Join(repo, ", ")
+	if output, err := t.installRepoPackages(repo...).CombinedOutput(); err != nil {
+		messages = append(messages, fmt.Sprintf("Failed to reinstall the replaced %s, reinstall it by hand: %v\n%s", names, err, strings.Trim(string(output), "\n")))
+	} else {
+		messages = append(messages, fmt.Sprintf("Reinstalled the replaced %s", names))
+	}
+	return strings.Join(messages, "\n")
+}
+
+// AURHelperName returns the name of the configured AUR helper.
+func (r Runner) AURHelperName() string {
+	return r.helper().Name
+}
+
+// HelperStrategies returns the bootstrap strategies available for the helper.
+func (r Runner) HelperStrategies() []string {
+	return r.helper().BootstrapStrategies()
+}
+
+func (r Runner) ValidateBootstrap(opts BootstrapOptions) error {
+	return opts.Validate(r.helper())
+}
+
+// HelperBootstrapPackage returns the AUR package the strategy builds, or ""
+// for local strategies.
+func (r Runner) HelperBootstrapPackage(opts BootstrapOptions) string {
+	return r.helper().BootstrapPackage(opts)
+}
+
+func (r Runner) HelperStepNames(opts BootstrapOptions) []string {
+	steps := r.helper().bootstrapSteps(r.target(), opts)
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.name
+	}
+	return names
+}
+
+func (r Runner) HelperStepCmd(opts BootstrapOptions, step int) *exec.Cmd {
+	steps := r.helper().bootstrapSteps(r.target(), opts)
+	if step < 0 || step >= len(steps) {
 		return exec.Command("false")
 	}
+	cmd := steps[step].cmd()
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -100,7 +264,11 @@ func (r Runner) ParuStepCmd(step int) *exec.Cmd {
 }
 
 func (r Runner) InstallVSCodeExtension(extension string) (bool, string) {
-	cmd := exec.Command(editorBinary(), "--install-extension", extension)
+	user := r.targetUser()
+	if user == "" {
+		return false, fmt.Sprintf("%s: %s", extension, r.missingUserMessage())
+	}
+	cmd := r.target().asUser(user, editorBinary(), "--install-extension", extension)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return false, fmt.Sprintf("%s: Failed to install %v\n%s", extension, err, strings.Trim(string(output), "\n"))
@@ -108,10 +276,9 @@ func (r Runner) InstallVSCodeExtension(extension string) (bool, string) {
 	return true, fmt.Sprintf("%s: Installed successfully", extension)
 }
 
-func (r Runner) EnableAutologin() (bool, string) {
-	user := os.Getenv("USER")
-	if user == "" {
-		return false, "Unable to get current user"
+func (r Runner) EnableAutologin(opts AutologinOptions) (bool, string) {
+	if err := opts.Validate(); err != nil {
+		return false, fmt.Sprintf("Invalid autologin settings: %v", err)
 	}
 
 	content, err := c.ReadFile(c.ConfigDir() + "/autologin.conf")
@@ -119,80 +286,98 @@ func (r Runner) EnableAutologin() (bool, string) {
 		return false, fmt.Sprintf("Failed to read autologin.conf: %v", err)
 	}
 
-	template := string(content)
-	replaced := strings.ReplaceAll(template, "$USER", user)
+	replaced := renderAutologinConf(string(content), opts)
+	dropInDir := r.target().path(autologinDropInDir(opts.TTY))
 
-	cmd1 := exec.Command("sudo", "mkdir", "-p", "/etc/systemd/system/getty@tty1.service.d")
+	cmd1 := privileged("mkdir", "-p", dropInDir)
 	if err := cmd1.Run(); err != nil {
 		return false, fmt.Sprintf("Failed to create directory: %v", err)
 	}
 
-	cmd2 := exec.Command("sudo", "tee", "/etc/systemd/system/getty@tty1.service.d/autologin.conf")
+	cmd2 := privileged("tee", dropInDir+"/autologin.conf")
 	cmd2.Stdin = strings.NewReader(replaced)
 	if err := cmd2.Run(); err != nil {
 		return false, fmt.Sprintf("Failed to write autologin.conf: %v", err)
 	}
 
-	return true, "Autologin configured successfully"
+	return true, fmt.Sprintf("Autologin configured successfully for %s on %s", opts.User, opts.TTY)
+}
+
+func (r Runner) DisableAutologin() (bool, string) {
+	t := r.target()
+	dropIns, _ := filepath.Glob(t.path(autologinDropInGlob))
+	if len(dropIns) == 0 {
+		return true, "Autologin is not configured, nothing to remove"
+	}
+
+	for _, dropIn := range dropIns {
+		if output, err := privileged("rm", "-f", dropIn).CombinedOutput(); err != nil {
+			return false, fmt.Sprintf("Failed to remove %s: %v\n%s", dropIn, err, strings.TrimSpace(string(output)))
+		}
+		// Remove the drop-in directory too if autologin.conf was its only file.
+		privileged("rmdir", "--ignore-fail-on-non-empty", filepath.Dir(dropIn)).Run()
+	}
+
+	// A mounted target has no running systemd to reload.
+	if !t.chrooted() {
+		if output, err := privileged("systemctl", "daemon-reload").CombinedOutput(); err != nil {
+			return false, fmt.Sprintf("Failed to reload systemd: %v\n%s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	return true, fmt.Sprintf("Autologin disabled (%d drop-in(s) removed)", len(dropIns))
+}
+
+// LoginUsers returns the real login users from /etc/passwd of the target.
+func (r Runner) LoginUsers() []string {
+	users, err := readLoginUsers(r.target().path(passwdPath))
+	if err != nil {
+		return nil
+	}
+	return users
 }
 
 func (r Runner) EnablePasswordlessSSH() (bool, string) {
-	success1, msg1 := disableSSHPasswordAuth()
+	success1, msg1 := disableSSHPasswordAuth(r.target())
 	if !success1 {
 		return false, msg1
 	}
-	success2, msg2 := enableService("sshd", false)
+	success2, msg2 := enableService(r.target(), "sshd", false, "")
 	if !success2 {
 		return false, msg1 + " - " + msg2
 	}
 	return true, msg1 + " - " + msg2
 }
 
-func (r Runner) EnablePasswordlessSudo() (bool, string) {
-	user := os.Getenv("USER")
+// EnablePasswordlessPrivilege configures passwordless root access for the
+// target user with whichever privilege tool is in use.
+func (r Runner) EnablePasswordlessPrivilege() (bool, string) {
+	user := r.targetUser()
 	if user == "" {
-		return false, "Unable to get current user"
-	}
-
-	sudoersPath := fmt.Sprintf("/etc/sudoers.d/%s", user)
-
-	if _, err := os.Stat(sudoersPath); err == nil {
-		return true, "Passwordless sudo is already configured"
+		return false, r.missingUserMessage()
 	}
 
-	sudoersContent := fmt.Sprintf("%s ALL=(ALL) NOPASSWD: ALL\n", user)
-
-	cmd := exec.Command("sudo", "tee", sudoersPath)
-	cmd.Stdin = strings.NewReader(sudoersContent)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return false, fmt.Sprintf("Failed to configure passwordless sudo: %v\n%s", err, strings.TrimSpace(stderr.String()))
-	}
-
-	checkSudoersCmd := exec.Command("sudo", "visudo", "-c", "-f", sudoersPath)
-	if checkOutput, checkErr := checkSudoersCmd.CombinedOutput(); checkErr != nil {
-		exec.Command("sudo", "rm", "-f", sudoersPath).Run()
-		return false, fmt.Sprintf("Sudoers file syntax error: %s", strings.TrimSpace(string(checkOutput)))
-	}
-
-	permCmd := exec.Command("sudo", "chmod", "440", sudoersPath)
-	if err := permCmd.Run(); err != nil {
-		return false, fmt.Sprintf("Failed to set permissions on sudoers file: %v", err)
+	switch tool := privilegeTool(); tool {
+	case PrivilegeSudo:
+		return enablePasswordlessSudo(r.target(), user)
+	case PrivilegeDoas:
+		return enablePasswordlessDoas(r.target(), user)
+	case PrivilegeRun0:
+		return enablePasswordlessRun0(r.target(), user)
+	default:
+		return false, fmt.Sprintf("Unsupported privilege tool %q (expected sudo, doas or run0)", tool)
 	}
-	return true, "Passwordless sudo configured successfully"
 }
 
 func (r Runner) WheelGroupCmd() *exec.Cmd {
-	user := os.Getenv("USER")
+	user := r.targetUser()
 	if user == "" {
 		return exec.Command("false")
 	}
+	t := r.target()
 
 	// Check if the user is already in the wheel group AND the sudoers
 	// drop-in for %wheel already exists — if so, nothing to do.
-	checkCmd := exec.Command("id", "-nG")
+	checkCmd := t.userGroups(user)
 	output, err := checkCmd.Output()
 	wheelGroupOk := false
 	if err == nil {
@@ -207,8 +392,8 @@ func (r Runner) WheelGroupCmd() *exec.Cmd {
 
 	// Also check if /etc/sudoers.d/wheel already grants access to %wheel.
 	wheelSudoersOk := false
-	if _, statErr := os.Stat("/etc/sudoers.d/wheel"); statErr == nil {
-		data, readErr := os.ReadFile("/etc/sudoers.d/wheel")
+	if _, statErr := os.Stat(t.path("/etc/sudoers.d/wheel")); statErr == nil {
+		data, readErr := os.ReadFile(t.path("/etc/sudoers.d/wheel"))
 		if readErr == nil && strings.Contains(string(data), "%wheel") {
 			wheelSudoersOk = true
 		}
@@ -224,8 +409,13 @@ func (r Runner) WheelGroupCmd() *exec.Cmd {
 	sudoersCmd := "mkdir -p /etc/sudoers.d && echo '%wheel ALL=(ALL:ALL) ALL' > /etc/sudoers.d/wheel && chmod 440 /etc/sudoers.d/wheel"
 	fullCmd := fmt.Sprintf("%s && %s", addUserCmd, sudoersCmd)
 
+	// A mounted target is configured from inside arch-chroot.
+	if t.chrooted() {
+		return t.shell(fullCmd)
+	}
+
 	// Running as root — execute the chain directly.
-	if os.Geteuid() == 0 {
+	if runningAsRoot() {
 		return exec.Command("sh", "-c", fullCmd)
 	}
 
@@ -237,13 +427,14 @@ func (r Runner) WheelGroupCmd() *exec.Cmd {
 }
 
 func (r Runner) AddUserToWheel() (bool, string) {
-	user := os.Getenv("USER")
+	user := r.targetUser()
 	if user == "" {
-		return false, "Unable to get current user"
+		return false, r.missingUserMessage()
 	}
+	t := r.target()
 
 	// Verify the user is in the wheel group.
-	checkCmd := exec.Command("id", "-nG")
+	checkCmd := t.userGroups(user)
 	output, err := checkCmd.Output()
 	if err != nil {
 		return false, fmt.Sprintf("Failed to check user groups: %v", err)
@@ -263,7 +454,7 @@ func (r Runner) AddUserToWheel() (bool, string) {
 	}
 
 	// Also verify the sudoers drop-in for %wheel exists.
-	if _, statErr := os.Stat("/etc/sudoers.d/wheel"); statErr != nil {
+	if _, statErr := os.Stat(t.path("/etc/sudoers.d/wheel")); statErr != nil {
 		return false, "Wheel group sudo access not configured.\nTry manually as root:\n  echo '%wheel ALL=(ALL:ALL) ALL' > /etc/sudoers.d/wheel && chmod 440 /etc/sudoers.d/wheel"
 	}
 
@@ -271,7 +462,7 @@ func (r Runner) AddUserToWheel() (bool, string) {
 }
 
 func (r Runner) GetPackageDescription(item string) string {
-	cmd := exec.Command("pacman", "-Q", "--info", item)
+	cmd := r.target().query("-Q", "--info", item)
 	output, err := cmd.Output()
 	if err != nil {
 		return ""
@@ -295,10 +486,11 @@ func (r Runner) GetExtensionDescription(extension string) string {
 	}
 	extID := fields[0]
 
-	home, _ := os.UserHomeDir()
+	home := userHome(r.target().path(passwdPath), r.targetUser())
 	if home == "" {
 		return ""
 	}
+	home = r.target().path(home)
 
 	dirs := []string{
 		filepath.Join(home, ".local", "share", "VSCodium", "extensions"),
@@ -334,48 +526,79 @@ func (r Runner) GetExtensionDescription(extension string) string {
 	return ""
 }
 
-func (r Runner) CheckParuInstalled() (bool, string) {
-	_, err := exec.LookPath("paru")
-	if err != nil {
-		return false, "paru is not installed. Please select 'Install Paru' from the main menu first."
+func (r Runner) CheckHelperInstalled() (bool, string) {
+	h := r.helper()
+	if !h.installedOn(r.target()) {
+		return false, fmt.Sprintf("%s is not installed. Please select 'Install %s' from the main menu first.", h.Name, HelperTitle(h.Name))
 	}
 	return true, ""
 }
 
-func (r Runner) IsPackageInstalled(pkg string) bool {
-	fields := strings.Fields(pkg)
-	if len(fields) == 0 {
-		return false
-	}
-	cmd := exec.Command("pacman", "-Q", fields[0])
-	return cmd.Run() == nil
+// IsRepoPackage reports whether the package, or every provider chosen for
+// it, is available from the sync repositories, i.e. installable with pacman
+// alone.
+func (r Runner) IsRepoPackage(pkg string) bool {
+	line, ok := parsePackageLine(pkg)
+	return ok && r.isRepoLine(line)
 }
 
-func (r Runner) IsExtensionInstalled(extension string) bool {
-	fields := strings.Fields(extension)
-	if len(fields) == 0 {
-		return false
+// isRepoLine reports whether all the packages installing the line are in
+// the sync databases.
+func (r Runner) isRepoLine(line packageLine) bool {
+	repo := getSyncPackages(r.target())
+	for _, name := range line.packages() {
+		if !repo[name] {
+			return false
+		}
 	}
-	return getInstalledExtensions()[fields[0]]
+	return true
+}
+
+// listExtensionsCmd lists the editor extensions of the target user.
+func (r Runner) listExtensionsCmd() *exec.Cmd {
+	return r.target().asUser(r.targetUser(), editorBinary(), "--list-extensions")
+}
+
+// PrivilegeTool returns the name of the privilege-escalation tool in use.
+func (r Runner) PrivilegeTool() string {
+	return privilegeTool()
 }
 
-func (r Runner) SudoValidateCmd() *exec.Cmd {
-	// First check if sudo credentials are already cached (non-interactive).
+func (r Runner) PrivilegeValidateCmd() *exec.Cmd {
+	// First check if credentials are already cached (non-interactive).
 	// If yes, return a no-op command so the user isn't prompted unnecessarily.
-	if err := exec.Command("sudo", "-n", "true").Run(); err == nil {
+	if err := privilegeCheckCmd().Run(); err == nil {
 		return exec.Command("true")
 	}
 	// Credentials expired — prompt the user.
-	cmd := exec.Command("sudo", "-v")
+	cmd := privilegePromptCmd()
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
 	return cmd
 }
 
+// RefreshPrivilege extends the cached credentials without prompting. It fails
+// when the credentials have already expired.
+func (r Runner) RefreshPrivilege() error {
+	return privilegeRefreshCmd().Run()
+}
+
+// CheckPrivilegeCache checks, after a successful prompt, that the privilege
+// tool runs commands without prompting again. Runs need it as their commands
+// and the credential keepalive cannot prompt; run0 has no credential cache
+// and doas only has one with "persist", so both need a passwordless rule
+// otherwise.
+func (r Runner) CheckPrivilegeCache() error {
+	if privilegeRefreshCmd().Run() != nil {
+		return privilegeCacheError()
+	}
+	return nil
+}
+
 // GetInstalledPackages runs pacman -Qi once and returns a map of
 // installed package names to their descriptions.
 func (r Runner) GetInstalledPackages() map[string]string {
-	cmd := exec.Command("pacman", "-Qi")
+	cmd := r.target().query("-Qi")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil
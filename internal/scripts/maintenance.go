@@ -0,0 +1,344 @@
+package scripts
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Maintenance source kinds. Maintenance tasks run through the same queue as
+// installs: installing an item applies the task to it.
+const (
+	// SourceOrphans removes orphaned packages; items are package names.
+	SourceOrphans = "orphans"
+	// SourceCache deletes old package files; items are cache file paths.
+	SourceCache = "cache"
+	// SourceConfigs resolves .pacnew and .pacsave files; items are file
+	// paths with a config action annotation, see WithConfigAction.
+	SourceConfigs = "configs"
+)
+
+// Orphans lists the packages installed as dependencies that no installed
+// package requires anymore.
+func (r Runner) Orphans() ([]string, error) {
+	output, err := r.target().query("-Qdtq").Output()
+	var exitErr *exec.ExitError
+	// pacman exits with 1 when there are no orphans.
+	if err != nil && !(errors.As(err, &exitErr) && len(strings.TrimSpace(string(output))) == 0) {
+		return nil, fmt.Errorf("failed to list orphaned packages: %v", err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// orphanSource removes orphaned packages with their unneeded dependencies.
+type orphanSource struct{ r Runner }
+
+func (s orphanSource) Kind() string                       { return SourceOrphans }
+func (s orphanSource) NeedsPrivilege() bool               { return true }
+func (s orphanSource) Installed() map[string]string       { return nil }
+func (s orphanSource) Install(item string) (bool, string) { return packageSource{s.r}.Remove(item) }
+func (s orphanSource) Describe(item string) string        { return s.r.GetPackageDescription(ItemID(item)) }
+
+func (s orphanSource) Remove(item string) (bool, string) {
+	return false, fmt.Sprintf("%s: Removed packages cannot be restored here, install it again instead", ItemID(item))
+}
+
+// CacheRetention selects the package files kept in the pacman cache, like
+// paccache's -k option.
+type CacheRetention struct {
+	// Keep is the number of versions kept of each installed package.
+	Keep int
+	// KeepUninstalled is the number kept of packages that are not installed.
+	KeepUninstalled int
+}
+
+// CachedPackage is a package file in the pacman cache.
+type CachedPackage struct {
+	// Path is the file's path on the target.
+	Path    string
+	Name    string
+	Version string
+	Arch    string
+	// Size includes the detached signature, if any.
+	Size int64
+}
+
+// Describe returns the package, version and size of the file.
+func (p CachedPackage) Describe() string {
+	return fmt.Sprintf("%s %s (%s), %s", p.Name, p.Version, p.Arch, formatBytes(uint64(p.Size)))
+}
+
+// StaleCachedPackages lists the package files in the pacman cache that the
+// retention does not keep: all but the newest versions of each package.
+func (r Runner) StaleCachedPackages(retention CacheRetention) ([]CachedPackage, error) {
+	t := r.target()
+	entries, err := os.ReadDir(t.path(pacmanCachePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the package cache: %v", err)
+	}
+	var pkgs []CachedPackage
+	for _, entry := range entries {
+		pkg, ok := parseCacheFile(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		pkg.Path = pacmanCachePath + "/" + entry.Name()
+		for _, name := range []string{pkg.Path, pkg.Path + ".sig"} {
+			if info, err := os.Stat(t.path(name)); err == nil {
+				pkg.Size += info.Size()
+			}
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	installed := make(map[string]bool)
+	if output, err := t.query("-Qq").Output(); err == nil {
+		for _, name := range strings.Fields(string(output)) {
+			installed[name] = true
+		}
+	}
+	return staleCachedPackages(pkgs, retention, installed), nil
+}
+
+// parseCacheFile parses a package file name, name-version-release-arch
+// followed by .pkg.tar and the compression extension. Signatures and partial
+// downloads are skipped.
+func parseCacheFile(file string) (CachedPackage, bool) {
+	idx := strings.Index(file, ".pkg.tar")
+	if idx < 0 || strings.HasSuffix(file, ".sig") || strings.HasSuffix(file, ".part") {
+		return CachedPackage{}, false
+	}
+	parts := strings.Split(file[:idx], "-")
+	if len(parts) < 4 {
+		return CachedPackage{}, false
+	}
+	n := len(parts)
+	return CachedPackage{
+		Name:    strings.Join(parts[:n-3], "-"),
+		Version: parts[n-3] + "-" + parts[n-2],
+		Arch:    parts[n-1],
+	}, true
+}
+
+// staleCachedPackages returns the files beyond the retained number of
+// newest versions of each package and architecture, oldest first.
+func staleCachedPackages(pkgs []CachedPackage, retention CacheRetention, installed map[string]bool) []CachedPackage {
+	byPackage := make(map[string][]CachedPackage)
+	var keys []string
+	for _, pkg := range pkgs {
+		key := pkg.Name + "/" + pkg.Arch
+		if _, ok := byPackage[key]; !ok {
+			keys = append(keys, key)
+		}
+		byPackage[key] = append(byPackage[key], pkg)
+	}
+	sort.Strings(keys)
+
+	var stale []CachedPackage
+	for _, key := range keys {
+		versions := byPackage[key]
+		sort.SliceStable(versions, func(i, j int) bool {
+			return vercmp(versions[i].Version, versions[j].Version) > 0
+		})
+		keep := retention.Keep
+		if !installed[versions[0].Name] {
+			keep = retention.KeepUninstalled
+		}
+		for i := len(versions) - 1; i >= max(keep, 0); i-- {
+			stale = append(stale, versions[i])
+		}
+	}
+	return stale
+}
+
+// cacheSource deletes package files from the pacman cache.
+type cacheSource struct{ r Runner }
+
+func (s cacheSource) Kind() string                 { return SourceCache }
+func (s cacheSource) NeedsPrivilege() bool         { return true }
+func (s cacheSource) Installed() map[string]string { return nil }
+
+func (s cacheSource) Install(item string) (bool, string) {
+	path := ItemID(item)
+	if _, ok := parseCacheFile(filepath.Base(path)); !ok || filepath.Dir(path) != pacmanCachePath {
+		return false, fmt.Sprintf("%s: Not a package file in %s", path, pacmanCachePath)
+	}
+	t := s.r.target()
+	output, err := privileged("rm", "-f", t.path(path), t.path(path+".sig")).CombinedOutput()
+	if err != nil {
+		return false, fmt.Sprintf("%s: Failed to delete %v\n%s", filepath.Base(path), err, strings.Trim(string(output), "\n"))
+	}
+	return true, fmt.Sprintf("%s: Deleted from the package cache", filepath.Base(path))
+}
+
+func (s cacheSource) Remove(item string) (bool, string) {
+	return false, fmt.Sprintf("%s: Deleted package files cannot be restored", filepath.Base(ItemID(item)))
+}
+
+func (s cacheSource) Describe(item string) string {
+	pkg, ok := parseCacheFile(filepath.Base(ItemID(item)))
+	if !ok {
+		return ""
+	}
+	return pkg.Describe()
+}
+
+// Config file actions for .pacnew and .pacsave files.
+const (
+	// ConfigKeep keeps the current file and deletes the other one.
+	ConfigKeep = "keep"
+	// ConfigReplace moves the other file over the current one.
+	ConfigReplace = "replace"
+	// ConfigMerge deletes the other file after both were merged by hand,
+	// see MergeConfigCmd.
+	ConfigMerge = "merge"
+)
+
+// ConfigActions lists the config file actions.
+var ConfigActions = []string{ConfigKeep, ConfigReplace, ConfigMerge}
+
+// configSuffixes are the suffixes pacman gives config files it did not
+// overwrite (.pacnew) or that were left behind on removal (.pacsave).
+var configSuffixes = []string{".pacnew", ".pacsave"}
+
+// ConfigFile is a .pacnew or .pacsave file next to the config it belongs to.
+type ConfigFile struct {
+	// Path is the .pacnew or .pacsave file on the target.
+	Path string
+	// Original is the config file it belongs to, which may not exist.
+	Original string
+}
+
+// ConfigFiles finds the .pacnew and .pacsave files under /etc. Directories
+// the process cannot read are skipped.
+func (r Runner) ConfigFiles() ([]ConfigFile, error) {
+	t := r.target()
+	root := t.path("/etc")
+	var files []ConfigFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return fs.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if file, ok := parseConfigFile("/etc/" + filepath.ToSlash(rel)); ok {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search /etc: %v", err)
+	}
+	return files, nil
+}
+
+// parseConfigFile returns the config file of a .pacnew or .pacsave path.
+func parseConfigFile(path string) (ConfigFile, bool) {
+	for _, suffix := range configSuffixes {
+		if original, ok := strings.CutSuffix(path, suffix); ok && original != "" {
+			return ConfigFile{Path: path, Original: original}, true
+		}
+	}
+	return ConfigFile{}, false
+}
+
+// ReadConfigFile returns the content of a file on the target, or "" when it
+// does not exist.
+func (r Runner) ReadConfigFile(path string) (string, error) {
+	content, err := os.ReadFile(r.target().path(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+// WithConfigAction returns the config item applying action to the file.
+func WithConfigAction(path, action string) string {
+	return path + " [" + action + "]"
+}
+
+// ItemConfigAction returns the action of a config item, or "" if it has none.
+func ItemConfigAction(item string) string {
+	fields := strings.Fields(item)
+	if len(fields) < 2 {
+		return ""
+	}
+	for _, field := range fields[1:] {
+		action := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
+		for _, known := range ConfigActions {
+			if action == known {
+				return action
+			}
+		}
+	}
+	return ""
+}
+
+// MergeConfigCmd opens the config file and its .pacnew or .pacsave file in
+// the diff program to merge them, like pacdiff: $DIFFPROG, or vim -d when it
+// is not set. It runs as root since both files usually belong to root.
+func (r Runner) MergeConfigCmd(file ConfigFile) *exec.Cmd {
+	diffProg := strings.Fields(os.Getenv("DIFFPROG"))
+	if len(diffProg) == 0 {
+		diffProg = []string{"vim", "-d"}
+	}
+	t := r.target()
+	args := append(diffProg[1:], t.path(file.Original), t.path(file.Path))
+	return privileged(diffProg[0], args...)
+}
+
+// configSource applies config actions to .pacnew and .pacsave files.
+type configSource struct{ r Runner }
+
+func (s configSource) Kind() string                 { return SourceConfigs }
+func (s configSource) NeedsPrivilege() bool         { return true }
+func (s configSource) Installed() map[string]string { return nil }
+
+func (s configSource) Install(item string) (bool, string) {
+	file, ok := parseConfigFile(ItemID(item))
+	if !ok || !strings.HasPrefix(file.Path, "/etc/") {
+		return false, fmt.Sprintf("%s: Not a .pacnew or .pacsave file in /etc", ItemID(item))
+	}
+	t := s.r.target()
+	var cmd *exec.Cmd
+	var done string
+	switch action := ItemConfigAction(item); action {
+	case ConfigKeep:
+		cmd = privileged("rm", "-f", t.path(file.Path))
+		done = "Kept " + file.Original
+	case ConfigMerge:
+		cmd = privileged("rm", "-f", t.path(file.Path))
+		done = "Merged into " + file.Original
+	case ConfigReplace:
+		cmd = privileged("mv", "-f", t.path(file.Path), t.path(file.Original))
+		done = "Replaced " + file.Original
+	default:
+		return false, fmt.Sprintf("%s: No action chosen, expected one of %s", file.Path, strings.Join(ConfigActions, ", "))
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, fmt.Sprintf("%s: Failed %v\n%s", file.Path, err, strings.Trim(string(output), "\n"))
+	}
+	return true, fmt.Sprintf("%s: %s", file.Path, done)
+}
+
+func (s configSource) Remove(item string) (bool, string) {
+	return false, fmt.Sprintf("%s: Resolved config files cannot be restored", ItemID(item))
+}
+
+func (s configSource) Describe(item string) string {
+	file, ok := parseConfigFile(ItemID(item))
+	if !ok {
+		return ""
+	}
+	return "Config file for " + file.Original
+}
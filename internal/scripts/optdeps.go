@@ -0,0 +1,81 @@
+package scripts
+
+import (
+	"strings"
+)
+
+// asDepsAnnotation marks a package line to be installed as a dependency.
+const asDepsAnnotation = "asdeps"
+
+// OptionalDep is an optional dependency of a package with the reason the
+// package gives for it.
+type OptionalDep struct {
+	Name      string
+	Reason    string
+	Installed bool
+}
+
+// AsDependency returns the package line installing pkg as a dependency
+// (pacman --asdeps), so that it is removed with the package needing it.
+func AsDependency(pkg string) string {
+	return pkg + " [" + asDepsAnnotation + "]"
+}
+
+// IsDependency reports whether the package line installs as a dependency.
+func IsDependency(item string) bool {
+	line, _ := parsePackageLine(item)
+	return line.asDeps
+}
+
+// OptionalDeps returns the optional dependencies of an installed package
+// from the local database, falling back to the sync database.
+func (r Runner) OptionalDeps(pkg string) []OptionalDep {
+	output, err := r.target().query("-Qi", pkg).Output()
+	if err != nil {
+		if output, err = r.target().query("-Si", pkg).Output(); err != nil {
+			return nil
+		}
+	}
+	return parseOptionalDeps(string(output))
+}
+
+// parseOptionalDeps parses the "Optional Deps" field of pacman -Qi/-Si. The
+// first entry follows the field name, the others are on indented lines:
+//
+//	Optional Deps   : pipewire-alsa: ALSA configuration [installed]
+//	                  pipewire-jack: JACK support
+func parseOptionalDeps(output string) []OptionalDep {
+	var deps []OptionalDep
+	inField := false
+	for _, line := range strings.Split(output, "\n") {
+		var entry string
+		switch {
+		case strings.HasPrefix(line, "Optional Deps"):
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			inField = true
+			entry = parts[1]
+		case inField && strings.HasPrefix(line, " "):
+			entry = line
+		default:
+			inField = false
+			continue
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == "None" {
+			continue
+		}
+		dep := OptionalDep{}
+		if rest, ok := strings.CutSuffix(entry, "[installed]"); ok {
+			dep.Installed = true
+			entry = strings.TrimSpace(rest)
+		}
+		name, reason, _ := strings.Cut(entry, ":")
+		dep.Name = strings.TrimSpace(name)
+		dep.Reason = strings.TrimSpace(reason)
+		deps = append(deps, dep)
+	}
+	return deps
+}
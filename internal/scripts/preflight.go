@@ -0,0 +1,159 @@
+package scripts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// CheckStatus is the outcome of a pre-flight check.
+type CheckStatus int
+
+const (
+	CheckPass CheckStatus = iota
+	CheckWarn
+	CheckFail
+)
+
+// CheckResult is the result of one pre-flight check.
+type CheckResult struct {
+	Name    string
+	Status  CheckStatus
+	Message string
+}
+
+const (
+	pacmanLockPath  = "/var/lib/pacman/db.lck"
+	pacmanCachePath = "/var/cache/pacman/pkg"
+	keyringPackage  = "archlinux-keyring"
+)
+
+// Free space thresholds: below minFreeSpace a run fails, below lowFreeSpace
+// it is likely to.
+var (
+	minFreeSpace uint64 = 1 << 30
+	lowFreeSpace uint64 = 5 << 30
+)
+
+// PreflightChecks runs the checks that catch the usual reasons for an install
+// run to fail halfway. Package runs also check the pacman database lock, the
+// pacman cache, the keyring and pending upgrades.
+func (r Runner) PreflightChecks(source string) []CheckResult {
+	t := r.target()
+	var results []CheckResult
+	if source == SourcePackages || source == SourceOrphans || source == SourceUndo {
+		lockPath := t.path(pacmanLockPath)
+		_, err := os.Stat(lockPath)
+		results = append(results, checkDBLock(lockPath, err == nil, exec.Command("pgrep", "-x", "pacman").Run() == nil))
+	}
+	free, err := freeSpace(t.path("/"))
+	results = append(results, checkFreeSpace("/", free, err))
+	if source != SourcePackages {
+		return results
+	}
+	// The cache is missing in a freshly mounted target until pacstrap creates it.
+	free, err = freeSpace(t.path(pacmanCachePath))
+	results = append(results, checkFreeSpace(pacmanCachePath, free, err))
+
+	var local, sync string
+	if output, err := t.query("-Q", keyringPackage).Output(); err == nil {
+		if fields := strings.Fields(string(output)); len(fields) == 2 {
+			local = fields[1]
+		}
+	}
+	if output, err := t.query("-Si", keyringPackage).Output(); err == nil {
+		if entries := infoFields(string(output)); len(entries) > 0 {
+			sync = entries[0]["Version"]
+		}
+	}
+	results = append(results, checkKeyring(local, sync))
+
+	var upgradable []string
+	if output, err := t.query("-Qu").Output(); err == nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				upgradable = append(upgradable, fields[0])
+			}
+		}
+	}
+	return append(results, checkPartialUpgrade(upgradable))
+}
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system of path.
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// checkDBLock checks the lock file at lockPath, the one of the target.
+func checkDBLock(lockPath string, locked, pacmanRunning bool) CheckResult {
+	result := CheckResult{Name: "pacman database lock"}
+	switch {
+	case !locked:
+		result.Message = "not locked"
+	case pacmanRunning:
+		result.Status = CheckFail
+		result.Message = "another pacman is running, wait for it to finish"
+	default:
+		result.Status = CheckFail
+		result.Message = fmt.Sprintf("stale lock, no pacman is running: remove %s", lockPath)
+	}
+	return result
+}
+
+// checkFreeSpace checks the free space of path, err being why it could not be
+// determined. An unknown amount only warns.
+func checkFreeSpace(path string, free uint64, err error) CheckResult {
+	result := CheckResult{Name: "free space on " + path, Message: formatBytes(free) + " available"}
+	switch {
+	case err != nil:
+		result.Status = CheckWarn
+		result.Message = fmt.Sprintf("unknown: %v", err)
+	case free < minFreeSpace:
+		result.Status = CheckFail
+	case free < lowFreeSpace:
+		result.Status = CheckWarn
+	}
+	return result
+}
+
+func checkKeyring(local, sync string) CheckResult {
+	result := CheckResult{Name: keyringPackage}
+	switch {
+	case local == "":
+		result.Status = CheckWarn
+		result.Message = "not installed"
+	case sync != "" && vercmp(sync, local) > 0:
+		result.Status = CheckWarn
+		result.Message = fmt.Sprintf("%s is installed but %s is available: signatures of newer packages may fail to verify, upgrade it first", local, sync)
+	default:
+		result.Message = local + " is up to date"
+	}
+	return result
+}
+
+func checkPartialUpgrade(upgradable []string) CheckResult {
+	result := CheckResult{Name: "pending upgrades", Message: "the system is up to date"}
+	if len(upgradable) > 0 {
+		result.Status = CheckWarn
+		result.Message = fmt.Sprintf("%d package(s) are older than the sync databases: installing without upgrading first is a partial upgrade", len(upgradable))
+	}
+	return result
+}
+
+// formatBytes formats n in GiB or MiB.
+func formatBytes(n uint64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	}
+	if n < 1<<20 {
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+	return fmt.Sprintf("%d MiB", n>>20)
+}
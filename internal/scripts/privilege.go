@@ -0,0 +1,233 @@
+package scripts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Supported privilege-escalation tools, in auto-detection order.
+const (
+	PrivilegeSudo = "sudo"
+	PrivilegeDoas = "doas"
+	PrivilegeRun0 = "run0"
+)
+
+var privilegeTools = []string{PrivilegeSudo, PrivilegeDoas, PrivilegeRun0}
+
+// PrivilegeToolNames returns the names of the supported privilege tools.
+func PrivilegeToolNames() []string {
+	return slices.Clone(privilegeTools)
+}
+
+// IsPrivilegeTool reports whether name is a supported privilege tool.
+func IsPrivilegeTool(name string) bool {
+	return slices.Contains(privilegeTools, name)
+}
+
+// Paths written by the passwordless task for doas and run0.
+var (
+	doasConfPath    = "/etc/doas.conf"
+	run0PolkitRules = "/etc/polkit-1/rules.d/49-archutils-run0-nopasswd.rules"
+)
+
+// privilegeTool returns the tool used for privileged operations.
+// Override with the ARCHUTILS_PRIVILEGE environment variable (sudo, doas or run0),
+// which main validates at startup; otherwise the first tool found on PATH is
+// used, falling back to sudo.
+func privilegeTool() string {
+	if tool := os.Getenv("ARCHUTILS_PRIVILEGE"); tool != "" {
+		return tool
+	}
+	for _, tool := range privilegeTools {
+		if _, err := exec.LookPath(tool); err == nil {
+			return tool
+		}
+	}
+	return PrivilegeSudo
+}
+
+// privileged returns a command that runs name with args as root through the
+// configured privilege tool, or directly when already running as root.
+func privileged(name string, args ...string) *exec.Cmd {
+	if runningAsRoot() {
+		return exec.Command(name, args...)
+	}
+	return exec.Command(privilegeTool(), append([]string{name}, args...)...)
+}
+
+// privilegeCheckCmd returns a non-interactive command that succeeds only when
+// the privilege tool can run without prompting (cached credentials or a
+// passwordless rule).
+func privilegeCheckCmd() *exec.Cmd {
+	if runningAsRoot() {
+		return exec.Command("true")
+	}
+	switch tool := privilegeTool(); tool {
+	case PrivilegeRun0:
+		return exec.Command(tool, "--no-ask-password", "true")
+	default:
+		return exec.Command(tool, "-n", "true")
+	}
+}
+
+// privilegeRefreshCmd returns a non-interactive command that extends cached
+// credentials. Only sudo can refresh its timestamp without running a command;
+// for doas and run0 a successful no-op run proves the credentials still work.
+func privilegeRefreshCmd() *exec.Cmd {
+	if runningAsRoot() {
+		return exec.Command("true")
+	}
+	switch tool := privilegeTool(); tool {
+	case PrivilegeSudo:
+		return exec.Command(tool, "-n", "-v")
+	default:
+		return privilegeCheckCmd()
+	}
+}
+
+// privilegePromptCmd returns the interactive command that asks for credentials.
+// sudo caches them with -v; doas caches them when "persist" is set; run0 has no
+// cache, so its prompt only confirms that authentication works. Runs check the
+// cache afterwards with CheckPrivilegeCache.
+func privilegePromptCmd() *exec.Cmd {
+	if runningAsRoot() {
+		return exec.Command("true")
+	}
+	switch tool := privilegeTool(); tool {
+	case PrivilegeSudo:
+		return exec.Command(tool, "-v")
+	default:
+		return exec.Command(tool, "true")
+	}
+}
+
+// privilegeCacheError explains why the privilege tool cannot run commands
+// without prompting right after its prompt succeeded.
+func privilegeCacheError() error {
+	switch tool := privilegeTool(); tool {
+	case PrivilegeRun0:
+		return fmt.Errorf("run0 does not cache credentials, configure passwordless run0 first")
+	case PrivilegeDoas:
+		return fmt.Errorf(`doas did not cache the credentials, add "persist" to its rule in %s or configure passwordless doas first`, doasConfPath)
+	default:
+		return fmt.Errorf("%s did not cache the credentials, configure passwordless %s first", tool, tool)
+	}
+}
+
+// doasNopassRule returns the doas.conf rule granting passwordless root to user.
+func doasNopassRule(user string) string {
+	return fmt.Sprintf("permit nopass %s as root", user)
+}
+
+// run0PolkitRule returns a polkit rule that lets user run run0 without
+// authenticating.
+func run0PolkitRule(user string) string {
+	return fmt.Sprintf(`polkit.addRule(function(action, subject) {
+    if (action.id == "org.freedesktop.systemd1.manage-units" && subject.user == %q) {
+        return polkit.Result.YES;
+    }
+});
+`, user)
+}
+
+// enablePasswordlessSudo writes a sudoers drop-in for user on the target and
+// checks it with visudo.
+func enablePasswordlessSudo(t target, user string) (bool, string) {
+	sudoersPath := t.path(fmt.Sprintf("/etc/sudoers.d/%s", user))
+
+	if _, err := os.Stat(sudoersPath); err == nil {
+		return true, "Passwordless sudo is already configured"
+	}
+
+	sudoersContent := fmt.Sprintf("%s ALL=(ALL) NOPASSWD: ALL\n", user)
+
+	cmd := privileged("tee", sudoersPath)
+	cmd.Stdin = strings.NewReader(sudoersContent)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to configure passwordless sudo: %v\n%s", err, strings.TrimSpace(string(output)))
+	}
+
+	checkSudoersCmd := privileged("visudo", "-c", "-f", sudoersPath)
+	if checkOutput, checkErr := checkSudoersCmd.CombinedOutput(); checkErr != nil {
+		privileged("rm", "-f", sudoersPath).Run()
+		return false, fmt.Sprintf("Sudoers file syntax error: %s", strings.TrimSpace(string(checkOutput)))
+	}
+
+	permCmd := privileged("chmod", "440", sudoersPath)
+	if err := permCmd.Run(); err != nil {
+		return false, fmt.Sprintf("Failed to set permissions on sudoers file: %v", err)
+	}
+	return true, "Passwordless sudo configured successfully"
+}
+
+// enablePasswordlessDoas appends a nopass rule for user to doas.conf. The new
+// file is checked with doas -C before it replaces the current one.
+func enablePasswordlessDoas(t target, user string) (bool, string) {
+	rule := doasNopassRule(user)
+	confPath := t.path(doasConfPath)
+
+	current, err := privileged("cat", confPath).Output()
+	if err != nil {
+		current = nil
+	}
+	for _, line := range strings.Split(string(current), "\n") {
+		if strings.TrimSpace(line) == rule {
+			return true, "Passwordless doas is already configured"
+		}
+	}
+
+	content := string(current)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += rule + "\n"
+
+	tmpPath := confPath + ".archutils"
+	cmd := privileged("tee", tmpPath)
+	cmd.Stdin = strings.NewReader(content)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to write %s: %v\n%s", tmpPath, err, strings.TrimSpace(string(output)))
+	}
+
+	checkCmd := exec.Command("doas", "-C", tmpPath)
+	if t.chrooted() {
+		// Check with the target's doas, which sees the file without the root prefix.
+		checkCmd = t.run("doas", "-C", doasConfPath+".archutils")
+	}
+	if output, err := checkCmd.CombinedOutput(); err != nil {
+		privileged("rm", "-f", tmpPath).Run()
+		return false, fmt.Sprintf("doas.conf syntax error: %s", strings.TrimSpace(string(output)))
+	}
+
+	if err := privileged("chmod", "400", tmpPath).Run(); err != nil {
+		return false, fmt.Sprintf("Failed to set permissions on %s: %v", tmpPath, err)
+	}
+	if output, err := privileged("mv", tmpPath, confPath).CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to install %s: %v\n%s", confPath, err, strings.TrimSpace(string(output)))
+	}
+	return true, "Passwordless doas configured successfully"
+}
+
+// enablePasswordlessRun0 installs a polkit rule that skips authentication for
+// run0 invocations by user on the target.
+func enablePasswordlessRun0(t target, user string) (bool, string) {
+	rulesPath := t.path(run0PolkitRules)
+	if _, err := os.Stat(rulesPath); err == nil {
+		return true, "Passwordless run0 is already configured"
+	}
+
+	if err := privileged("mkdir", "-p", filepath.Dir(rulesPath)).Run(); err != nil {
+		return false, fmt.Sprintf("Failed to create directory %s: %v", filepath.Dir(rulesPath), err)
+	}
+
+	cmd := privileged("tee", rulesPath)
+	cmd.Stdin = strings.NewReader(run0PolkitRule(user))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to write polkit rule: %v\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return true, "Passwordless run0 configured successfully"
+}
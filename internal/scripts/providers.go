@@ -0,0 +1,77 @@
+package scripts
+
+import (
+	"strings"
+)
+
+// providerAnnotation prefixes the packages chosen to install an item that is
+// a virtual package or a package group, e.g. "java-runtime [provider:jre-openjdk]".
+const providerAnnotation = "provider:"
+
+// ProviderChoice is a selected item that several packages can install.
+type ProviderChoice struct {
+	// Item is the package line as selected.
+	Item string
+	// Group is set for package groups, of which any members can be chosen;
+	// a virtual package needs exactly one provider.
+	Group bool
+	// Options lists the providers or group members in repository order.
+	Options []string
+}
+
+// ItemProviders returns the packages chosen for an item with [provider:]
+// annotations.
+func ItemProviders(item string) []string {
+	line, _ := parsePackageLine(item)
+	return line.providers
+}
+
+// WithProviders returns the package line with its [provider:] annotations
+// replaced by providers.
+func WithProviders(item string, providers []string) string {
+	var fields []string
+	for _, field := range strings.Fields(item) {
+		if !strings.HasPrefix(field, "["+providerAnnotation) {
+			fields = append(fields, field)
+		}
+	}
+	for _, p := range providers {
+		fields = append(fields, "["+providerAnnotation+p+"]")
+	}
+	return strings.Join(fields, " ")
+}
+
+// ProviderChoices returns the items that are not sync packages themselves but
+// virtual packages with several providers or package groups, i.e. the ones
+// an AUR helper would resolve on its own under --noconfirm.
+func (r Runner) ProviderChoices(items []string) []ProviderChoice {
+	t := r.target()
+	return providerChoices(items, getSyncPackages(t), getSyncInfo(t), getSyncGroups(t))
+}
+
+func providerChoices(items []string, syncPkgs map[string]bool, infos []packageInfo, groups map[string][]string) []ProviderChoice {
+	var choices []ProviderChoice
+	for _, item := range items {
+		id := ItemID(item)
+		if id == "" || syncPkgs[id] {
+			continue
+		}
+		if members := groups[id]; len(members) > 0 {
+			choices = append(choices, ProviderChoice{Item: item, Group: true, Options: members})
+			continue
+		}
+		var providers []string
+		for _, info := range infos {
+			for _, provided := range info.provides {
+				if provided == id {
+					providers = append(providers, info.name)
+					break
+				}
+			}
+		}
+		if len(providers) > 1 {
+			choices = append(choices, ProviderChoice{Item: item, Options: providers})
+		}
+	}
+	return choices
+}
@@ -11,6 +11,9 @@ import (
 var (
 	extCacheOnce sync.Once
 	extCache     map[string]bool
+
+	syncCacheOnce sync.Once
+	syncCache     map[string]bool
 )
 
 // editorBinary returns the editor binary to use for extension management.
@@ -23,12 +26,13 @@ func editorBinary() string {
 	return "codium"
 }
 
-// getInstalledExtensions returns a lazily-loaded set of installed VSCode/VSCodium extensions.
-// The result is cached via sync.Once for thread-safe one-time initialization.
-func getInstalledExtensions() map[string]bool {
+// getInstalledExtensions returns a lazily-loaded set of installed VSCode/VSCodium extensions,
+// listed by the command from listCmd. The result is cached via sync.Once for thread-safe
+// one-time initialization.
+func getInstalledExtensions(listCmd func() *exec.Cmd) map[string]bool {
 	extCacheOnce.Do(func() {
 		extCache = make(map[string]bool)
-		cmd := exec.Command(editorBinary(), "--list-extensions")
+		cmd := listCmd()
 		output, err := cmd.Output()
 		if err != nil {
 			return
@@ -42,14 +46,123 @@ func getInstalledExtensions() map[string]bool {
 	return extCache
 }
 
-// enableService runs systemctl enable --now for the given service.
-func enableService(service string, userLevel bool) (bool, string) {
-	var cmd *exec.Cmd
-	if userLevel {
-		cmd = exec.Command("systemctl", "--user", "enable", "--now", service)
-	} else {
-		cmd = exec.Command("sudo", "systemctl", "enable", "--now", service)
+// getSyncPackages returns a lazily-loaded set of package names available in
+// the sync repositories of the target (pacman -Slq). Anything not in this set
+// is assumed to come from the AUR.
+func getSyncPackages(t target) map[string]bool {
+	syncCacheOnce.Do(func() {
+		syncCache = make(map[string]bool)
+		output, err := t.query("-Slq").Output()
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if name := strings.TrimSpace(line); name != "" {
+				syncCache[name] = true
+			}
+		}
+	})
+	return syncCache
+}
+
+// packageLine is a parsed line of a packages category file, e.g.
+// "docker [docker] [group:docker]" or "syncthing [syncthing] [user]".
+type packageLine struct {
+	name      string
+	services  []string
+	groups    []string
+	userLevel bool
+	// asDeps installs the package as a dependency, see AsDependency.
+	asDeps bool
+	// providers are the packages chosen for a virtual package or group.
+	providers []string
+	// replaced are installed packages removed before installing, see
+	// WithReplaced.
+	replaced []string
+	// approved is the AUR commit whose build files were approved, see
+	// WithApproval.
+	approved string
+}
+
+// packages returns the packages installing the line: the chosen providers,
+// or the package itself.
+func (l packageLine) packages() []string {
+	if len(l.providers) > 0 {
+		return l.providers
+	}
+	return []string{l.name}
+}
+
+// targets returns the pacman targets installing the line's package.
+func (l packageLine) targets() []string {
+	if l.asDeps {
+		return append([]string{"--asdeps"}, l.packages()...)
 	}
+	return l.packages()
+}
+
+// groupAnnotation prefixes the groups the target user is added to after the
+// package is installed.
+const groupAnnotation = "group:"
+
+// parsePackageLine splits a package line into its name and bracketed annotations.
+func parsePackageLine(line string) (packageLine, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return packageLine{}, false
+	}
+	parsed := packageLine{name: fields[0]}
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			content := strings.TrimSuffix(strings.TrimPrefix(field, "["), "]")
+			switch {
+			case content == "user":
+				parsed.userLevel = true
+			case content == asDepsAnnotation:
+				parsed.asDeps = true
+			case strings.HasPrefix(content, replaceAnnotation):
+				if pkg := strings.TrimPrefix(content, replaceAnnotation); pkg != "" {
+					parsed.replaced = append(parsed.replaced, pkg)
+				}
+			case strings.HasPrefix(content, providerAnnotation):
+				if provider := strings.TrimPrefix(content, providerAnnotation); provider != "" {
+					parsed.providers = append(parsed.providers, provider)
+				}
+			case strings.HasPrefix(content, approvedAnnotation):
+				parsed.approved = strings.TrimPrefix(content, approvedAnnotation)
+			case strings.HasPrefix(content, groupAnnotation):
+				if group := strings.TrimPrefix(content, groupAnnotation); group != "" {
+					parsed.groups = append(parsed.groups, group)
+				}
+			default:
+				parsed.services = append(parsed.services, content)
+			}
+		}
+	}
+	return parsed, true
+}
+
+// ItemGroups returns the groups a package line adds the target user to.
+func ItemGroups(item string) []string {
+	line, _ := parsePackageLine(item)
+	return line.groups
+}
+
+// addUserToGroup creates the group on the target if needed and adds user to it.
+func addUserToGroup(t target, group, user string) (bool, string) {
+	if output, err := t.run("groupadd", "-f", "-r", group).CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to create group \033[31m%s\033[0m: %v\n%s", group, err, strings.Trim(string(output), "\n"))
+	}
+	if output, err := t.run("usermod", "-aG", group, user).CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to add %s to group \033[31m%s\033[0m: %v\n%s", user, group, err, strings.Trim(string(output), "\n"))
+	}
+	return true, fmt.Sprintf("%s added to group \033[32m%s\033[0m", user, group)
+}
+
+// enableService enables the given service on the target, starting it too on
+// the running system. User-level services are enabled for user.
+func enableService(t target, service string, userLevel bool, user string) (bool, string) {
+	cmd := t.enableUnit(service, userLevel, user)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return false, fmt.Sprintf("Failed to enable \033[31m%s\033[0m: %v\n%s", service, err, strings.Trim(string(output), "\n"))
@@ -58,14 +171,14 @@ func enableService(service string, userLevel bool) (bool, string) {
 }
 
 // disableSSHPasswordAuth writes a drop-in config disabling SSH password auth.
-func disableSSHPasswordAuth() (bool, string) {
-	cmd1 := exec.Command("sudo", "mkdir", "-p", "/etc/ssh/ssh_config.d")
+func disableSSHPasswordAuth(t target) (bool, string) {
+	cmd1 := privileged("mkdir", "-p", t.path("/etc/ssh/ssh_config.d"))
 	if err := cmd1.Run(); err != nil {
 		return false, fmt.Sprintf("Failed to create directory /etc/ssh/ssh_config.d: %v", err)
 	}
 
 	configContent := "PasswordAuthentication no\n"
-	cmd2 := exec.Command("sudo", "tee", "/etc/ssh/ssh_config.d/disable_password.conf")
+	cmd2 := privileged("tee", t.path("/etc/ssh/ssh_config.d/disable_password.conf"))
 	cmd2.Stdin = strings.NewReader(configContent)
 	if err := cmd2.Run(); err != nil {
 		return false, fmt.Sprintf("Failed to write disable_password.conf: %v", err)
@@ -2,9 +2,25 @@ package scripts
 
 import (
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 )
 
+// TestMain pins the tests to non-root mode so that results do not depend on
+// the user running them; root-mode tests opt in with asRootForTest.
+func TestMain(m *testing.M) {
+	geteuid = func() int { return 1000 }
+	os.Exit(m.Run())
+}
+
+func asRootForTest(t *testing.T) {
+	geteuid = func() int { return 0 }
+	t.Cleanup(func() { geteuid = func() int { return 1000 } })
+}
+
 func TestEditorBinary_Default(t *testing.T) {
 	// Ensure env var is unset
 	os.Unsetenv("ARCHUTILS_EDITOR")
@@ -33,3 +49,1131 @@ func TestEditorBinary_EnvOverrideCustom(t *testing.T) {
 		t.Errorf("expected 'code-oss' from env, got %q", got)
 	}
 }
+
+func TestReadLoginUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	passwd := "root:x:0:0::/root:/bin/bash\n" +
+		"nobody:x:65534:65534:Kernel Overflow User:/:/usr/bin/nologin\n" +
+		"alice:x:1000:1000::/home/alice:/bin/zsh\n" +
+		"svc:x:1001:1001::/var/lib/svc:/usr/bin/false\n" +
+		"bob:x:1002:1002::/home/bob:/bin/bash\n"
+	if err := os.WriteFile(path, []byte(passwd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := readLoginUsers(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"alice", "bob"}
+	if strings.Join(users, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v, got %v", want, users)
+	}
+}
+
+func TestRenderAutologinConf(t *testing.T) {
+	template := "# path /etc/systemd/system/getty@$TTY.service.d/\n[Service]\nExecStart=-/sbin/agetty --autologin $USER %I $TERM\n$ENVIRONMENT\n"
+
+	got := renderAutologinConf(template, AutologinOptions{
+		TTY:         "tty2",
+		User:        "alice",
+		SessionType: "x11",
+		Environment: []string{"FOO=bar", `GREETING=hello "you" 100%`},
+	})
+	want := "# path /etc/systemd/system/getty@tty2.service.d/\n[Service]\nExecStart=-/sbin/agetty --autologin alice %I $TERM\nEnvironment=XDG_SESSION_TYPE=x11\nEnvironment=FOO=bar\n" +
+		`Environment="GREETING=hello \"you\" 100%%"` + "\n"
+	if got != want {
+		t.Errorf("unexpected render:\n%s\nwant:\n%s", got, want)
+	}
+
+	got = renderAutologinConf(template, AutologinOptions{TTY: "tty1", User: "bob"})
+	if strings.Contains(got, "Environment") || strings.Contains(got, "$ENVIRONMENT") {
+		t.Errorf("expected no environment lines, got:\n%s", got)
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	got, err := ParseEnvironment(`FOO="a b"  BAR=c 'BAZ=d e' QUX=f\ g`)
+	want := []string{"FOO=a b", "BAR=c", "BAZ=d e", "QUX=f g"}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q (%v)", want, got, err)
+	}
+	if got, err := ParseEnvironment("  "); err != nil || len(got) != 0 {
+		t.Errorf("expected no entries, got %q (%v)", got, err)
+	}
+	if _, err := ParseEnvironment(`FOO="a b`); err == nil {
+		t.Error("expected an unterminated quote to be rejected")
+	}
+}
+
+func TestAutologinOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    AutologinOptions
+		wantErr bool
+	}{
+		{"valid", AutologinOptions{TTY: "tty1", User: "alice", Environment: []string{"A=b"}}, false},
+		{"bad tty", AutologinOptions{TTY: "pts/0", User: "alice"}, true},
+		{"no user", AutologinOptions{TTY: "tty1"}, true},
+		{"bad env", AutologinOptions{TTY: "tty1", User: "alice", Environment: []string{"novalue"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPrivilegeTool_EnvOverride(t *testing.T) {
+	os.Setenv("ARCHUTILS_PRIVILEGE", "doas")
+	defer os.Unsetenv("ARCHUTILS_PRIVILEGE")
+
+	if got := privilegeTool(); got != "doas" {
+		t.Errorf("expected 'doas' from env, got %q", got)
+	}
+	cmd := privileged("systemctl", "daemon-reload")
+	if want := []string{"doas", "systemctl", "daemon-reload"}; strings.Join(cmd.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("expected args %v, got %v", want, cmd.Args)
+	}
+}
+
+func TestIsPrivilegeTool(t *testing.T) {
+	for _, tool := range PrivilegeToolNames() {
+		if !IsPrivilegeTool(tool) {
+			t.Errorf("expected %s to be supported", tool)
+		}
+	}
+	for _, tool := range []string{"", "su", "pkexec", "SUDO"} {
+		if IsPrivilegeTool(tool) {
+			t.Errorf("expected %q to be refused", tool)
+		}
+	}
+}
+
+func TestCheckPrivilegeCache(t *testing.T) {
+	// No tool is found, as when run0 or doas without persist refuse to run
+	// without prompting.
+	t.Setenv("PATH", t.TempDir())
+	for tool, want := range map[string]string{
+		"run0": "configure passwordless run0",
+		"doas": `add "persist"`,
+	} {
+		t.Setenv("ARCHUTILS_PRIVILEGE", tool)
+		if err := (Runner{}).CheckPrivilegeCache(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", tool, want, err)
+		}
+	}
+}
+
+func TestInstallPackage_GroupWithoutUser(t *testing.T) {
+	r := Runner{Root: t.TempDir()}
+	ok, msg := r.InstallPackage("docker [docker] [group:docker]")
+	if ok || !strings.HasPrefix(msg, "docker: Not installed, [group:docker] needs a user") || !strings.Contains(msg, "pass --user") {
+		t.Errorf("expected the install to be refused before it ran, got %v %q", ok, msg)
+	}
+}
+
+func TestPrivilegeCheckAndPromptCmds(t *testing.T) {
+	defer os.Unsetenv("ARCHUTILS_PRIVILEGE")
+	tests := []struct {
+		tool       string
+		wantCheck  string
+		wantPrompt string
+	}{
+		{"sudo", "sudo -n true", "sudo -v"},
+		{"doas", "doas -n true", "doas true"},
+		{"run0", "run0 --no-ask-password true", "run0 true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			os.Setenv("ARCHUTILS_PRIVILEGE", tt.tool)
+			if got := strings.Join(privilegeCheckCmd().Args, " "); got != tt.wantCheck {
+				t.Errorf("check: expected %q, got %q", tt.wantCheck, got)
+			}
+			if got := strings.Join(privilegePromptCmd().Args, " "); got != tt.wantPrompt {
+				t.Errorf("prompt: expected %q, got %q", tt.wantPrompt, got)
+			}
+		})
+	}
+}
+
+func TestPasswordlessRules(t *testing.T) {
+	if got := doasNopassRule("alice"); got != "permit nopass alice as root" {
+		t.Errorf("unexpected doas rule %q", got)
+	}
+	if rule := run0PolkitRule("alice"); !strings.Contains(rule, `subject.user == "alice"`) {
+		t.Errorf("expected polkit rule to match alice, got:\n%s", rule)
+	}
+}
+
+func TestParsePackageLine(t *testing.T) {
+	line, ok := parsePackageLine("syncthing [syncthing] [user]")
+	if !ok {
+		t.Fatal("expected line to parse")
+	}
+	if line.name != "syncthing" || !line.userLevel || len(line.services) != 1 || line.services[0] != "syncthing" {
+		t.Errorf("unexpected parse: %+v", line)
+	}
+
+	line, _ = parsePackageLine("docker [docker] [containerd]")
+	if line.userLevel || len(line.services) != 2 {
+		t.Errorf("unexpected parse: %+v", line)
+	}
+
+	line, _ = parsePackageLine("docker [docker] [group:docker] [group:]")
+	if len(line.services) != 1 || len(line.groups) != 1 || line.groups[0] != "docker" {
+		t.Errorf("unexpected parse: %+v", line)
+	}
+	if groups := ItemGroups("virt-manager [libvirtd] [group:libvirt]"); len(groups) != 1 || groups[0] != "libvirt" {
+		t.Errorf("unexpected groups %v", groups)
+	}
+
+	if _, ok := parsePackageLine("   "); ok {
+		t.Error("expected empty line to be rejected")
+	}
+}
+
+func TestDetectAURHelper(t *testing.T) {
+	if h := detectAURHelper("yay"); h.Name != "yay" {
+		t.Errorf("expected explicit 'yay', got %q", h.Name)
+	}
+
+	os.Setenv("ARCHUTILS_AUR_HELPER", "pikaur")
+	defer os.Unsetenv("ARCHUTILS_AUR_HELPER")
+	if h := detectAURHelper(""); h.Name != "pikaur" {
+		t.Errorf("expected 'pikaur' from env, got %q", h.Name)
+	}
+	if h := detectAURHelper("paru"); h.Name != "paru" {
+		t.Errorf("expected explicit name to win over env, got %q", h.Name)
+	}
+}
+
+func TestAURHelperCommands(t *testing.T) {
+	os.Setenv("ARCHUTILS_PRIVILEGE", "doas")
+	defer os.Unsetenv("ARCHUTILS_PRIVILEGE")
+
+	yay, _ := LookupAURHelper("yay")
+	if got := strings.Join(yay.installCmd(target{}, "foo").Args, " "); got != "yay -S --needed --noconfirm --sudo doas foo" {
+		t.Errorf("unexpected yay install command %q", got)
+	}
+	pikaur, _ := LookupAURHelper("pikaur")
+	if got := strings.Join(pikaur.installCmd(target{}, "foo").Args, " "); got != "pikaur -S --needed --noconfirm foo" {
+		t.Errorf("unexpected pikaur install command %q", got)
+	}
+
+	steps := yay.bootstrapSteps(target{}, BootstrapOptions{Strategy: BootstrapSource})
+	if len(steps) != 5 {
+		t.Fatalf("expected 5 source steps, got %d", len(steps))
+	}
+	if clone := strings.Join(steps[2].cmd().Args, " "); !strings.Contains(clone, "https://aur.archlinux.org/yay.git") {
+		t.Errorf("expected yay clone URL, got %q", clone)
+	}
+
+	bin := yay.bootstrapSteps(target{}, BootstrapOptions{Strategy: BootstrapBinary})
+	if clone := strings.Join(bin[2].cmd().Args, " "); !strings.Contains(clone, "https://aur.archlinux.org/yay-bin.git") {
+		t.Errorf("expected yay-bin clone URL, got %q", clone)
+	}
+	if deps := strings.Join(bin[1].cmd().Args, " "); strings.Contains(deps, " go") {
+		t.Errorf("binary strategy should not install the go toolchain, got %q", deps)
+	}
+
+	local := yay.bootstrapSteps(target{}, BootstrapOptions{Strategy: BootstrapLocalFile, Path: "/srv/yay-12.0-1-x86_64.pkg.tar.zst"})
+	if got := strings.Join(local[len(local)-1].cmd().Args, " "); got != "doas pacman -U --noconfirm /srv/yay-12.0-1-x86_64.pkg.tar.zst" {
+		t.Errorf("unexpected local file install command %q", got)
+	}
+
+	repo := yay.bootstrapSteps(target{}, BootstrapOptions{Strategy: BootstrapLocalRepo, Repo: "custom"})
+	if got := strings.Join(repo[len(repo)-1].cmd().Args, " "); got != "doas pacman -S --needed --noconfirm custom/yay" {
+		t.Errorf("unexpected local repo install command %q", got)
+	}
+}
+
+func TestBootstrapOptionsValidate(t *testing.T) {
+	yay, _ := LookupAURHelper("yay")
+	pikaur, _ := LookupAURHelper("pikaur")
+
+	pkg := filepath.Join(t.TempDir(), "yay-12.0-1-x86_64.pkg.tar.zst")
+	if err := os.WriteFile(pkg, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		helper  AURHelper
+		opts    BootstrapOptions
+		wantErr bool
+	}{
+		{"source", yay, BootstrapOptions{Strategy: BootstrapSource}, false},
+		{"binary", yay, BootstrapOptions{Strategy: BootstrapBinary}, false},
+		{"binary unavailable", pikaur, BootstrapOptions{Strategy: BootstrapBinary}, true},
+		{"local file", yay, BootstrapOptions{Strategy: BootstrapLocalFile, Path: pkg}, false},
+		{"local file missing", yay, BootstrapOptions{Strategy: BootstrapLocalFile, Path: pkg + ".missing.pkg.tar.zst"}, true},
+		{"local file wrong type", yay, BootstrapOptions{Strategy: BootstrapLocalFile, Path: "/etc/hostname"}, true},
+		{"local repo", yay, BootstrapOptions{Strategy: BootstrapLocalRepo}, false},
+		{"local repo invalid", yay, BootstrapOptions{Strategy: BootstrapLocalRepo, Repo: "a/b"}, true},
+		{"unknown", yay, BootstrapOptions{Strategy: "magic"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.Validate(tt.helper); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if got := pikaur.BootstrapStrategies(); len(got) != 3 {
+		t.Errorf("expected pikaur to offer 3 strategies, got %v", got)
+	}
+}
+
+func TestHelperTitle(t *testing.T) {
+	if got := HelperTitle("paru"); got != "Paru" {
+		t.Errorf("expected 'Paru', got %q", got)
+	}
+}
+
+func TestReadBuildFiles(t *testing.T) {
+	dir := t.TempDir()
+	pkgbuild := "pkgname=foo\npkgver=1\ninstall=\"foo.install\"\n"
+	os.WriteFile(filepath.Join(dir, "PKGBUILD"), []byte(pkgbuild), 0o644)
+	os.WriteFile(filepath.Join(dir, "foo.install"), []byte("post_install() { :; }\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "foo.patch"), []byte("--- a\n"), 0o644)
+
+	files, err := readBuildFiles(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 2 || files["PKGBUILD"] != pkgbuild || files["foo.install"] == "" {
+		t.Errorf("expected PKGBUILD and foo.install, got %v", files)
+	}
+
+	if _, err := readBuildFiles(t.TempDir()); err == nil {
+		t.Error("expected an error without a PKGBUILD")
+	}
+}
+
+func TestRootMode_PrivilegedRunsDirectly(t *testing.T) {
+	asRootForTest(t)
+	os.Setenv("ARCHUTILS_PRIVILEGE", "doas")
+	defer os.Unsetenv("ARCHUTILS_PRIVILEGE")
+
+	if got := strings.Join(privileged("pacman", "-Syu").Args, " "); got != "pacman -Syu" {
+		t.Errorf("expected pacman to run directly as root, got %q", got)
+	}
+	if got := strings.Join(privilegePromptCmd().Args, " "); got != "true" {
+		t.Errorf("expected no credential prompt as root, got %q", got)
+	}
+}
+
+func TestRootMode_AURBuildsUseBuildUser(t *testing.T) {
+	asRootForTest(t)
+
+	yay, _ := LookupAURHelper("yay")
+	cmd := yay.installCmd(target{}, "--asdeps", "foo")
+	if got := cmd.Args; got[0] != "sh" || got[len(got)-2] != "--asdeps" || got[len(got)-1] != "foo" {
+		t.Errorf("expected a root script installing foo as a dependency, got %q", got)
+	}
+	script := cmd.Args[2]
+	for _, want := range []string{
+		"runuser -u " + buildUser + " -- env HOME=" + buildUserHome,
+		"as_build makepkg -f --noconfirm",
+		"pacman -S --needed --asdeps --noconfirm $missing",
+		"pacman -U --noconfirm $3 $pkgfile",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected the root script to contain %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "sudo") || strings.Contains(script, "as_build yay -S") {
+		t.Errorf("expected the build user to only build, got:\n%s", script)
+	}
+
+	steps := yay.bootstrapSteps(target{}, BootstrapOptions{Strategy: BootstrapSource})
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	if len(steps) != 6 || !strings.Contains(names[2], buildUser) {
+		t.Fatalf("expected a build user step before cloning, got %v", names)
+	}
+	build := steps[4].cmd()
+	if got := strings.Join(build.Args, " "); build.Args[0] != "runuser" || !strings.Contains(got, "cd "+buildUserHome+"/yay && makepkg") {
+		t.Errorf("expected makepkg to run as the build user in its home, got %q", got)
+	}
+	if install := strings.Join(steps[5].cmd().Args, " "); strings.Contains(install, "sudo") {
+		t.Errorf("expected the final install to run pacman directly, got %q", install)
+	}
+}
+
+func TestBuildUserSetupScript(t *testing.T) {
+	script := buildUserSetupScript()
+	for _, want := range []string{
+		"useradd --system --create-home --home-dir " + buildUserHome,
+		"rm -f " + buildUserSudoers,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected setup script to contain %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "NOPASSWD") {
+		t.Errorf("expected the build user to get no sudo rule:\n%s", script)
+	}
+}
+
+func TestEnsureBuildUser_OncePerRun(t *testing.T) {
+	asRootForTest(t)
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls")
+	stub := "#!/bin/sh\necho x >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "sh"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Cleanup(func() { preparedBuildUsers.roots = map[string]bool{} })
+
+	for range 3 {
+		if err := ensureBuildUser(target{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := os.ReadFile(calls)
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("expected the build user to be prepared once, got %d runs", n)
+	}
+}
+
+func TestTargetMode_CommandsAndPaths(t *testing.T) {
+	tgt := target{root: "/mnt"}
+	os.Setenv("ARCHUTILS_PRIVILEGE", "sudo")
+	defer os.Unsetenv("ARCHUTILS_PRIVILEGE")
+
+	if got := tgt.path("/etc/sudoers.d/alice"); got != "/mnt/etc/sudoers.d/alice" {
+		t.Errorf("unexpected target path %q", got)
+	}
+	if got := (target{}).path("/etc/doas.conf"); got != "/etc/doas.conf" {
+		t.Errorf("expected host paths unchanged, got %q", got)
+	}
+
+	tests := []struct {
+		name string
+		got  []string
+		want string
+	}{
+		{"repo install", tgt.installRepoPackages("git").Args, "sudo pacstrap /mnt --needed git"},
+		{"system unit", tgt.enableUnit("sshd", false, "").Args, "sudo systemctl --root=/mnt enable sshd"},
+		{"user unit", tgt.enableUnit("syncthing", true, "alice").Args, "sudo systemctl --root=/mnt --global enable syncthing"},
+		{"host unit", target{}.enableUnit("sshd", false, "").Args, "sudo systemctl enable --now sshd"},
+		{"query", tgt.query("-Q", "git").Args, "pacman --root /mnt -Q git"},
+		{"chroot command", tgt.run("id", "-nG", "alice").Args, "sudo arch-chroot /mnt id -nG alice"},
+		{"as user", tgt.asUser("alice", "codium", "--list-extensions").Args, "sudo arch-chroot /mnt runuser -u alice -- codium --list-extensions"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tt.got, " "); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestTargetMode_HelperBootstrap(t *testing.T) {
+	tgt := target{root: "/mnt"}
+	yay, _ := LookupAURHelper("yay")
+
+	steps := yay.bootstrapSteps(tgt, BootstrapOptions{Strategy: BootstrapSource})
+	if len(steps) != 6 {
+		t.Fatalf("expected a build user step in target mode, got %d steps", len(steps))
+	}
+	for i, step := range steps {
+		if args := strings.Join(step.cmd().Args, " "); !strings.Contains(args, "arch-chroot /mnt") {
+			t.Errorf("step %d (%s) should run inside the target, got %q", i, step.name, args)
+		}
+	}
+
+	local := yay.bootstrapSteps(tgt, BootstrapOptions{Strategy: BootstrapLocalFile, Path: "/root/yay-12-1-x86_64.pkg.tar.zst"})
+	if got := strings.Join(local[1].cmd().Args, " "); !strings.HasSuffix(got, "cp /root/yay-12-1-x86_64.pkg.tar.zst /mnt/var/cache/pacman/pkg/yay-12-1-x86_64.pkg.tar.zst") {
+		t.Errorf("expected the package to be copied into the target, got %q", got)
+	}
+}
+
+func TestRunnerTargetUser(t *testing.T) {
+	t.Setenv("SUDO_USER", "host-user")
+	if got := (Runner{}).targetUser(); got != "host-user" {
+		t.Errorf("expected the invoking user on the host, got %q", got)
+	}
+	if got := (Runner{Root: "/mnt"}).targetUser(); got != "" {
+		t.Errorf("expected no implicit user for a target system, got %q", got)
+	}
+	if got := (Runner{Root: "/mnt", User: "alice"}).targetUser(); got != "alice" {
+		t.Errorf("expected the explicit user, got %q", got)
+	}
+	if ok, msg := (Runner{Root: "/mnt"}).AddUserToWheel(); ok || !strings.Contains(msg, "--user") {
+		t.Errorf("expected a missing --user error, got %v %q", ok, msg)
+	}
+}
+
+func TestInvokingUser(t *testing.T) {
+	t.Setenv("USER", "stale")
+	t.Setenv("SUDO_USER", "alice")
+	t.Setenv("DOAS_USER", "")
+	if got := invokingUser(); got != "alice" {
+		t.Errorf("expected SUDO_USER to win, got %q", got)
+	}
+	t.Setenv("SUDO_USER", "root")
+	t.Setenv("DOAS_USER", "bob")
+	if got := invokingUser(); got != "bob" {
+		t.Errorf("expected DOAS_USER when SUDO_USER is root, got %q", got)
+	}
+	t.Setenv("DOAS_USER", "")
+	if got := invokingUser(); got != currentUsername() || got == "stale" {
+		t.Errorf("expected the process owner, got %q", got)
+	}
+	if got := (Runner{}).WithUser("carol").TargetUser(); got != "carol" {
+		t.Errorf("expected the picked user, got %q", got)
+	}
+}
+
+func TestUserHomeAndUserUnits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	content := "root:x:0:0::/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/zsh\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := userHome(path, "alice"); got != "/home/alice" {
+		t.Errorf("expected /home/alice, got %q", got)
+	}
+	if got := userHome(path, "nobody"); got != "" {
+		t.Errorf("expected no home for an unknown user, got %q", got)
+	}
+
+	t.Setenv("ARCHUTILS_PRIVILEGE", "sudo")
+	other := "archutils-test-other-user"
+	if got := strings.Join(target{}.enableUnit("syncthing", true, other).Args, " "); got != "sudo systemctl --user --machine="+other+"@ enable --now syncthing" {
+		t.Errorf("unexpected user unit command for another user %q", got)
+	}
+	if got := strings.Join(target{}.enableUnit("syncthing", true, currentUsername()).Args, " "); got != "systemctl --user enable --now syncthing" {
+		t.Errorf("unexpected user unit command for the process owner %q", got)
+	}
+}
+
+func TestFlatpakLinesAndCommands(t *testing.T) {
+	line, ok := parseFlatpakLine("com.spotify.Client [user]")
+	if !ok || line.id != "com.spotify.Client" || !line.user {
+		t.Errorf("unexpected parse result %+v", line)
+	}
+	if line, _ := parseFlatpakLine("org.videolan.VLC"); line.user || line.scope() != "system" {
+		t.Errorf("expected system scope by default, got %+v", line)
+	}
+
+	t.Setenv("ARCHUTILS_PRIVILEGE", "sudo")
+	if got := strings.Join(flatpakCmd(target{}, "", false, "install", "-y", "flathub", "org.videolan.VLC").Args, " "); got != "sudo flatpak install --system -y flathub org.videolan.VLC" {
+		t.Errorf("unexpected system install command %q", got)
+	}
+	got := strings.Join(flatpakCmd(target{root: "/mnt"}, "alice", true, "install", "flathub", "x").Args, " ")
+	if got != "sudo arch-chroot /mnt runuser -u alice -- flatpak install --user flathub x" {
+		t.Errorf("unexpected user install command in a target %q", got)
+	}
+
+	apps := parseFlatpakList("org.videolan.VLC\tMedia player\ncom.github.tchx84.Flatseal\tManage permissions\n\n")
+	if len(apps) != 2 || apps["org.videolan.VLC"] != "Media player" {
+		t.Errorf("unexpected flatpak list %v", apps)
+	}
+}
+
+func TestSources(t *testing.T) {
+	r := Runner{}
+	for _, kind := range []string{SourcePackages, SourceVSCode, SourceFlatpak, SourcePipx, SourceCargo, SourceNpm, SourceGo} {
+		if src := r.Source(kind); src == nil || src.Kind() != kind {
+			t.Errorf("expected a %s source, got %v", kind, src)
+		}
+	}
+	if r.Source("snap") != nil {
+		t.Error("expected no source for an unknown kind")
+	}
+	if got := ItemID("  golang.org/x/tools/gopls [v0.16.0]"); got != "golang.org/x/tools/gopls" {
+		t.Errorf("unexpected item ID %q", got)
+	}
+}
+
+func TestToolListParsers(t *testing.T) {
+	pipx := parsePipxList("black 24.4.2\nhttpie 3.2.2\n")
+	if len(pipx) != 2 || pipx["black"] != "version 24.4.2" {
+		t.Errorf("unexpected pipx list %v", pipx)
+	}
+
+	cargo := parseCargoList("ripgrep v14.1.0:\n    rg\nbat v0.24.0:\n    bat\n")
+	if len(cargo) != 2 || cargo["ripgrep"] != "version 14.1.0" {
+		t.Errorf("unexpected cargo list %v", cargo)
+	}
+
+	npm := parseNpmList(`{"dependencies":{"typescript":{"version":"5.4.5"}}}`)
+	if len(npm) != 1 || npm["typescript"] != "version 5.4.5" {
+		t.Errorf("unexpected npm list %v", npm)
+	}
+	if len(parseNpmList("not json")) != 0 {
+		t.Error("expected invalid npm output to yield no packages")
+	}
+
+	goList := parseGoVersionM("/home/alice/go/bin/gopls: go1.22.3\n\tpath\tgolang.org/x/tools/gopls\n\tmod\tgolang.org/x/tools/gopls\tv0.16.0\th1:abc=\n")
+	if goList["golang.org/x/tools/gopls"] != "version 0.16.0" {
+		t.Errorf("unexpected go list %v", goList)
+	}
+}
+
+func TestToolInstalled_ListedOncePerSource(t *testing.T) {
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls")
+	stub := "#!/bin/sh\necho x >> " + calls + "\necho 'black 24.1.0'\n"
+	if err := os.WriteFile(filepath.Join(bin, "pipx"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// The listing runs through env to set HOME.
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Cleanup(func() { toolCache.listings = map[string]map[string]string{} })
+
+	source := Runner{User: currentUsername()}.Source(SourcePipx)
+	for _, item := range []string{"black", "ruff", "black"} {
+		source.Describe(item)
+	}
+	if got := source.Describe("black"); got != "version 24.1.0" {
+		t.Errorf("unexpected description %q", got)
+	}
+	data, _ := os.ReadFile(calls)
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("expected pipx to be listed once, got %d runs", n)
+	}
+
+	// A removal changes the listing, so it is read again.
+	source.Remove("black")
+	source.Describe("black")
+	data, _ = os.ReadFile(calls)
+	if n := strings.Count(string(data), "x"); n != 3 {
+		t.Errorf("expected pipx to be listed again after a removal, got %d runs", n)
+	}
+}
+
+func TestGoBinaryName(t *testing.T) {
+	tests := map[string]string{
+		"golang.org/x/tools/gopls":             "gopls",
+		"github.com/go-delve/delve/cmd/dlv":    "dlv",
+		"github.com/golangci/golangci-lint/v2": "golangci-lint",
+		"honnef.co/go/tools/cmd/staticcheck/":  "staticcheck",
+	}
+	for pkg, want := range tests {
+		if got := goBinaryName(pkg); got != want {
+			t.Errorf("goBinaryName(%q) = %q, want %q", pkg, got, want)
+		}
+	}
+}
+
+func TestParseOptionalDeps(t *testing.T) {
+	output := `Name            : pipewire
+Optional Deps   : pipewire-alsa: ALSA configuration [installed]
+                  pipewire-jack: JACK support
+                  gst-plugin-pipewire
+Required By     : None
+`
+	deps := parseOptionalDeps(output)
+	if len(deps) != 3 {
+		t.Fatalf("expected 3 optional deps, got %+v", deps)
+	}
+	if deps[0] != (OptionalDep{Name: "pipewire-alsa", Reason: "ALSA configuration", Installed: true}) {
+		t.Errorf("unexpected first dep %+v", deps[0])
+	}
+	if deps[1].Name != "pipewire-jack" || deps[1].Reason != "JACK support" || deps[1].Installed {
+		t.Errorf("unexpected second dep %+v", deps[1])
+	}
+	if deps[2].Name != "gst-plugin-pipewire" || deps[2].Reason != "" {
+		t.Errorf("unexpected third dep %+v", deps[2])
+	}
+	if deps := parseOptionalDeps("Optional Deps   : None\n"); len(deps) != 0 {
+		t.Errorf("expected no deps, got %+v", deps)
+	}
+}
+
+func TestAsDependency(t *testing.T) {
+	item := AsDependency("pipewire-jack")
+	if !IsDependency(item) || IsDependency("pipewire-jack") {
+		t.Errorf("unexpected dependency detection for %q", item)
+	}
+	line, _ := parsePackageLine(item)
+	if got := strings.Join(line.targets(), " "); got != "--asdeps pipewire-jack" {
+		t.Errorf("unexpected targets %q", got)
+	}
+	t.Setenv("ARCHUTILS_PRIVILEGE", "sudo")
+	if got := strings.Join(target{}.installRepoPackages(line.targets()...).Args, " "); got != "sudo pacman -S --needed --noconfirm --asdeps pipewire-jack" {
+		t.Errorf("unexpected install command %q", got)
+	}
+}
+
+func TestProviderChoices(t *testing.T) {
+	output := `Repository      : extra
+Name            : jre-openjdk
+Provides        : java-runtime=23  java-runtime-openjdk=23
+                  jre-openjdk-headless=23
+
+Repository      : extra
+Name            : jre21-openjdk
+Provides        : java-runtime=21
+Depends On      : None
+
+Repository      : extra
+Name            : git
+Provides        : None
+`
+	infos := parsePackageInfo(output)
+	if len(infos) != 3 || len(infos[0].provides) != 3 || infos[0].provides[2] != "jre-openjdk-headless" || len(infos[2].provides) != 0 {
+		t.Fatalf("unexpected package info %+v", infos)
+	}
+	groups := parseGroups("xorg-apps xorg-xrandr\nxorg-apps xorg-xset\n")
+	syncPkgs := map[string]bool{"jre-openjdk": true, "jre21-openjdk": true, "git": true}
+
+	choices := providerChoices([]string{"java-runtime", "jre-openjdk-headless", "git", "xorg-apps [user]"}, syncPkgs, infos, groups)
+	if len(choices) != 2 {
+		t.Fatalf("expected a virtual package and a group, got %+v", choices)
+	}
+	if choices[0].Group || strings.Join(choices[0].Options, " ") != "jre-openjdk jre21-openjdk" {
+		t.Errorf("unexpected virtual package choice %+v", choices[0])
+	}
+	if !choices[1].Group || choices[1].Item != "xorg-apps [user]" || len(choices[1].Options) != 2 {
+		t.Errorf("unexpected group choice %+v", choices[1])
+	}
+
+	item := WithProviders(WithProviders("java-runtime [provider:jre-openjdk]", []string{"jre21-openjdk"}), []string{"jre17-openjdk"})
+	if item != "java-runtime [provider:jre17-openjdk]" {
+		t.Errorf("unexpected item %q", item)
+	}
+	line, _ := parsePackageLine(AsDependency("xorg-apps [provider:xorg-xrandr] [provider:xorg-xset]"))
+	if got := strings.Join(line.targets(), " "); got != "--asdeps xorg-xrandr xorg-xset" {
+		t.Errorf("unexpected targets %q", got)
+	}
+}
+
+// withSyncPackages makes pkgs the content of the sync databases.
+func withSyncPackages(t *testing.T, pkgs ...string) {
+	syncCacheOnce.Do(func() {})
+	syncCache = make(map[string]bool)
+	for _, pkg := range pkgs {
+		syncCache[pkg] = true
+	}
+	t.Cleanup(func() {
+		syncCacheOnce = sync.Once{}
+		syncCache = nil
+	})
+}
+
+func TestIsRepoPackage_QueriesTarget(t *testing.T) {
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	stub := "#!/bin/sh\necho \"$@\" >> " + args + "\necho foo\n"
+	if err := os.WriteFile(filepath.Join(bin, "pacman"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Cleanup(func() {
+		syncCacheOnce = sync.Once{}
+		syncCache = nil
+	})
+
+	if !(Runner{Root: "/mnt"}).IsRepoPackage("foo") {
+		t.Error("expected foo to be found in the target's sync databases")
+	}
+	if data, _ := os.ReadFile(args); strings.TrimSpace(string(data)) != "--root /mnt -Slq" {
+		t.Errorf("expected the target's databases to be listed, got %q", data)
+	}
+}
+
+func TestProviderChoices_QueriesTarget(t *testing.T) {
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	stub := "#!/bin/sh\necho \"$@\" >> " + args + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "pacman"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	t.Cleanup(func() {
+		syncCacheOnce, syncInfoOnce, syncGroupsOnce = sync.Once{}, sync.Once{}, sync.Once{}
+		syncCache, syncInfo, syncGroups = nil, nil, nil
+	})
+
+	(Runner{Root: "/mnt"}).ProviderChoices([]string{"foo"})
+	data, _ := os.ReadFile(args)
+	for _, want := range []string{"--root /mnt -Si\n", "--root /mnt -Sgg\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected the target's databases to be read with %q, got %q", want, data)
+		}
+	}
+}
+
+func TestPackageInstallCmd_ProvidersWithoutHelper(t *testing.T) {
+	withSyncPackages(t, "jre21-openjdk")
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("ARCHUTILS_PRIVILEGE", "sudo")
+
+	line, _ := parsePackageLine("java-runtime [provider:jre21-openjdk]")
+	cmd, err := Runner{}.packageInstallCmd(line)
+	if err != nil {
+		t.Fatalf("expected the repo provider to install without a helper, got %v", err)
+	}
+	if got := strings.Join(cmd.Args, " "); got != "sudo pacman -S --needed --noconfirm jre21-openjdk" {
+		t.Errorf("unexpected install command %q", got)
+	}
+
+	root := t.TempDir()
+	cmd, err = Runner{Root: root}.packageInstallCmd(line)
+	if err != nil || strings.Join(cmd.Args, " ") != "sudo pacstrap "+root+" --needed jre21-openjdk" {
+		t.Errorf("expected pacstrap in a target, got %v %v", cmd, err)
+	}
+
+	line, _ = parsePackageLine("java-runtime [provider:jdk-temurin]")
+	if _, err := (Runner{}).packageInstallCmd(line); err == nil || !strings.Contains(err.Error(), "AUR package cannot be installed") {
+		t.Errorf("expected an AUR provider to need the helper, got %v", err)
+	}
+}
+
+func TestPackageInstallCmd_LeavesConflictsToPacman(t *testing.T) {
+	withSyncPackages(t, "tlp")
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("ARCHUTILS_PRIVILEGE", "sudo")
+
+	line, _ := parsePackageLine(WithReplaced("tlp [tlp]", "power-profiles-daemon"))
+	cmd, err := Runner{}.packageInstallCmd(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cmd.Args, " "); got != "sudo pacman -S --needed --noconfirm tlp" {
+		t.Errorf("expected no blanket answer to conflict prompts, got %q", got)
+	}
+}
+
+func TestRestoreReplaced_ReportsAURPackages(t *testing.T) {
+	asRootForTest(t)
+	t.Setenv("PATH", t.TempDir())
+
+	got := restoreReplaced(target{}, []string{"foo-git"}, []string{"foo-git"})
+	if !strings.Contains(got, "foo-git came from the AUR and was not reinstalled") || strings.Contains(got, "Reinstalled") {
+		t.Errorf("expected the AUR package to be reported, got %q", got)
+	}
+	got = restoreReplaced(target{}, []string{"foo-git", "bar"}, []string{"foo-git"})
+	if !strings.Contains(got, "foo-git came from the AUR") || !strings.Contains(got, "Failed to reinstall the replaced bar,") {
+		t.Errorf("expected only bar to be reinstalled from the repos, got %q", got)
+	}
+}
+
+func TestApprovedBuild(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	item := WithApproval(WithApproval("foo [asdeps]", "ffffffffffffffffffffffffffffffffffffffff"), commit)
+	if item != "foo [asdeps] [approved:"+commit+"]" {
+		t.Fatalf("unexpected item %q", item)
+	}
+	line, _ := parsePackageLine(item)
+	if line.approved != commit || len(line.services) != 0 {
+		t.Fatalf("expected the approval not to be a service, got %+v", line)
+	}
+
+	t.Setenv("ARCHUTILS_PRIVILEGE", "doas")
+	paru, _ := LookupAURHelper("paru")
+	got := strings.Join(paru.approvedBuildCmd(target{}, "foo", commit, true).Args, " ")
+	for _, want := range []string{"git clone -q https://aur.archlinux.org/foo.git", "git checkout -q " + commit, "paru -Ui --noconfirm --asdeps --sudo doas"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+	yay, _ := LookupAURHelper("yay")
+	got = strings.Join(yay.approvedBuildCmd(target{root: t.TempDir()}, "foo", commit, false).Args, " ")
+	if !strings.Contains(got, "arch-chroot") || !strings.HasSuffix(got, "sh foo "+commit+" ") || strings.Contains(got, "yay -Bi") {
+		t.Errorf("expected a target build to run through the build user script, got %q", got)
+	}
+
+	steps := paru.bootstrapSteps(target{}, BootstrapOptions{Strategy: BootstrapSource, Commit: commit})
+	if clone := strings.Join(steps[2].cmd().Args, " "); !strings.Contains(clone, "git -C /tmp/paru checkout -q "+commit) {
+		t.Errorf("expected the bootstrap to check out the approved commit, got %q", clone)
+	}
+	if err := (BootstrapOptions{Commit: "HEAD; rm -rf /"}).Validate(paru); err == nil {
+		t.Error("expected an invalid commit to be rejected")
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	syncInfos := []packageInfo{
+		{name: "tlp", conflicts: []string{"laptop-mode-tools", "power-profiles-daemon"}},
+		{name: "auto-cpufreq", conflicts: []string{"tlp"}},
+		{name: "power-profiles-daemon"},
+		{name: "pipewire-pulse", provides: []string{"pulse-native-provider"}, conflicts: []string{"pulseaudio"}},
+		{name: "git"},
+	}
+	local := []packageInfo{{name: "pulseaudio"}, {name: "power-profiles-daemon"}, {name: "git"}}
+
+	conflicts := findConflicts([]string{"tlp", "auto-cpufreq [auto-cpufreq]", "pipewire-pulse [user]", "git"}, syncInfos, local)
+	if len(conflicts) != 3 {
+		t.Fatalf("expected 3 conflicts, got %+v", conflicts)
+	}
+	if c := conflicts[0]; c.Item != "tlp" || c.With != "auto-cpufreq [auto-cpufreq]" || c.Installed || c.Reason != "auto-cpufreq conflicts with tlp" {
+		t.Errorf("unexpected selection conflict %+v", c)
+	}
+	if c := conflicts[1]; c.Item != "tlp" || c.With != "power-profiles-daemon" || !c.Installed {
+		t.Errorf("unexpected installed conflict %+v", c)
+	}
+	if c := conflicts[2]; c.Item != "pipewire-pulse [user]" || c.With != "pulseaudio" {
+		t.Errorf("unexpected installed conflict %+v", c)
+	}
+
+	replaced := findConflicts([]string{WithReplaced("tlp", "power-profiles-daemon")}, syncInfos, local)
+	if len(replaced) != 0 {
+		t.Errorf("expected the replaced package not to conflict, got %+v", replaced)
+	}
+
+	infos := parsePackageInfo("Name            : tlp\nConflicts With  : laptop-mode-tools  power-profiles-daemon\nReplaces        : None\n")
+	if len(infos) != 1 || len(infos[0].conflicts) != 2 || len(infos[0].replaces) != 0 {
+		t.Errorf("unexpected package info %+v", infos)
+	}
+}
+
+func TestPreflightChecks(t *testing.T) {
+	if r := checkDBLock(pacmanLockPath, false, false); r.Status != CheckPass {
+		t.Errorf("expected an unlocked database to pass, got %+v", r)
+	}
+	if r := checkDBLock("/mnt"+pacmanLockPath, true, false); r.Status != CheckFail || !strings.HasSuffix(r.Message, "remove /mnt"+pacmanLockPath) {
+		t.Errorf("expected a stale lock of the target to fail, got %+v", r)
+	}
+	if r := checkDBLock(pacmanLockPath, true, true); r.Status != CheckFail || !strings.Contains(r.Message, "another pacman") {
+		t.Errorf("expected a running pacman to fail, got %+v", r)
+	}
+
+	if r := checkFreeSpace("/", 512<<20, nil); r.Status != CheckFail || r.Message != "512 MiB available" {
+		t.Errorf("unexpected result for low space %+v", r)
+	}
+	if r := checkFreeSpace("/", 3<<30, nil); r.Status != CheckWarn {
+		t.Errorf("expected a warning below %d bytes, got %+v", lowFreeSpace, r)
+	}
+	if r := checkFreeSpace("/", 20<<30, nil); r.Status != CheckPass || r.Message != "20.0 GiB available" {
+		t.Errorf("unexpected result for enough space %+v", r)
+	}
+	_, err := freeSpace(filepath.Join(t.TempDir(), "var/cache/pacman/pkg"))
+	if r := checkFreeSpace(pacmanCachePath, 0, err); r.Status != CheckWarn || !strings.Contains(r.Message, "no such file") {
+		t.Errorf("expected a missing directory to warn, got %+v", r)
+	}
+
+	if r := checkKeyring("20240520-1", "20240520-1"); r.Status != CheckPass {
+		t.Errorf("expected an up-to-date keyring to pass, got %+v", r)
+	}
+	if r := checkKeyring("20240101-1", "20240520-1"); r.Status != CheckWarn {
+		t.Errorf("expected an outdated keyring to warn, got %+v", r)
+	}
+	if r := checkKeyring("20240601-1", "20240520-1"); r.Status != CheckPass {
+		t.Errorf("expected a keyring newer than the sync database to pass, got %+v", r)
+	}
+	if r := checkPartialUpgrade([]string{"linux", "glibc"}); r.Status != CheckWarn || !strings.HasPrefix(r.Message, "2 package(s)") {
+		t.Errorf("expected pending upgrades to warn, got %+v", r)
+	}
+	if r := checkPartialUpgrade(nil); r.Status != CheckPass {
+		t.Errorf("expected no pending upgrades to pass, got %+v", r)
+	}
+}
+
+func TestParseUpdates(t *testing.T) {
+	output := "linux 6.9.1.arch1-1 -> 6.9.2.arch1-1\nfirefox 126.0-1 -> 126.0.1-1\n:: warning line\n\n"
+	got := parseUpdates(output, true)
+	want := []PackageUpdate{
+		{Name: "linux", From: "6.9.1.arch1-1", To: "6.9.2.arch1-1", AUR: true},
+		{Name: "firefox", From: "126.0-1", To: "126.0.1-1", AUR: true},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseUpdates() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNeedsReboot(t *testing.T) {
+	tests := map[string]bool{
+		"linux":             true,
+		"linux-lts":         true,
+		"linux-zen":         true,
+		"systemd":           true,
+		"glibc":             true,
+		"linux-headers":     false,
+		"linux-lts-headers": false,
+		"linux-firmware":    false,
+		"linux-api-headers": false,
+		"linuxconsoletools": false,
+		"firefox":           false,
+	}
+	for name, want := range tests {
+		if got := (PackageUpdate{Name: name}).NeedsReboot(); got != want {
+			t.Errorf("NeedsReboot(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestVercmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0-1", "1.0-1", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0.1-1", "1.0-1", 1},
+		{"1.0-1", "1.0alpha-1", 1},
+		{"1.0a-1", "1.0b-1", -1},
+		{"1.10-1", "1.9-1", 1},
+		{"1.001-1", "1.1-1", 0},
+		{"1:1.0-1", "2.0-1", 1},
+		{"1.0", "1.0-5", 0},
+		{"6.9.2.arch1-1", "6.9.10.arch1-1", -1},
+		{"1.5b-1", "1.5-1", -1},
+		{"1_0-1", "1.0-1", 0},
+	}
+	for _, tt := range tests {
+		if got := vercmp(tt.a, tt.b); got != tt.want {
+			t.Errorf("vercmp(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := vercmp(tt.b, tt.a); got != -tt.want {
+			t.Errorf("vercmp(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseCacheFile(t *testing.T) {
+	pkg, ok := parseCacheFile("python-pip-24.0-1-any.pkg.tar.zst")
+	if !ok || pkg.Name != "python-pip" || pkg.Version != "24.0-1" || pkg.Arch != "any" {
+		t.Errorf("unexpected package %+v", pkg)
+	}
+	for _, file := range []string{"python-pip-24.0-1-any.pkg.tar.zst.sig", "git-2.45-1-x86_64.pkg.tar.zst.part", "download-abc", "foo-1-x86_64.pkg.tar.zst"} {
+		if _, ok := parseCacheFile(file); ok {
+			t.Errorf("expected %q to be skipped", file)
+		}
+	}
+}
+
+func TestStaleCachedPackages(t *testing.T) {
+	var pkgs []CachedPackage
+	for _, file := range []string{
+		"git-2.44.0-1-x86_64.pkg.tar.zst",
+		"git-2.45.1-1-x86_64.pkg.tar.zst",
+		"git-2.9.0-1-x86_64.pkg.tar.zst",
+		"git-2.45.0-2-x86_64.pkg.tar.zst",
+		"oldtool-1.0-1-any.pkg.tar.zst",
+		"oldtool-1.1-1-any.pkg.tar.zst",
+	} {
+		pkg, _ := parseCacheFile(file)
+		pkg.Path = pacmanCachePath + "/" + file
+		pkgs = append(pkgs, pkg)
+	}
+	stale := staleCachedPackages(pkgs, CacheRetention{Keep: 2, KeepUninstalled: 1}, map[string]bool{"git": true})
+	var got []string
+	for _, pkg := range stale {
+		got = append(got, pkg.Name+" "+pkg.Version)
+	}
+	want := []string{"git 2.9.0-1", "git 2.44.0-1", "oldtool 1.0-1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("staleCachedPackages() = %v, want %v", got, want)
+	}
+	if stale := staleCachedPackages(pkgs, CacheRetention{}, nil); len(stale) != len(pkgs) {
+		t.Errorf("expected keeping 0 versions to delete all %d files, got %d", len(pkgs), len(stale))
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"etc/pacman.conf", "etc/pacman.conf.pacnew", "etc/ssh/sshd_config.pacsave", "etc/hosts"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := Runner{Root: root}
+	files, err := r.ConfigFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfigFile{
+		{Path: "/etc/pacman.conf.pacnew", Original: "/etc/pacman.conf"},
+		{Path: "/etc/ssh/sshd_config.pacsave", Original: "/etc/ssh/sshd_config"},
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("ConfigFiles() = %+v, want %+v", files, want)
+	}
+	if content, err := r.ReadConfigFile("/etc/pacman.conf"); err != nil || content != "etc/pacman.conf\n" {
+		t.Errorf("ReadConfigFile() = %q, %v", content, err)
+	}
+	if content, err := r.ReadConfigFile("/etc/ssh/sshd_config"); err != nil || content != "" {
+		t.Errorf("expected a missing config to read as empty, got %q, %v", content, err)
+	}
+}
+
+func TestConfigAction(t *testing.T) {
+	item := WithConfigAction("/etc/pacman.conf.pacnew", ConfigMerge)
+	if item != "/etc/pacman.conf.pacnew [merge]" || ItemConfigAction(item) != ConfigMerge {
+		t.Errorf("unexpected config item %q", item)
+	}
+	if action := ItemConfigAction("/etc/pacman.conf.pacnew"); action != "" {
+		t.Errorf("expected no action, got %q", action)
+	}
+	if ok, msg := (configSource{}).Install("/etc/pacman.conf.pacnew"); ok || !strings.Contains(msg, "No action chosen") {
+		t.Errorf("expected a missing action to fail, got %v %q", ok, msg)
+	}
+	if ok, _ := (configSource{}).Install(WithConfigAction("/usr/lib/foo.pacnew", ConfigKeep)); ok {
+		t.Error("expected files outside /etc to be refused")
+	}
+	if ok, _ := (cacheSource{}).Install("/etc/passwd"); ok {
+		t.Error("expected files outside the package cache to be refused")
+	}
+}
+
+func TestPlanUndo(t *testing.T) {
+	local := parsePackageInfo(`Name            : ripgrep
+Required By     : None
+
+Name            : pcre2
+Required By     : ripgrep  git
+
+Name            : libfoo
+Required By     : foo-gui
+
+Name            : foo-gui
+Required By     : None
+
+Name            : libbar
+Required By     : libfoo
+`)
+	plan := planUndo([]string{"pcre2", "ripgrep", "libbar", "libfoo", "foo-gui", "gone"}, local)
+	if want := []string{"ripgrep", "foo-gui", "libfoo", "libbar"}; !slices.Equal(plan.Remove, want) {
+		t.Errorf("Remove = %v, want %v", plan.Remove, want)
+	}
+	if len(plan.Kept) != 1 || !slices.Equal(plan.Kept["pcre2"], []string{"git"}) {
+		t.Errorf("expected pcre2 to be kept for git, got %v", plan.Kept)
+	}
+
+	// A kept package keeps the packages it requires.
+	plan = planUndo([]string{"libfoo", "libbar"}, local)
+	if len(plan.Remove) != 0 || !slices.Equal(plan.Kept["libbar"], []string{"libfoo"}) {
+		t.Errorf("expected both packages to be kept, got %+v", plan)
+	}
+}
+
+func TestParseSnapshotID(t *testing.T) {
+	timeshift := `Using system disk as snapshot device for creating snapshots in RSYNC mode
+Creating new snapshot...(RSYNC)
+Saving to device: /dev/nvme0n1p2, mounted at path: /run/timeshift/backup
+Synching files with rsync...
+Created control file: /run/timeshift/backup/timeshift/snapshots/2026-10-19_14-02-10/info.json
+RSYNC Snapshot saved successfully (12s)
+Tagged snapshot '2026-10-19_14-02-10': ondemand
+`
+	tests := []struct {
+		tool, output, want string
+		ok                 bool
+	}{
+		{SnapshotSnapper, "42\n", "42", true},
+		{SnapshotSnapper, "", "", false},
+		{SnapshotSnapper, "Creating config failed.\n", "", false},
+		{SnapshotTimeshift, timeshift, "2026-10-19_14-02-10", true},
+		{SnapshotTimeshift, "Snapshot device not selected\n", "", false},
+		{"btrfs", "1\n", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSnapshotID(tt.tool, tt.output)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSnapshotID(%q, %q) = %q, %v, want %q, %v", tt.tool, tt.output, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package scripts
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/fcarp10/archutils/internal/session"
+)
+
+// Snapshot tools, see SnapshotTool.
+const (
+	SnapshotSnapper   = "snapper"
+	SnapshotTimeshift = "timeshift"
+)
+
+// snapshotConfigs are the files that show a snapshot tool is set up for the
+// root file system, by tool.
+var snapshotConfigs = map[string]string{
+	SnapshotSnapper:   "/etc/snapper/configs/root",
+	SnapshotTimeshift: "/etc/timeshift/timeshift.json",
+}
+
+// SnapshotTool returns the snapshot tool set up for the root file system,
+// snapper before timeshift, or "" when there is none. Runs on a mounted
+// target never get snapshots: the tools would snapshot the host instead.
+func (r Runner) SnapshotTool() string {
+	if r.target().chrooted() {
+		return ""
+	}
+	for _, tool := range []string{SnapshotSnapper, SnapshotTimeshift} {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		if _, err := os.Stat(snapshotConfigs[tool]); err == nil {
+			return tool
+		}
+	}
+	return ""
+}
+
+// CreateSnapshot takes a snapshot of the root file system with tool, labelled
+// with description. Credentials must have been validated before.
+func (r Runner) CreateSnapshot(tool, description string) (session.Snapshot, error) {
+	var cmd *exec.Cmd
+	switch tool {
+	case SnapshotSnapper:
+		// The number cleanup algorithm lets snapper expire the snapshot
+		// like its own pre/post snapshots.
+		cmd = privileged("snapper", "-c", "root", "create", "--type", "single",
+			"--cleanup-algorithm", "number", "--print-number", "--description", description)
+	case SnapshotTimeshift:
+		cmd = privileged("timeshift", "--create", "--scripted", "--comments", description)
+	default:
+		return session.Snapshot{}, fmt.Errorf("unknown snapshot tool %q", tool)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return session.Snapshot{}, fmt.Errorf("%s failed: %v\n%s", tool, err, strings.Trim(string(output), "\n"))
+	}
+	id, ok := parseSnapshotID(tool, string(output))
+	if !ok {
+		return session.Snapshot{}, fmt.Errorf("%s did not report the snapshot it created:\n%s", tool, strings.Trim(string(output), "\n"))
+	}
+	return session.Snapshot{Tool: tool, ID: id}, nil
+}
+
+var timeshiftTaggedRe = regexp.MustCompile(`Tagged snapshot '([^']+)'`)
+
+// parseSnapshotID returns the snapshot reported by a snapshot command:
+// snapper --print-number prints the number, timeshift tags the snapshot
+// by its name.
+func parseSnapshotID(tool, output string) (string, bool) {
+	switch tool {
+	case SnapshotSnapper:
+		fields := strings.Fields(output)
+		if len(fields) == 0 {
+			return "", false
+		}
+		id := fields[len(fields)-1]
+		if strings.Trim(id, "0123456789") != "" {
+			return "", false
+		}
+		return id, true
+	case SnapshotTimeshift:
+		if match := timeshiftTaggedRe.FindStringSubmatch(output); match != nil {
+			return match[1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,180 @@
+package scripts
+
+import (
+	"fmt"
+	"strings"
+
+	c "github.com/fcarp10/archutils/internal/config"
+)
+
+// Source kinds. Each kind reads its items from the category files of
+// configs/<kind>, see config.ReadCategories.
+const (
+	SourcePackages = "packages"
+	SourceVSCode   = "vscode"
+	SourceFlatpak  = "flatpak"
+	SourcePipx     = "pipx"
+	SourceCargo    = "cargo"
+	SourceNpm      = "npm"
+	SourceGo       = "go"
+)
+
+// Source is a kind of installable item: pacman packages, editor extensions,
+// Flatpak applications or language tool installers. Items are lines of a
+// category file whose first field is the item ID; the rest are annotations
+// the source interprets. Maintenance tasks are sources too, see SourceOrphans.
+type Source interface {
+	// Kind returns the source kind, which is also its config directory name.
+	Kind() string
+	// NeedsPrivilege reports whether installs run commands as root.
+	NeedsPrivilege() bool
+	// Installed returns the IDs of the installed items with their descriptions.
+	Installed() map[string]string
+	Install(item string) (bool, string)
+	Remove(item string) (bool, string)
+	// Describe returns a short description of the item, if one is known.
+	Describe(item string) string
+}
+
+// ItemID returns the ID of an item line, i.e. its first field.
+func ItemID(item string) string {
+	fields := strings.Fields(item)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Source returns the source of the given kind, or nil if the kind is unknown.
+func (r Runner) Source(kind string) Source {
+	switch kind {
+	case SourcePackages:
+		return packageSource{r}
+	case SourceVSCode:
+		return vscodeSource{r}
+	case SourceFlatpak:
+		return flatpakSource{r}
+	case SourcePipx:
+		return pipxSource{r}
+	case SourceCargo:
+		return cargoSource{r}
+	case SourceNpm:
+		return npmSource{r}
+	case SourceGo:
+		return goSource{r}
+	case SourceOrphans:
+		return orphanSource{r}
+	case SourceCache:
+		return cacheSource{r}
+	case SourceConfigs:
+		return configSource{r}
+	case SourceUndo:
+		return undoSource{r}
+	}
+	return nil
+}
+
+// RouteItem returns the source installing an item line of a kind's category,
+// the one its [source:] annotation names or else the kind's, together with
+// the line as that source expects it. The source is nil for unknown kinds.
+func RouteItem(installer Installer, kind, item string) (Source, string) {
+	kind, item = c.ItemSource(item, kind)
+	return installer.Source(kind), item
+}
+
+// InstalledItems returns the descriptions of the installed items of a kind's
+// category by index, listing each source the items route to once.
+func InstalledItems(installer Installer, kind string, items []string) map[int]string {
+	listings := make(map[string]map[string]string)
+	installed := make(map[int]string)
+	for i, item := range items {
+		src, line := RouteItem(installer, kind, item)
+		if src == nil {
+			continue
+		}
+		listing, ok := listings[src.Kind()]
+		if !ok {
+			listing = src.Installed()
+			listings[src.Kind()] = listing
+		}
+		if desc, ok := listing[ItemID(line)]; ok {
+			installed[i] = desc
+		}
+	}
+	return installed
+}
+
+// removeResult formats the outcome of a remove command like the install ones.
+func removeResult(id string, output []byte, err error) (bool, string) {
+	if err != nil {
+		return false, fmt.Sprintf("%s: Failed to remove %v\n%s", id, err, strings.Trim(string(output), "\n"))
+	}
+	return true, fmt.Sprintf("%s: Removed successfully", id)
+}
+
+// packageSource installs pacman packages, through the AUR helper when needed.
+type packageSource struct{ r Runner }
+
+func (s packageSource) Kind() string                       { return SourcePackages }
+func (s packageSource) NeedsPrivilege() bool               { return true }
+func (s packageSource) Installed() map[string]string       { return s.r.GetInstalledPackages() }
+func (s packageSource) Install(item string) (bool, string) { return s.r.InstallPackage(item) }
+func (s packageSource) Describe(item string) string        { return s.r.GetPackageDescription(ItemID(item)) }
+
+func (s packageSource) Remove(item string) (bool, string) {
+	id := ItemID(item)
+	output, err := s.r.target().run("pacman", "-Rns", "--noconfirm", id).CombinedOutput()
+	return removeResult(id, output, err)
+}
+
+// vscodeSource installs VSCode/VSCodium extensions for the selected user.
+type vscodeSource struct{ r Runner }
+
+func (s vscodeSource) Kind() string                       { return SourceVSCode }
+func (s vscodeSource) NeedsPrivilege() bool               { return false }
+func (s vscodeSource) Install(item string) (bool, string) { return s.r.InstallVSCodeExtension(item) }
+func (s vscodeSource) Describe(item string) string        { return s.r.GetExtensionDescription(item) }
+
+func (s vscodeSource) Installed() map[string]string {
+	installed := make(map[string]string)
+	for ext := range getInstalledExtensions(s.r.listExtensionsCmd) {
+		installed[ext] = s.r.GetExtensionDescription(ext)
+	}
+	return installed
+}
+
+func (s vscodeSource) Remove(item string) (bool, string) {
+	id := ItemID(item)
+	cmd := s.r.target().asUser(s.r.targetUser(), editorBinary(), "--uninstall-extension", id)
+	output, err := cmd.CombinedOutput()
+	return removeResult(id, output, err)
+}
+
+// flatpakSource installs Flathub applications. Installed merges the user and
+// system installations, so an application counts as installed in either scope.
+type flatpakSource struct{ r Runner }
+
+func (s flatpakSource) Kind() string                       { return SourceFlatpak }
+func (s flatpakSource) NeedsPrivilege() bool               { return true }
+func (s flatpakSource) Install(item string) (bool, string) { return s.r.InstallFlatpak(item) }
+func (s flatpakSource) Describe(item string) string        { return s.r.GetFlatpakDescription(item) }
+
+func (s flatpakSource) Installed() map[string]string {
+	installed := make(map[string]string)
+	for _, apps := range s.r.installedFlatpaks() {
+		for id, desc := range apps {
+			installed[id] = desc
+		}
+	}
+	return installed
+}
+
+func (s flatpakSource) Remove(item string) (bool, string) {
+	line, ok := parseFlatpakLine(item)
+	if !ok {
+		return false, "Invalid application string"
+	}
+	cmd := flatpakCmd(s.r.target(), s.r.targetUser(), line.user, "uninstall", "--noninteractive", "-y", line.id)
+	output, err := cmd.CombinedOutput()
+	return removeResult(line.id, output, err)
+}
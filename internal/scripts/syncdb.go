@@ -0,0 +1,128 @@
+package scripts
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	syncInfoOnce sync.Once
+	syncInfo     []packageInfo
+
+	syncGroupsOnce sync.Once
+	syncGroups     map[string][]string
+)
+
+// packageInfo holds the fields of a pacman -Si/-Qi entry that relate
+// packages to each other.
+type packageInfo struct {
+	name      string
+	provides  []string
+	conflicts []string
+	replaces  []string
+	// requiredBy is only set by pacman -Qi.
+	requiredBy []string
+}
+
+// infoFields splits pacman -Si/-Qi output into one field map per package.
+// Values wrapped onto indented continuation lines are joined with spaces.
+func infoFields(output string) []map[string]string {
+	var entries []map[string]string
+	var entry map[string]string
+	var field string
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+			entry = nil
+		case strings.HasPrefix(line, " ") && entry != nil && field != "":
+			entry[field] += " " + strings.TrimSpace(line)
+		default:
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			if entry == nil {
+				entry = make(map[string]string)
+				entries = append(entries, entry)
+			}
+			field = strings.TrimSpace(key)
+			entry[field] = strings.TrimSpace(value)
+		}
+	}
+	return entries
+}
+
+// depNames returns the package names of a dependency list field such as
+// "java-runtime=21  sh", dropping version constraints.
+func depNames(value string) []string {
+	if value == "None" {
+		return nil
+	}
+	var names []string
+	for _, dep := range strings.Fields(value) {
+		if i := strings.IndexAny(dep, "<>="); i >= 0 {
+			dep = dep[:i]
+		}
+		if dep != "" {
+			names = append(names, dep)
+		}
+	}
+	return names
+}
+
+// parsePackageInfo parses pacman -Si/-Qi output.
+func parsePackageInfo(output string) []packageInfo {
+	var infos []packageInfo
+	for _, fields := range infoFields(output) {
+		if fields["Name"] == "" {
+			continue
+		}
+		infos = append(infos, packageInfo{
+			name:       fields["Name"],
+			provides:   depNames(fields["Provides"]),
+			conflicts:  depNames(fields["Conflicts With"]),
+			replaces:   depNames(fields["Replaces"]),
+			requiredBy: depNames(fields["Required By"]),
+		})
+	}
+	return infos
+}
+
+// getSyncInfo returns a lazily-loaded list of every sync package of the
+// target in repository order (pacman -Si).
+func getSyncInfo(t target) []packageInfo {
+	syncInfoOnce.Do(func() {
+		output, err := t.query("-Si").Output()
+		if err != nil {
+			return
+		}
+		syncInfo = parsePackageInfo(string(output))
+	})
+	return syncInfo
+}
+
+// getSyncGroups returns a lazily-loaded map of the target's sync package
+// groups to their members (pacman -Sgg).
+func getSyncGroups(t target) map[string][]string {
+	syncGroupsOnce.Do(func() {
+		output, err := t.query("-Sgg").Output()
+		if err != nil {
+			syncGroups = map[string][]string{}
+			return
+		}
+		syncGroups = parseGroups(string(output))
+	})
+	return syncGroups
+}
+
+// parseGroups parses "group package" lines.
+func parseGroups(output string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			groups[fields[0]] = append(groups[fields[0]], fields[1])
+		}
+	}
+	return groups
+}
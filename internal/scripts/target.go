@@ -0,0 +1,112 @@
+package scripts
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// target is the system archutils configures: the running system, or a
+// mounted installation such as /mnt from the live ISO when --root is given.
+type target struct {
+	root string
+}
+
+// chrooted reports whether a mounted target system is being configured.
+func (t target) chrooted() bool {
+	return t.root != ""
+}
+
+// path maps an absolute path on the target to the corresponding host path.
+func (t target) path(p string) string {
+	if !t.chrooted() {
+		return p
+	}
+	return filepath.Join(t.root, p)
+}
+
+// run returns a command running name as root on the target: through the
+// privilege tool on the running system, inside arch-chroot otherwise.
+func (t target) run(name string, args ...string) *exec.Cmd {
+	if !t.chrooted() {
+		return privileged(name, args...)
+	}
+	return privileged("arch-chroot", append([]string{t.root, name}, args...)...)
+}
+
+// shell returns a command running script with sh as root on the target.
+func (t target) shell(script string) *exec.Cmd {
+	return t.run("sh", "-c", script)
+}
+
+// query returns an unprivileged pacman query against the target's database.
+func (t target) query(args ...string) *exec.Cmd {
+	if t.chrooted() {
+		args = append([]string{"--root", t.root}, args...)
+	}
+	return exec.Command("pacman", args...)
+}
+
+// installRepoPackages returns the command installing sync packages on the
+// target, with pacstrap when it is a mounted system.
+func (t target) installRepoPackages(pkgs ...string) *exec.Cmd {
+	if !t.chrooted() {
+		return privileged("pacman", append([]string{"-S", "--needed", "--noconfirm"}, pkgs...)...)
+	}
+	return privileged("pacstrap", append([]string{t.root, "--needed"}, pkgs...)...)
+}
+
+// enableUnit returns the command enabling a systemd unit on the target.
+// User units belong to user; when that is not the process owner they are
+// enabled through the user's service manager with --machine=user@. Units of
+// a mounted system can only be enabled, not started, and user units are
+// enabled for every user there since no user manager is running.
+func (t target) enableUnit(service string, userLevel bool, user string) *exec.Cmd {
+	switch {
+	case t.chrooted() && userLevel:
+		return privileged("systemctl", "--root="+t.root, "--global", "enable", service)
+	case t.chrooted():
+		return privileged("systemctl", "--root="+t.root, "enable", service)
+	case userLevel && user != "" && user != currentUsername():
+		return privileged("systemctl", "--user", "--machine="+user+"@", "enable", "--now", service)
+	case userLevel:
+		return exec.Command("systemctl", "--user", "enable", "--now", service)
+	default:
+		return privileged("systemctl", "enable", "--now", service)
+	}
+}
+
+// userGroups returns the command listing the groups of user on the target.
+func (t target) userGroups(user string) *exec.Cmd {
+	if t.chrooted() {
+		return t.run("id", "-nG", user)
+	}
+	return exec.Command("id", "-nG", user)
+}
+
+// asUser returns a command running name as user on the target. On the
+// running system the command runs directly when the process already is user.
+func (t target) asUser(user, name string, args ...string) *exec.Cmd {
+	if !t.chrooted() && (user == "" || user == currentUsername()) {
+		return exec.Command(name, args...)
+	}
+	return t.run("runuser", append([]string{"-u", user, "--", name}, args...)...)
+}
+
+// usesBuildUser reports whether AUR builds run as the build user, which is
+// the case as root and inside a mounted target.
+func (t target) usesBuildUser() bool {
+	return t.chrooted() || runningAsRoot()
+}
+
+// asBuildUser returns a command running name with args as the build user,
+// with HOME and USER pointing at the build account.
+func (t target) asBuildUser(name string, args ...string) *exec.Cmd {
+	runuserArgs := append([]string{"-u", buildUser, "--", name}, args...)
+	cmd := exec.Command("runuser", runuserArgs...)
+	if t.chrooted() {
+		cmd = t.run("runuser", runuserArgs...)
+	}
+	cmd.Env = append(os.Environ(), "HOME="+buildUserHome, "USER="+buildUser, "LOGNAME="+buildUser)
+	return cmd
+}
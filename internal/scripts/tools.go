@@ -0,0 +1,306 @@
+package scripts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// toolCache holds the installed listing of each language tool by binary, so
+// that describing every item of a category lists the tool only once. Installs
+// and removals drop the listing of their tool.
+var toolCache = struct {
+	sync.Mutex
+	listings map[string]map[string]string
+}{listings: map[string]map[string]string{}}
+
+// toolAvailable reports whether binary is installed on the target.
+func (r Runner) toolAvailable(binary string) bool {
+	t := r.target()
+	if t.chrooted() {
+		_, err := os.Stat(t.path("/usr/bin/" + binary))
+		return err == nil
+	}
+	_, err := exec.LookPath(binary)
+	return err == nil
+}
+
+// ensureTool installs the repo package providing binary when it is missing.
+func (r Runner) ensureTool(binary, pkg string) (bool, string) {
+	if r.toolAvailable(binary) {
+		return true, ""
+	}
+	if output, err := r.target().installRepoPackages(pkg).CombinedOutput(); err != nil {
+		return false, fmt.Sprintf("Failed to install %s %v\n%s", pkg, err, strings.Trim(string(output), "\n"))
+	}
+	return true, ""
+}
+
+// userHomeDir returns the home directory of the selected user on the target,
+// as seen from inside the target.
+func (r Runner) userHomeDir() string {
+	return userHome(r.target().path(passwdPath), r.targetUser())
+}
+
+// toolCmd returns a command running a language tool as the selected user.
+// HOME is set explicitly because runuser keeps the caller's environment.
+func (r Runner) toolCmd(name string, args ...string) *exec.Cmd {
+	if home := r.userHomeDir(); home != "" {
+		args = append([]string{"HOME=" + home, name}, args...)
+		name = "env"
+	}
+	return r.target().asUser(r.targetUser(), name, args...)
+}
+
+// runTool runs a tool install for id after making sure the tool exists.
+func (r Runner) runTool(binary, pkg, id string, cmd func() *exec.Cmd) (bool, string) {
+	if id == "" {
+		return false, "Invalid item string"
+	}
+	if r.targetUser() == "" {
+		return false, fmt.Sprintf("%s: %s", id, r.missingUserMessage())
+	}
+	if ok, msg := r.ensureTool(binary, pkg); !ok {
+		return false, fmt.Sprintf("%s: %s", id, msg)
+	}
+	output, err := cmd().CombinedOutput()
+	forgetToolListing(binary)
+	if err != nil {
+		return false, fmt.Sprintf("%s: Failed to install %v\n%s", id, err, strings.Trim(string(output), "\n"))
+	}
+	return true, fmt.Sprintf("%s: Installed successfully", id)
+}
+
+// toolInstalled returns the lazily-loaded listing of binary, parsed from the
+// output of cmd and cached in toolCache. It is empty when the tool is
+// missing or fails.
+func (r Runner) toolInstalled(binary string, cmd func() *exec.Cmd, parse func(string) map[string]string) map[string]string {
+	if !r.toolAvailable(binary) || r.targetUser() == "" {
+		return map[string]string{}
+	}
+	toolCache.Lock()
+	defer toolCache.Unlock()
+	if listing, ok := toolCache.listings[binary]; ok {
+		return listing
+	}
+	listing := map[string]string{}
+	if output, err := cmd().Output(); err == nil {
+		listing = parse(string(output))
+	}
+	toolCache.listings[binary] = listing
+	return listing
+}
+
+// forgetToolListing drops the cached listing of binary after it changed.
+func forgetToolListing(binary string) {
+	toolCache.Lock()
+	defer toolCache.Unlock()
+	delete(toolCache.listings, binary)
+}
+
+// pipxSource installs Python applications with pipx.
+type pipxSource struct{ r Runner }
+
+func (s pipxSource) Kind() string         { return SourcePipx }
+func (s pipxSource) NeedsPrivilege() bool { return !s.r.toolAvailable("pipx") }
+func (s pipxSource) Describe(item string) string {
+	return s.Installed()[ItemID(item)]
+}
+
+func (s pipxSource) Installed() map[string]string {
+	return s.r.toolInstalled("pipx", func() *exec.Cmd { return s.r.toolCmd("pipx", "list", "--short") }, parsePipxList)
+}
+
+func (s pipxSource) Install(item string) (bool, string) {
+	id := ItemID(item)
+	return s.r.runTool("pipx", "python-pipx", id, func() *exec.Cmd { return s.r.toolCmd("pipx", "install", id) })
+}
+
+func (s pipxSource) Remove(item string) (bool, string) {
+	id := ItemID(item)
+	output, err := s.r.toolCmd("pipx", "uninstall", id).CombinedOutput()
+	forgetToolListing("pipx")
+	return removeResult(id, output, err)
+}
+
+// parsePipxList parses "pipx list --short": one "name version" per line.
+func parsePipxList(output string) map[string]string {
+	installed := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			installed[fields[0]] = "version " + fields[1]
+		}
+	}
+	return installed
+}
+
+// cargoSource installs Rust binaries with cargo install.
+type cargoSource struct{ r Runner }
+
+func (s cargoSource) Kind() string         { return SourceCargo }
+func (s cargoSource) NeedsPrivilege() bool { return !s.r.toolAvailable("cargo") }
+func (s cargoSource) Describe(item string) string {
+	return s.Installed()[ItemID(item)]
+}
+
+func (s cargoSource) Installed() map[string]string {
+	return s.r.toolInstalled("cargo", func() *exec.Cmd { return s.r.toolCmd("cargo", "install", "--list") }, parseCargoList)
+}
+
+func (s cargoSource) Install(item string) (bool, string) {
+	id := ItemID(item)
+	return s.r.runTool("cargo", "rust", id, func() *exec.Cmd { return s.r.toolCmd("cargo", "install", "--locked", id) })
+}
+
+func (s cargoSource) Remove(item string) (bool, string) {
+	id := ItemID(item)
+	output, err := s.r.toolCmd("cargo", "uninstall", id).CombinedOutput()
+	forgetToolListing("cargo")
+	return removeResult(id, output, err)
+}
+
+// parseCargoList parses "cargo install --list", where each crate is an
+// unindented "name vX.Y.Z:" line followed by its indented binaries.
+func parseCargoList(output string) map[string]string {
+	installed := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, ":"))
+		if len(fields) >= 2 {
+			installed[fields[0]] = "version " + strings.TrimPrefix(fields[1], "v")
+		}
+	}
+	return installed
+}
+
+// npmSource installs Node.js packages globally for the selected user, under
+// ~/.local so that no root access is needed.
+type npmSource struct{ r Runner }
+
+func (s npmSource) Kind() string         { return SourceNpm }
+func (s npmSource) NeedsPrivilege() bool { return !s.r.toolAvailable("npm") }
+func (s npmSource) Describe(item string) string {
+	return s.Installed()[ItemID(item)]
+}
+
+// prefix returns the --prefix arguments for the user's global packages.
+func (s npmSource) prefix() []string {
+	return []string{"-g", "--prefix", path.Join(s.r.userHomeDir(), ".local")}
+}
+
+func (s npmSource) Installed() map[string]string {
+	return s.r.toolInstalled("npm", func() *exec.Cmd {
+		args := append([]string{"ls"}, s.prefix()...)
+		return s.r.toolCmd("npm", append(args, "--depth=0", "--json")...)
+	}, parseNpmList)
+}
+
+func (s npmSource) Install(item string) (bool, string) {
+	id := ItemID(item)
+	return s.r.runTool("npm", "npm", id, func() *exec.Cmd {
+		return s.r.toolCmd("npm", append(append([]string{"install"}, s.prefix()...), id)...)
+	})
+}
+
+func (s npmSource) Remove(item string) (bool, string) {
+	id := ItemID(item)
+	output, err := s.r.toolCmd("npm", append(append([]string{"uninstall"}, s.prefix()...), id)...).CombinedOutput()
+	forgetToolListing("npm")
+	return removeResult(id, output, err)
+}
+
+// parseNpmList parses "npm ls --json" output.
+func parseNpmList(output string) map[string]string {
+	var list struct {
+		Dependencies map[string]struct {
+			Version string `json:"version"`
+		} `json:"dependencies"`
+	}
+	installed := make(map[string]string)
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return installed
+	}
+	for name, dep := range list.Dependencies {
+		installed[name] = "version " + dep.Version
+	}
+	return installed
+}
+
+// goSource installs Go commands with go install. Items are package paths,
+// optionally followed by a [version] annotation; the default is @latest.
+type goSource struct{ r Runner }
+
+// goBinDirScript prints nothing but sets $dir to the directory go install
+// writes binaries to.
+const goBinDirScript = `dir=$(go env GOBIN); [ -n "$dir" ] || dir=$(go env GOPATH)/bin; `
+
+var goMajorSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+func (s goSource) Kind() string         { return SourceGo }
+func (s goSource) NeedsPrivilege() bool { return !s.r.toolAvailable("go") }
+func (s goSource) Describe(item string) string {
+	return s.Installed()[ItemID(item)]
+}
+
+func (s goSource) Installed() map[string]string {
+	return s.r.toolInstalled("go", func() *exec.Cmd {
+		return s.r.toolCmd("sh", "-c", goBinDirScript+`go version -m "$dir"`)
+	}, parseGoVersionM)
+}
+
+func (s goSource) Install(item string) (bool, string) {
+	id := ItemID(item)
+	version := "latest"
+	for _, field := range strings.Fields(item) {
+		if field != id && strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+			version = strings.Trim(field, "[]")
+		}
+	}
+	return s.r.runTool("go", "go", id, func() *exec.Cmd { return s.r.toolCmd("go", "install", id+"@"+version) })
+}
+
+// Remove deletes the installed binary; go has no uninstall command.
+func (s goSource) Remove(item string) (bool, string) {
+	id := ItemID(item)
+	script := fmt.Sprintf(`%srm -f "$dir/%s"`, goBinDirScript, goBinaryName(id))
+	output, err := s.r.toolCmd("sh", "-c", script).CombinedOutput()
+	forgetToolListing("go")
+	return removeResult(id, output, err)
+}
+
+// goBinaryName returns the binary name go install uses for a package path,
+// skipping a trailing major version element such as /v2.
+func goBinaryName(pkg string) string {
+	elems := strings.Split(strings.Trim(pkg, "/"), "/")
+	name := elems[len(elems)-1]
+	if goMajorSuffix.MatchString(name) && len(elems) > 1 {
+		name = elems[len(elems)-2]
+	}
+	return name
+}
+
+// parseGoVersionM parses "go version -m <dir>", mapping each binary's main
+// package path to its module version.
+func parseGoVersionM(output string) map[string]string {
+	installed := make(map[string]string)
+	var current string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 2 && fields[0] == "path":
+			current = fields[1]
+			installed[current] = ""
+		case len(fields) >= 3 && fields[0] == "mod" && current != "":
+			installed[current] = "version " + strings.TrimPrefix(fields[2], "v")
+		}
+	}
+	return installed
+}
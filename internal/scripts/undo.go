@@ -0,0 +1,148 @@
+package scripts
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/fcarp10/archutils/internal/history"
+	"github.com/fcarp10/archutils/internal/session"
+)
+
+// SourceUndo removes packages an archutils run installed; items are package
+// names in removal order, see PlanUndo.
+const SourceUndo = "undo"
+
+// History returns the pacman transactions of the target, tied to the
+// archutils runs that caused them, newest first.
+func (r Runner) History() ([]history.Entry, error) {
+	transactions, err := history.Load(r.Root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pacman log: %v", err)
+	}
+	records, err := session.Archived()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session archive %s: %v", session.ArchivePath(), err)
+	}
+	return history.Group(transactions, records), nil
+}
+
+// UndoPlan is how the packages a run installed are removed again.
+type UndoPlan struct {
+	// Remove are the packages to remove, each before the packages it needs.
+	Remove []string
+	// Kept are the packages still required by packages that stay installed,
+	// with those packages.
+	Kept map[string][]string
+}
+
+// PlanUndo checks the reverse dependencies of the packages a run installed.
+// Packages that are no longer installed are left out.
+func (r Runner) PlanUndo(pkgs []string) UndoPlan {
+	var local []packageInfo
+	if output, err := r.target().query("-Qi").Output(); err == nil {
+		local = parsePackageInfo(string(output))
+	}
+	return planUndo(pkgs, local)
+}
+
+// planUndo keeps the packages that an installed package outside the removal
+// set requires, directly or through other kept packages, and orders the rest
+// so that no package is removed before a package that requires it.
+func planUndo(pkgs []string, local []packageInfo) UndoPlan {
+	requiredBy := make(map[string][]string)
+	for _, info := range local {
+		if slices.Contains(pkgs, info.name) {
+			requiredBy[info.name] = info.requiredBy
+		}
+	}
+	removing := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if _, installed := requiredBy[pkg]; installed {
+			removing[pkg] = true
+		}
+	}
+
+	plan := UndoPlan{Kept: make(map[string][]string)}
+	for changed := true; changed; {
+		changed = false
+		for _, pkg := range pkgs {
+			if !removing[pkg] {
+				continue
+			}
+			var needed []string
+			for _, dependent := range requiredBy[pkg] {
+				if !removing[dependent] {
+					needed = append(needed, dependent)
+				}
+			}
+			if len(needed) > 0 {
+				removing[pkg] = false
+				plan.Kept[pkg] = needed
+				changed = true
+			}
+		}
+	}
+
+	// Dependents first: a package is removed once everything in the removal
+	// set that requires it is.
+	removed := make(map[string]bool)
+	for len(plan.Remove) < countTrue(removing) {
+		progress := false
+		for _, pkg := range pkgs {
+			if !removing[pkg] || removed[pkg] {
+				continue
+			}
+			ready := true
+			for _, dependent := range requiredBy[pkg] {
+				if removing[dependent] && !removed[dependent] && dependent != pkg {
+					ready = false
+				}
+			}
+			if ready {
+				plan.Remove = append(plan.Remove, pkg)
+				removed[pkg] = true
+				progress = true
+			}
+		}
+		if !progress {
+			// Dependency cycle: the rest is removed in list order, and pacman
+			// reports the packages of the cycle it cannot remove alone.
+			for _, pkg := range pkgs {
+				if removing[pkg] && !removed[pkg] {
+					plan.Remove = append(plan.Remove, pkg)
+					removed[pkg] = true
+				}
+			}
+		}
+	}
+	return plan
+}
+
+func countTrue(set map[string]bool) int {
+	n := 0
+	for _, v := range set {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
+// undoSource removes packages one by one without their dependencies, which
+// are removed as items of their own when the run installed them.
+type undoSource struct{ r Runner }
+
+func (s undoSource) Kind() string                 { return SourceUndo }
+func (s undoSource) NeedsPrivilege() bool         { return true }
+func (s undoSource) Installed() map[string]string { return nil }
+func (s undoSource) Describe(item string) string  { return s.r.GetPackageDescription(ItemID(item)) }
+
+func (s undoSource) Install(item string) (bool, string) {
+	id := ItemID(item)
+	output, err := s.r.target().run("pacman", "-R", "--noconfirm", id).CombinedOutput()
+	return removeResult(id, output, err)
+}
+
+func (s undoSource) Remove(item string) (bool, string) {
+	return false, fmt.Sprintf("%s: Undone removals cannot be undone again, install it instead", ItemID(item))
+}
@@ -0,0 +1,110 @@
+package scripts
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// PackageUpdate is a package a system upgrade will change.
+type PackageUpdate struct {
+	Name string
+	From string
+	To   string
+	// AUR is set for updates found by the AUR helper.
+	AUR bool
+}
+
+// rebootPackages are the packages, besides kernels, whose update only takes
+// full effect after a reboot.
+var rebootPackages = map[string]bool{
+	"systemd":      true,
+	"systemd-libs": true,
+	"glibc":        true,
+}
+
+// NeedsReboot reports whether the update only takes full effect after a
+// reboot: kernels, systemd and glibc.
+func (u PackageUpdate) NeedsReboot() bool {
+	if rebootPackages[u.Name] {
+		return true
+	}
+	if u.Name != "linux" && !strings.HasPrefix(u.Name, "linux-") {
+		return false
+	}
+	switch {
+	case strings.HasSuffix(u.Name, "-headers"), strings.HasSuffix(u.Name, "-docs"),
+		strings.HasPrefix(u.Name, "linux-firmware"), u.Name == "linux-api-headers":
+		return false
+	}
+	return true
+}
+
+// PendingUpgrades lists the packages a system upgrade will change. Repository
+// updates come from checkupdates when it is installed, which syncs a
+// temporary copy of the databases, and from the current sync databases
+// otherwise; synced reports the former. Without it the list can miss
+// updates released since the databases were last synced.
+func (r Runner) PendingUpgrades() (updates []PackageUpdate, synced bool, err error) {
+	t := r.target()
+	cmd := t.query("-Qu")
+	if !t.chrooted() && r.toolAvailable("checkupdates") {
+		cmd = exec.Command("checkupdates")
+		synced = true
+	}
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	// Both exit with a non-zero status when there is nothing to upgrade.
+	if err != nil && !(errors.As(err, &exitErr) && len(strings.TrimSpace(string(output))) == 0) {
+		return nil, false, fmt.Errorf("failed to list updates: %v", err)
+	}
+	updates = parseUpdates(string(output), false)
+
+	h := r.helper()
+	if len(h.aurUpdatesArgs) > 0 && !t.chrooted() && h.installedOn(t) {
+		aurCmd := exec.Command(h.Name, h.aurUpdatesArgs...)
+		if t.usesBuildUser() {
+			aurCmd = t.asBuildUser(h.Name, h.aurUpdatesArgs...)
+		}
+		if output, err := aurCmd.Output(); err == nil {
+			updates = append(updates, parseUpdates(string(output), true)...)
+		}
+	}
+	return updates, synced, nil
+}
+
+// parseUpdates parses "name old -> new" lines.
+func parseUpdates(output string, aur bool) []PackageUpdate {
+	var updates []PackageUpdate
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[2] == "->" {
+			updates = append(updates, PackageUpdate{Name: fields[0], From: fields[1], To: fields[3], AUR: aur})
+		}
+	}
+	return updates
+}
+
+// UpgradeCmd returns the non-interactive command upgrading the target: the
+// AUR helper's -Syu when it is installed, pacman's otherwise and inside a
+// mounted target. As root, pacman upgrades and the build user rebuilds the
+// AUR updates, see buildUserUpgradeCmd.
+func (r Runner) UpgradeCmd() *exec.Cmd {
+	t := r.target()
+	h := r.helper()
+	if t.chrooted() || !h.installedOn(t) {
+		return t.run("pacman", "-Syu", "--noconfirm")
+	}
+	if t.usesBuildUser() {
+		if err := ensureBuildUser(t); err != nil {
+			return exec.Command("sh", "-c", `echo "$1" >&2; exit 1`, "sh", err.Error())
+		}
+		return t.buildUserUpgradeCmd(h)
+	}
+	args := []string{"-Syu", "--noconfirm"}
+	if tool := privilegeTool(); tool != PrivilegeSudo && h.sudoFlag != "" {
+		args = append(args, h.sudoFlag, tool)
+	}
+	return exec.Command(h.Name, args...)
+}
@@ -0,0 +1,87 @@
+package scripts
+
+import (
+	"bufio"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// passwdPath is the passwd database read by LoginUsers. Tests point it at a fixture.
+var passwdPath = "/etc/passwd"
+
+// readLoginUsers parses a passwd file and returns the accounts that belong to
+// real people: a regular UID range (1000-59999) and a shell that allows login.
+func readLoginUsers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var users []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil || uid < 1000 || uid >= 60000 {
+			continue
+		}
+		shell := fields[6]
+		if shell == "" || strings.HasSuffix(shell, "/nologin") || strings.HasSuffix(shell, "/false") {
+			continue
+		}
+		users = append(users, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// currentUsername returns the account the process runs as.
+func currentUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// invokingUser returns the user who started archutils. Under sudo or doas the
+// process runs as root, so SUDO_USER and DOAS_USER take precedence over the
+// process owner. $USER is not trusted: sudo -i and su rewrite or keep it
+// depending on flags.
+func invokingUser() string {
+	for _, env := range []string{"SUDO_USER", "DOAS_USER"} {
+		if name := os.Getenv(env); name != "" && name != "root" {
+			return name
+		}
+	}
+	return currentUsername()
+}
+
+// userHome returns the home directory of name from the passwd file at path.
+func userHome(path, name string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 7 && fields[0] == name {
+			return fields[5]
+		}
+	}
+	return ""
+}
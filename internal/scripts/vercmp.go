@@ -0,0 +1,126 @@
+package scripts
+
+import (
+	"strings"
+)
+
+// vercmp compares two pacman versions ([epoch:]version[-release]) like
+// pacman's vercmp, returning -1, 0 or 1.
+func vercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, verA, relA := parseEVR(a)
+	epochB, verB, relB := parseEVR(b)
+	if ret := rpmvercmp(epochA, epochB); ret != 0 {
+		return ret
+	}
+	if ret := rpmvercmp(verA, verB); ret != 0 {
+		return ret
+	}
+	if relA != "" && relB != "" {
+		return rpmvercmp(relA, relB)
+	}
+	return 0
+}
+
+// parseEVR splits a version into epoch, version and release. The epoch
+// defaults to 0 and the release is empty when there is none.
+func parseEVR(evr string) (epoch, version, release string) {
+	epoch = "0"
+	i := 0
+	for i < len(evr) && isDigit(evr[i]) {
+		i++
+	}
+	if i < len(evr) && evr[i] == ':' {
+		if i > 0 {
+			epoch = evr[:i]
+		}
+		evr = evr[i+1:]
+	}
+	if dash := strings.LastIndexByte(evr, '-'); dash >= 0 {
+		return epoch, evr[:dash], evr[dash+1:]
+	}
+	return epoch, evr, ""
+}
+
+// rpmvercmp compares version segments the way libalpm does: runs of digits
+// compare numerically, runs of letters lexically, and digits beat letters.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	one, two := a, b
+	for one != "" && two != "" {
+		trimmedOne := strings.TrimLeftFunc(one, isSeparator)
+		trimmedTwo := strings.TrimLeftFunc(two, isSeparator)
+		sepOne, sepTwo := len(one)-len(trimmedOne), len(two)-len(trimmedTwo)
+		one, two = trimmedOne, trimmedTwo
+		if one == "" || two == "" {
+			break
+		}
+		if sepOne != sepTwo {
+			if sepOne < sepTwo {
+				return -1
+			}
+			return 1
+		}
+
+		isNum := isDigit(one[0])
+		segment := isAlpha
+		if isNum {
+			segment = isDigit
+		}
+		segOne, segTwo := leading(one, segment), leading(two, segment)
+		one, two = one[len(segOne):], two[len(segTwo):]
+		if segTwo == "" {
+			// The segments are of different types: numbers are newer.
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if isNum {
+			segOne = strings.TrimLeft(segOne, "0")
+			segTwo = strings.TrimLeft(segTwo, "0")
+			if len(segOne) != len(segTwo) {
+				if len(segOne) > len(segTwo) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(segOne, segTwo); c != 0 {
+			return c
+		}
+	}
+
+	if one == "" && two == "" {
+		return 0
+	}
+	// A remaining alpha segment never beats an empty string: "1.0" is newer
+	// than "1.0alpha", while "1.0.1" is newer than "1.0".
+	if (one == "" && !isAlpha(two[0])) || (one != "" && isAlpha(one[0])) {
+		return -1
+	}
+	return 1
+}
+
+// leading returns the prefix of s whose bytes satisfy class.
+func leading(s string, class func(byte) bool) string {
+	i := 0
+	for i < len(s) && class(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isAlpha(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+// isSeparator reports whether r separates version segments, which is any
+// character but an ASCII letter or digit.
+func isSeparator(r rune) bool {
+	return r >= 0x80 || (!isDigit(byte(r)) && !isAlpha(byte(r)))
+}
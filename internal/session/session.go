@@ -0,0 +1,199 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Result is the outcome of a single item in an install run.
+type Result struct {
+	Item    string `json:"item"`
+	Success bool   `json:"success"`
+	Log     string `json:"log"`
+}
+
+// State is the on-disk record of an install run. Items move from Pending to
+// Results as they finish, so an interrupted run can be resumed.
+type State struct {
+	// Source is the kind of the item source, see scripts.Source.
+	Source string `json:"source"`
+	// ItemType is the numeric item type written by older versions
+	// (0 packages, 1 VSCode extensions, 2 Flatpak); Load maps it to Source.
+	ItemType  int       `json:"item_type,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Pending   []string  `json:"pending"`
+	Results   []Result  `json:"results"`
+	// Snapshot is the file system snapshot taken before the run, if any.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+}
+
+// Snapshot is a file system snapshot taken before a run.
+type Snapshot struct {
+	// Tool is the snapshot tool, snapper or timeshift.
+	Tool string `json:"tool"`
+	// ID is the snapshot number of snapper or the snapshot name of timeshift.
+	ID string `json:"id"`
+}
+
+func (s Snapshot) String() string {
+	if s.Tool == "snapper" {
+		return "snapper #" + s.ID
+	}
+	return s.Tool + " " + s.ID
+}
+
+// Unfinished reports whether the run still has items left to install.
+func (s State) Unfinished() bool {
+	return len(s.Pending) > 0
+}
+
+// Record moves item from Pending to Results.
+func (s State) Record(item string, success bool, log string) State {
+	for i, pending := range s.Pending {
+		if pending == item {
+			s.Pending = append(s.Pending[:i:i], s.Pending[i+1:]...)
+			break
+		}
+	}
+	s.Results = append(s.Results, Result{Item: item, Success: success, Log: log})
+	return s
+}
+
+// statePath is where the session is stored. Tests point it at a temp dir.
+var statePath = defaultPath()
+
+// defaultPath returns $XDG_STATE_HOME/archutils/session.json, falling back to
+// ~/.local/state when XDG_STATE_HOME is unset.
+func defaultPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "archutils", "session.json")
+}
+
+// SetPath overrides the location of the session file.
+func SetPath(path string) {
+	statePath = path
+}
+
+// Path returns the location of the session file.
+func Path() string {
+	return statePath
+}
+
+// Save writes the session atomically so a crash never leaves a torn file.
+func Save(s State) error {
+	if err := os.MkdirAll(filepath.Dir(statePath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath)
+}
+
+// Load reads the saved session. The boolean is false when there is none.
+func Load() (State, bool, error) {
+	data, err := os.ReadFile(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, false, nil
+	}
+	if err != nil {
+		return State{}, false, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return State{}, false, err
+	}
+	if s.Source == "" {
+		legacySources := []string{"packages", "vscode", "flatpak"}
+		if s.ItemType >= 0 && s.ItemType < len(legacySources) {
+			s.Source = legacySources[s.ItemType]
+		}
+		s.ItemType = 0
+	}
+	return s, true, nil
+}
+
+// Clear removes the saved session.
+func Clear() error {
+	err := os.Remove(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// SourceUpgrade is the Source of archived system upgrades, whose Results are
+// the packages they upgraded.
+const SourceUpgrade = "upgrade"
+
+// Record is a finished run kept in the session archive, so the pacman
+// transactions it caused can be told apart from others.
+type Record struct {
+	Source     string    `json:"source"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Results    []Result  `json:"results"`
+	Snapshot   *Snapshot `json:"snapshot,omitempty"`
+}
+
+// ArchivePath returns the location of the session archive, a JSON line per
+// finished run next to the session file.
+func ArchivePath() string {
+	return filepath.Join(filepath.Dir(statePath), "history.jsonl")
+}
+
+// Archive appends the finished run to the session archive.
+func Archive(s State, finishedAt time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(statePath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(Record{Source: s.Source, StartedAt: s.StartedAt, FinishedAt: finishedAt, Results: s.Results, Snapshot: s.Snapshot})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ArchivePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Archived returns the archived runs, oldest first. Lines that cannot be
+// parsed, e.g. one torn by a crash, are skipped.
+func Archived() ([]Record, error) {
+	f, err := os.Open(ArchivePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err == nil {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
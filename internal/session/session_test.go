@@ -0,0 +1,131 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func useTempPath(t *testing.T) {
+	orig := statePath
+	statePath = filepath.Join(t.TempDir(), "archutils", "session.json")
+	t.Cleanup(func() { statePath = orig })
+}
+
+func TestLoad_NoSession(t *testing.T) {
+	useTempPath(t)
+
+	_, ok, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok {
+		t.Error("expected no session")
+	}
+}
+
+func TestSaveLoadClear(t *testing.T) {
+	useTempPath(t)
+
+	s := State{
+		Source:    "pipx",
+		StartedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Pending:   []string{"git", "docker [docker]"},
+	}
+	if err := Save(s); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded, ok, err := Load()
+	if err != nil || !ok {
+		t.Fatalf("load: ok=%v err=%v", ok, err)
+	}
+	if loaded.Source != "pipx" || len(loaded.Pending) != 2 || !loaded.StartedAt.Equal(s.StartedAt) {
+		t.Errorf("unexpected state: %+v", loaded)
+	}
+
+	if err := Clear(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if _, ok, _ := Load(); ok {
+		t.Error("expected session to be cleared")
+	}
+	if err := Clear(); err != nil {
+		t.Errorf("clearing twice should not fail: %v", err)
+	}
+}
+
+func TestLoad_LegacyItemType(t *testing.T) {
+	useTempPath(t)
+	if err := os.MkdirAll(filepath.Dir(statePath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"item_type":2,"started_at":"2026-01-02T03:04:05Z","pending":["org.videolan.VLC"]}`
+	if err := os.WriteFile(statePath, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, ok, err := Load()
+	if err != nil || !ok {
+		t.Fatalf("load: ok=%v err=%v", ok, err)
+	}
+	if loaded.Source != "flatpak" || loaded.ItemType != 0 {
+		t.Errorf("expected the legacy item type to map to flatpak, got %+v", loaded)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := State{Pending: []string{"a", "b", "c"}}
+	orig := s.Pending
+
+	s = s.Record("b", false, "b: failed")
+	if len(s.Pending) != 2 || s.Pending[0] != "a" || s.Pending[1] != "c" {
+		t.Errorf("unexpected pending: %v", s.Pending)
+	}
+	if len(s.Results) != 1 || s.Results[0].Item != "b" || s.Results[0].Success {
+		t.Errorf("unexpected results: %+v", s.Results)
+	}
+	if orig[1] != "b" {
+		t.Error("Record must not modify the previous state's slice")
+	}
+	if !s.Unfinished() {
+		t.Error("expected session to be unfinished")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	useTempPath(t)
+
+	if records, err := Archived(); err != nil || records != nil {
+		t.Fatalf("expected an empty archive, got %v, %v", records, err)
+	}
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := State{Source: "packages", StartedAt: start, Snapshot: &Snapshot{Tool: "snapper", ID: "42"}}.Record("git", true, "git: ok")
+	if err := Archive(s, start.Add(time.Minute)); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if err := Archive(State{Source: "undo", StartedAt: start.Add(time.Hour)}, start.Add(2*time.Hour)); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	f, err := os.OpenFile(ArchivePath(), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"source\": \"torn")
+	f.Close()
+
+	records, err := Archived()
+	if err != nil {
+		t.Fatalf("archived: %v", err)
+	}
+	if len(records) != 2 || records[0].Source != "packages" || records[1].Source != "undo" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if !records[0].FinishedAt.Equal(start.Add(time.Minute)) || len(records[0].Results) != 1 {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+	if snap := records[0].Snapshot; snap == nil || snap.String() != "snapper #42" || records[1].Snapshot != nil {
+		t.Errorf("expected only the first run to have a snapshot, got %v, %v", snap, records[1].Snapshot)
+	}
+}
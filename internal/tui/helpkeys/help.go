@@ -12,6 +12,7 @@ type keyMap struct {
 	Search        key.Binding
 	ConfirmYes    key.Binding
 	ConfirmNo     key.Binding
+	Review        key.Binding
 	CancelInstall key.Binding
 	Help          key.Binding
 	Quit          key.Binding
@@ -59,6 +60,10 @@ var Keys = keyMap{
 		key.WithKeys("n"),
 		key.WithHelp("n", "Cancel install"),
 	),
+	Review: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "Review PKGBUILDs"),
+	),
 	CancelInstall: key.NewBinding(
 		key.WithKeys("c"),
 		key.WithHelp("c", "Cancel install"),
@@ -82,7 +87,7 @@ func (k keyMap) FullHelp() [][]key.Binding {
 		{k.Up, k.Down, k.Back},
 		{k.Enter, k.SelectAll, k.DeselectAll},
 		{k.Search, k.Install, k.CancelInstall},
-		{k.ConfirmYes, k.ConfirmNo, k.Help},
-		{k.Quit},
+		{k.ConfirmYes, k.ConfirmNo, k.Review},
+		{k.Help, k.Quit},
 	}
 }
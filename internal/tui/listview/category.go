@@ -2,10 +2,10 @@ package listview
 
 import (
 	"fmt"
-	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/scripts"
 )
 
 func (m Model) viewCategory() string {
@@ -33,38 +33,42 @@ func (m Model) viewCategory() string {
 }
 
 func (m Model) handleCategoryEnter() (Model, tea.Cmd) {
+	if m.maintenance {
+		return m.handleMaintenanceEnter()
+	}
+	if m.historyEntries != nil {
+		return m.handleHistoryEnter()
+	}
+	if m.cursor >= len(m.categories) {
+		return m, nil
+	}
+	category := m.categories[m.cursor]
+	items, origins := hardwareItems(m.hardware, category)
+	category.Items = items
 	var names []string
-	for _, item := range m.categories[m.cursor].Items {
+	for _, item := range items {
 		names = append(names, item.Name)
 	}
-	m.itemNames = names
 	m.itemNames, m.selectedItems = initializeSelection(names)
+	// Items for hardware that was not detected are listed but not preselected.
+	for i, item := range items {
+		if reason, _ := unmetCondition(m.hardware, item.Conditions); reason != "" {
+			delete(m.selectedItems, i)
+		}
+	}
 	m.installedItems = make(map[int]bool)
 
-	switch m.directory {
-	case config.PkgsDir():
-		installed := m.installer.GetInstalledPackages()
-		for i, item := range m.itemNames {
-			fields := strings.Fields(item)
-			if len(fields) == 0 {
-				continue
-			}
-			pkgName := fields[0]
-			if desc, ok := installed[pkgName]; ok {
-				m.installedItems[i] = true
-				m.categories[m.cursor].Items[i].Description = desc
-			}
-		}
-	case config.ExtDir():
-		for i, item := range m.itemNames {
-			if m.installer.IsExtensionInstalled(item) {
-				m.installedItems[i] = true
-				m.categories[m.cursor].Items[i].Description = m.installer.GetExtensionDescription(item)
-			}
+	for i, desc := range scripts.InstalledItems(m.installer, config.SourceKind(m.directory), m.itemNames) {
+		m.installedItems[i] = true
+		// Descriptions from the category file are kept.
+		if category.Items[i].Description != "" {
+			continue
 		}
+		category.Items[i].Description = desc
+		m.categories[m.cursor].Items[origins[i]].Description = desc
 	}
 
-	m.selectedCategory = m.categories[m.cursor]
+	m.selectedCategory = category
 	m.cursor = 0
 	m.currentStage = stageItems
 	m.searchMode = false
@@ -49,21 +49,45 @@ func (m Model) handleConfirmYes() (Model, tea.Cmd) {
 	m.logsVisible = true
 	m.searchMode = false
 	m.searchQuery = ""
-	var selectedItemNames []string
-	for idx := range m.selectedItems {
-		if idx < len(m.itemNames) {
-			selectedItemNames = append(selectedItemNames, m.itemNames[idx])
+	selectedItemNames := m.selectedItemNames()
+	blocked := m.blockedItems(selectedItemNames)
+	if len(blocked) > 0 {
+		var allowed []string
+		for _, name := range selectedItemNames {
+			if _, ok := blocked[name]; !ok {
+				allowed = append(allowed, name)
+			}
 		}
+		selectedItemNames = allowed
 	}
+	if len(selectedItemNames) == 0 {
+		_, helperMsg := m.installer.CheckHelperInstalled()
+		m.currentStage = stageItems
+		m.logsView = logsview.NewInfo("Nothing to install: all selected items come from the AUR.\n\n" + helperMsg)
+		return m, nil
+	}
+	m.pendingInstall = selectedItemNames
+	return m.startPreflight()
+}
+
+// startInstall installs the items that passed the pre-flight checks, after
+// taking a snapshot with snapshotTool unless it is "".
+func (m Model) startInstall(snapshotTool string) (Model, tea.Cmd) {
 	m.currentStage = stageInstalling
-	var installType logsview.ItemsInstallType
-	switch m.directory {
-	case config.PkgsDir():
-		installType = logsview.InstallPackages
-	case config.ExtDir():
-		installType = logsview.InstallExtensions
+	m.logsVisible = true
+	kind := config.SourceKind(m.directory)
+	installType := logsview.ItemsInstallType(kind)
+	if m.pendingResume != nil {
+		m.logsView = logsview.NewResumedItems(*m.pendingResume, m.pendingInstall, m.installer)
+	} else {
+		m.logsView = logsview.NewItems(m.pendingInstall, m.installer)
+	}
+	if snapshotTool != "" {
+		description := fmt.Sprintf("archutils: before %s run of %d item(s)", kind, len(m.pendingInstall))
+		m.logsView = m.logsView.WithSnapshot(snapshotTool, description)
 	}
-	m.logsView = logsview.NewItems(selectedItemNames, m.installer)
+	m.pendingInstall = nil
+	m.pendingResume = nil
 	var cmd tea.Cmd
 	m.logsView, cmd = m.logsView.Update(logsview.InstallItems(installType))
 	return m, cmd
@@ -0,0 +1,451 @@
+package listview
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+// Form identifiers; formNone means no form is open.
+const (
+	formNone = iota
+	formAutologin
+	formHelperInstall
+	formUser
+	formProviders
+	formConflicts
+	formCacheRetention
+	formConfigActions
+)
+
+var (
+	formLabelStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("241"))
+	formErrorStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196"))
+)
+
+// formField is a single row of a parameter form. Fields with options are
+// cycled with ←/→, the others are edited as free text.
+type formField struct {
+	label   string
+	value   string
+	options []string
+	// optionLabels maps option values to display names (e.g. "" → "none").
+	optionLabels map[string]string
+}
+
+// form is a small parameter form rendered in the right-hand pane.
+type form struct {
+	title  string
+	fields []formField
+	focus  int
+	err    string
+}
+
+func (f *form) cycle(delta int) {
+	field := &f.fields[f.focus]
+	if len(field.options) == 0 {
+		return
+	}
+	idx := 0
+	for i, opt := range field.options {
+		if opt == field.value {
+			idx = i
+			break
+		}
+	}
+	idx = (idx + delta + len(field.options)) % len(field.options)
+	field.value = field.options[idx]
+}
+
+func (f form) value(label string) string {
+	for _, field := range f.fields {
+		if field.label == label {
+			return strings.TrimSpace(field.value)
+		}
+	}
+	return ""
+}
+
+func (f form) view() string {
+	width := 0
+	for _, field := range f.fields {
+		if w := lipgloss.Width(field.label); w > width {
+			width = w
+		}
+	}
+
+	s := f.title + "\n\n"
+	for i, field := range f.fields {
+		cursor := " "
+		value := field.value
+		if len(field.options) > 0 {
+			if label, ok := field.optionLabels[value]; ok {
+				value = label
+			}
+			value = "‹ " + value + " ›"
+		} else if i == f.focus {
+			value += "▎"
+		}
+		label := formLabelStyle.Render(fmt.Sprintf("%-*s", width+1, field.label+":"))
+		line := fmt.Sprintf("%s %s", label, value)
+		if i == f.focus {
+			cursor = listItemSelectedStyle.Render("❯")
+			line = fmt.Sprintf("%s %s", label, listItemSelectedStyle.Render(value))
+		}
+		s += fmt.Sprintf("%s %s\n", cursor, line)
+	}
+	if f.err != "" {
+		s += "\n" + formErrorStyle.Render(f.err) + "\n"
+	}
+	s += "\n  tab/↑↓: Move   ←/→: Change   enter: Apply   esc: Cancel"
+	return s
+}
+
+// openForm shows the given form in the right-hand pane.
+func (m Model) openForm(kind int, f form) Model {
+	m.formKind = kind
+	m.form = f
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo(m.form.view())
+	return m
+}
+
+func (m Model) closeForm(info string) Model {
+	m.formKind = formNone
+	m.form = form{}
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo(info)
+	return m
+}
+
+// handleFormInput edits the open form. Navigation keys are matched by key type
+// rather than through helpkeys so that letters like j/k/q can be typed.
+func (m Model) handleFormInput(msg tea.KeyMsg) (Model, tea.Cmd) {
+	field := &m.form.fields[m.form.focus]
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		if m.formKind == formProviders || m.formKind == formConflicts || m.formKind == formConfigActions {
+			m.currentStage = stageItems
+		}
+		return m.closeForm("Cancelled."), nil
+	case tea.KeyEnter:
+		return m.submitForm()
+	case tea.KeyTab, tea.KeyDown:
+		m.form.focus = (m.form.focus + 1) % len(m.form.fields)
+	case tea.KeyShiftTab, tea.KeyUp:
+		m.form.focus = (m.form.focus - 1 + len(m.form.fields)) % len(m.form.fields)
+	case tea.KeyLeft:
+		m.form.cycle(-1)
+	case tea.KeyRight:
+		m.form.cycle(1)
+	case tea.KeyBackspace:
+		if len(field.options) == 0 && len(field.value) > 0 {
+			runes := []rune(field.value)
+			field.value = string(runes[:len(runes)-1])
+		}
+	case tea.KeySpace:
+		if len(field.options) == 0 {
+			field.value += " "
+		}
+	case tea.KeyRunes:
+		if len(field.options) == 0 && !msg.Alt {
+			field.value += string(msg.Runes)
+		}
+	}
+	m.form.err = ""
+	m.logsView = logsview.NewInfo(m.form.view())
+	return m, nil
+}
+
+func (m Model) submitForm() (Model, tea.Cmd) {
+	switch m.formKind {
+	case formAutologin:
+		return m.submitAutologinForm()
+	case formHelperInstall:
+		return m.submitHelperInstallForm()
+	case formUser:
+		return m.submitUserForm()
+	case formProviders:
+		return m.submitProvidersForm()
+	case formConflicts:
+		return m.submitConflictsForm()
+	case formCacheRetention:
+		return m.submitCacheRetentionForm()
+	case formConfigActions:
+		return m.submitConfigActionsForm()
+	}
+	return m.closeForm(""), nil
+}
+
+func (m Model) autologinForm() form {
+	return form{
+		title: "Enable Autologin",
+		fields: []formField{
+			{label: "TTY", value: "tty1"},
+			m.userField(),
+			{
+				label:        "Session type",
+				value:        scripts.AutologinSessionTypes[0],
+				options:      scripts.AutologinSessionTypes,
+				optionLabels: map[string]string{"": "none"},
+			},
+			{label: "Environment", value: ""},
+		},
+	}
+}
+
+func (m Model) submitAutologinForm() (Model, tea.Cmd) {
+	env, err := scripts.ParseEnvironment(m.form.value("Environment"))
+	opts := scripts.AutologinOptions{
+		TTY:         m.form.value("TTY"),
+		User:        m.form.value("User"),
+		SessionType: m.form.value("Session type"),
+		Environment: env,
+	}
+	if err == nil {
+		err = opts.Validate()
+	}
+	if err != nil {
+		m.form.err = err.Error()
+		m.logsView = logsview.NewInfo(m.form.view())
+		return m, nil
+	}
+	m.formKind = formNone
+	m.form = form{}
+	m.logsVisible = true
+	m.logsView = logsview.NewScript(m.installer).WithAutologinOptions(opts)
+	var cmd tea.Cmd
+	m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptAutologin))
+	return m, cmd
+}
+
+// helperInstallForm asks how to bootstrap the AUR helper. After a failed
+// attempt it offers to resume from the step that failed.
+func (m Model) helperInstallForm() form {
+	helper := scripts.HelperTitle(m.installer.AURHelperName())
+	title := "Install " + helper
+	if installed, _ := m.installer.CheckHelperInstalled(); installed {
+		title = fmt.Sprintf("%s is already installed. Reinstall it?", helper)
+	}
+
+	last := scripts.BootstrapOptions{Strategy: scripts.BootstrapSource}
+	if m.helperFailure != nil {
+		last = m.helperFailure.Opts
+	}
+	fields := []formField{
+		{label: "Strategy", value: last.Strategy, options: m.installer.HelperStrategies()},
+		{label: "Package file", value: last.Path},
+		{label: "Repository", value: last.Repo},
+		{label: "Review PKGBUILD", value: "no", options: []string{"no", "yes"}},
+	}
+	if f := m.helperFailure; f != nil {
+		names := m.installer.HelperStepNames(f.Opts)
+		resume := fmt.Sprintf("from step %d", f.Step+1)
+		if f.Step < len(names) {
+			resume = fmt.Sprintf("from step %d/%d (%s)", f.Step+1, len(names), names[f.Step])
+		}
+		title += fmt.Sprintf("\n\nThe last %s attempt failed at step %d.", f.Opts.Strategy, f.Step+1)
+		fields = append(fields, formField{
+			label:        "Start",
+			value:        "resume",
+			options:      []string{"resume", "restart"},
+			optionLabels: map[string]string{"resume": resume, "restart": "from the beginning"},
+		})
+	}
+	return form{title: title, fields: fields}
+}
+
+func (m Model) submitHelperInstallForm() (Model, tea.Cmd) {
+	opts := scripts.BootstrapOptions{Strategy: m.form.value("Strategy")}
+	switch opts.Strategy {
+	case scripts.BootstrapLocalFile:
+		opts.Path = m.form.value("Package file")
+	case scripts.BootstrapLocalRepo:
+		opts.Repo = m.form.value("Repository")
+	}
+	err := m.installer.ValidateBootstrap(opts)
+	start := 0
+	if err == nil && m.form.value("Start") == "resume" && m.helperFailure != nil {
+		// The failed attempt may have been pinned to a reviewed commit.
+		failed := m.helperFailure.Opts
+		failed.Commit = ""
+		if failed != opts {
+			err = fmt.Errorf("cannot resume: the failed attempt used the %s strategy", m.helperFailure.Opts.Strategy)
+		}
+		start = m.helperFailure.Step
+	}
+	if err != nil {
+		m.form.err = err.Error()
+		m.logsView = logsview.NewInfo(m.form.view())
+		return m, nil
+	}
+
+	review := m.form.value("Review PKGBUILD") == "yes"
+	m.formKind = formNone
+	m.form = form{}
+	m.helperBootstrap = opts
+	m.helperStartStep = start
+	if pkg := m.installer.HelperBootstrapPackage(opts); review && pkg != "" {
+		return m.startReview([]string{pkg}, true)
+	}
+	return m.startHelperInstall()
+}
+
+// userField offers the login users of the target system, preselecting the
+// user that user-scoped tasks currently apply to.
+func (m Model) userField() formField {
+	current := m.installer.TargetUser()
+	field := formField{label: "User", value: current}
+	if users := m.installer.LoginUsers(); len(users) > 0 {
+		field.options = users
+		field.value = users[0]
+		for _, u := range users {
+			if u == current {
+				field.value = u
+			}
+		}
+	}
+	return field
+}
+
+// userForm picks the user that user-scoped tasks apply to.
+func (m Model) userForm() form {
+	return form{title: "Select User", fields: []formField{m.userField()}}
+}
+
+func (m Model) submitUserForm() (Model, tea.Cmd) {
+	user := m.form.value("User")
+	if user == "" {
+		m.form.err = "user must not be empty"
+		m.logsView = logsview.NewInfo(m.form.view())
+		return m, nil
+	}
+	m.installer = m.installer.WithUser(user)
+	refreshMenuTitles(m.installer)
+	return m.closeForm(fmt.Sprintf("User-scoped tasks now apply to %s.", user)), nil
+}
+
+// providersForm picks the provider of each selected virtual package and the
+// members of each selected package group, preselecting earlier choices.
+func (m Model) providersForm() form {
+	f := form{title: "Choose what to install for these items"}
+	for _, choice := range m.providerChoices {
+		current := scripts.ItemProviders(choice.Item)
+		field := formField{label: scripts.ItemID(choice.Item)}
+		if choice.Group {
+			if len(current) == 0 {
+				current = choice.Options
+			}
+			field.value = strings.Join(current, " ")
+			f.title += fmt.Sprintf("\n\n%s is a group of: %s", field.label, strings.Join(choice.Options, " "))
+		} else {
+			field.options = choice.Options
+			field.value = choice.Options[0]
+			if len(current) == 1 && slices.Contains(choice.Options, current[0]) {
+				field.value = current[0]
+			}
+		}
+		f.fields = append(f.fields, field)
+	}
+	return f
+}
+
+func (m Model) submitProvidersForm() (Model, tea.Cmd) {
+	m.form.err = ""
+	chosen := make(map[string][]string)
+	for _, choice := range m.providerChoices {
+		id := scripts.ItemID(choice.Item)
+		providers := strings.Fields(m.form.value(id))
+		if len(providers) == 0 {
+			m.form.err = fmt.Sprintf("choose at least one package for %s", id)
+		}
+		for _, p := range providers {
+			if !slices.Contains(choice.Options, p) {
+				m.form.err = fmt.Sprintf("%s is not a member of %s", p, id)
+			}
+		}
+		if m.form.err != "" {
+			m.logsView = logsview.NewInfo(m.form.view())
+			return m, nil
+		}
+		chosen[choice.Item] = providers
+	}
+	for i, name := range m.itemNames {
+		if providers, ok := chosen[name]; ok {
+			m.itemNames[i] = scripts.WithProviders(name, providers)
+		}
+	}
+	m.providerChoices = nil
+	return m.closeForm("").showConfirm(), nil
+}
+
+// conflictsForm asks how to resolve each conflict: which of two conflicting
+// selected items to install, or whether to skip an item or remove the
+// installed package it conflicts with.
+func (m Model) conflictsForm() form {
+	f := form{title: "Resolve conflicts before installing:\n"}
+	for _, c := range m.conflicts {
+		item, with := scripts.ItemID(c.Item), scripts.ItemID(c.With)
+		f.title += "\n  • " + c.Reason
+		field := formField{
+			label:   fmt.Sprintf("%s / %s", item, with),
+			options: []string{"keep item", "keep other"},
+			optionLabels: map[string]string{
+				"keep item":  "install " + item,
+				"keep other": "install " + with,
+			},
+		}
+		if c.Installed {
+			field.label = fmt.Sprintf("%s / installed %s", item, with)
+			field.options = []string{"skip", "replace"}
+			field.optionLabels = map[string]string{
+				"skip":    "skip " + item,
+				"replace": fmt.Sprintf("install %s in place of %s", item, with),
+			}
+		}
+		field.value = field.options[0]
+		f.fields = append(f.fields, field)
+	}
+	return f
+}
+
+func (m Model) submitConflictsForm() (Model, tea.Cmd) {
+	deselect := make(map[string]bool)
+	replace := make(map[string][]string)
+	for i, c := range m.conflicts {
+		switch m.form.fields[i].value {
+		case "keep item":
+			deselect[c.With] = true
+		case "keep other", "skip":
+			deselect[c.Item] = true
+		case "replace":
+			replace[c.Item] = append(replace[c.Item], c.With)
+		}
+	}
+	for i, name := range m.itemNames {
+		if deselect[name] {
+			delete(m.selectedItems, i)
+			continue
+		}
+		for _, pkg := range replace[name] {
+			m.itemNames[i] = scripts.WithReplaced(m.itemNames[i], pkg)
+		}
+	}
+	m.conflicts = nil
+	m = m.closeForm("")
+	if len(m.selectedItems) == 0 {
+		m.currentStage = stageItems
+		m.logsView = logsview.NewInfo("Nothing to install: every selected item was skipped.")
+		return m, nil
+	}
+	return m.showConfirm(), nil
+}
@@ -0,0 +1,97 @@
+package listview
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/hardware"
+)
+
+var hardwareFlagStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("214"))
+
+// unmetCondition returns why the conditions do not hold on the detected
+// hardware, e.g. "no battery detected", or "" when they all do. hide is set
+// when an unmet condition hides what it is on.
+func unmetCondition(hw hardware.Info, conditions []config.Condition) (reason string, hide bool) {
+	for _, c := range conditions {
+		has, known := hw.Has(c.Feature, c.Value)
+		if known && has != c.Negate {
+			continue
+		}
+		hide = hide || c.Hide
+		if reason != "" {
+			continue
+		}
+		switch {
+		case !known:
+			reason = "unknown hardware condition " + c.String()
+		case c.Negate:
+			reason = hardware.Describe(c.Feature, c.Value) + " detected"
+		default:
+			reason = "no " + hardware.Describe(c.Feature, c.Value) + " detected"
+		}
+	}
+	return reason, hide
+}
+
+// hardwareNote explains how the detected hardware affects an item.
+func hardwareNote(hw hardware.Info, conditions []config.Condition) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	if reason, _ := unmetCondition(hw, conditions); reason != "" {
+		return "Hardware: " + reason + ", not preselected."
+	}
+	var met []string
+	for _, c := range conditions {
+		name := hardware.Describe(c.Feature, c.Value)
+		if c.Negate {
+			met = append(met, "no "+name)
+		} else {
+			met = append(met, name)
+		}
+	}
+	return "Hardware: " + strings.Join(met, ", ") + " detected."
+}
+
+// visibleCategories drops the categories the hardware hides and flags the
+// names of the ones it does not recommend.
+func visibleCategories(hw hardware.Info, categories []config.Category) ([]config.Category, []string) {
+	var visible []config.Category
+	var names []string
+	for _, category := range categories {
+		reason, hide := unmetCondition(hw, category.Conditions)
+		if hide {
+			continue
+		}
+		name := category.Name
+		if reason != "" {
+			name += " (" + reason + ")"
+		}
+		visible = append(visible, category)
+		names = append(names, name)
+	}
+	return visible, names
+}
+
+// hardwareItems returns the items of a category the hardware does not hide,
+// with the category's conditions added to each, and their indices in the
+// category.
+func hardwareItems(hw hardware.Info, category config.Category) ([]config.Item, []int) {
+	var items []config.Item
+	var origins []int
+	for i, item := range category.Items {
+		if len(category.Conditions) > 0 {
+			item.Conditions = append(slices.Clone(category.Conditions), item.Conditions...)
+		}
+		if _, hide := unmetCondition(hw, item.Conditions); hide {
+			continue
+		}
+		items = append(items, item)
+		origins = append(origins, i)
+	}
+	return items, origins
+}
@@ -0,0 +1,203 @@
+package listview
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/history"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+// historyLimit is the number of most recent history entries listed.
+const historyLimit = 200
+
+// historyLoaded carries the pacman history, newest first.
+type historyLoaded struct {
+	entries []history.Entry
+	err     error
+}
+
+// undoPlanned carries the plan undoing the history entry at index. refresh is
+// set when the plan is made again after an undo run.
+type undoPlanned struct {
+	index   int
+	plan    scripts.UndoPlan
+	refresh bool
+}
+
+func loadHistory(installer scripts.Installer) tea.Cmd {
+	return func() tea.Msg {
+		entries, err := installer.History()
+		return historyLoaded{entries: entries, err: err}
+	}
+}
+
+// startHistory reads the pacman log before listing its transactions.
+func (m Model) startHistory() (Model, tea.Cmd) {
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo("Reading the pacman log...")
+	return m, loadHistory(m.installer)
+}
+
+func (m Model) handleHistoryLoaded(msg historyLoaded) Model {
+	if m.currentStage != stageMenu || m.logsView.IsActive() {
+		return m
+	}
+	m.logsVisible = true
+	if msg.err != nil {
+		m.logsView = logsview.NewInfo(fmt.Sprintf("Error: %v", msg.err))
+		return m
+	}
+	if len(msg.entries) == 0 {
+		m.logsView = logsview.NewInfo("No package transactions found in " + history.LogPath() + ".")
+		return m
+	}
+	entries := msg.entries
+	if len(entries) > historyLimit {
+		entries = entries[:historyLimit]
+	}
+	m.maintenance = false
+	m.directory = ""
+	m.historyEntries = entries
+	m.categories = nil
+	m.categoryNames = nil
+	for _, entry := range entries {
+		m.categories = append(m.categories, config.Category{Name: entry.Title()})
+		m.categoryNames = append(m.categoryNames, entry.Title())
+	}
+	m.cursor = 0
+	m.currentStage = stageCategory
+	return m.showInformation()
+}
+
+// historyDetails lists the package changes of an entry by action.
+func (m Model) historyDetails(entry history.Entry) string {
+	var s string
+	if entry.Session != nil {
+		s = fmt.Sprintf("archutils %s run, %d transaction(s)\n", entry.Session.Source, len(entry.Transactions))
+		if entry.Session.Snapshot != nil {
+			s += fmt.Sprintf("Snapshot before the run: %s\n", entry.Session.Snapshot)
+		}
+	} else {
+		s = entry.Transactions[0].Command + "\n"
+	}
+
+	var lines []string
+	for _, action := range []string{history.Installed, history.Upgraded, history.Downgraded, history.Reinstalled, history.Removed} {
+		var changes []string
+		for _, c := range entry.Changes() {
+			if c.Action == action {
+				changes = append(changes, "  "+c.String())
+			}
+		}
+		if len(changes) > 0 {
+			lines = append(lines, "", strings.ToUpper(action[:1])+action[1:]+":")
+			lines = append(lines, changes...)
+		}
+	}
+	if page := m.reviewPageSize(); len(lines) > page {
+		more := len(lines) - page
+		lines = append(lines[:page], scrollDownStyle.Render(fmt.Sprintf("▼ %d more", more)))
+	}
+	s += strings.Join(lines, "\n")
+	if entry.Session != nil && len(entry.NewlyInstalled()) > 0 {
+		s += "\n\n  enter: Undo this session"
+	}
+	return s
+}
+
+func (m Model) handleHistoryEnter() (Model, tea.Cmd) {
+	if m.cursor >= len(m.historyEntries) {
+		return m, nil
+	}
+	entry := m.historyEntries[m.cursor]
+	m.logsVisible = true
+	if entry.Session == nil {
+		m.logsView = logsview.NewInfo("Only archutils sessions can be undone.\n\n" + m.historyDetails(entry))
+		return m, nil
+	}
+	if len(entry.NewlyInstalled()) == 0 {
+		m.logsView = logsview.NewInfo("This session installed no new packages, nothing to undo.\n\n" + m.historyDetails(entry))
+		return m, nil
+	}
+	m.logsView = logsview.NewInfo("Checking reverse dependencies...")
+	return m, planUndo(m.installer, m.cursor, entry, false)
+}
+
+func planUndo(installer scripts.Installer, index int, entry history.Entry, refresh bool) tea.Cmd {
+	return func() tea.Msg {
+		return undoPlanned{index: index, plan: installer.PlanUndo(entry.NewlyInstalled()), refresh: refresh}
+	}
+}
+
+func (m Model) handleUndoPlanned(msg undoPlanned) Model {
+	stage := stageCategory
+	if msg.refresh {
+		stage = stageItems
+	}
+	if m.historyEntries == nil || msg.index >= len(m.historyEntries) || m.currentStage != stage || m.logsView.IsActive() {
+		return m
+	}
+	entry := m.historyEntries[msg.index]
+	info := ""
+	if msg.refresh {
+		info = strings.TrimRight(m.resultsSummary, "\n")
+		if info != "" {
+			info += "\n\n"
+		}
+	}
+	m.logsVisible = true
+	if len(msg.plan.Remove) == 0 {
+		m.currentStage = stageCategory
+		m.cursor = msg.index
+		m.itemNames = nil
+		m.selectedItems = make(map[int]struct{})
+		info += "Nothing left to undo: the packages this session installed were removed already"
+		if len(msg.plan.Kept) > 0 {
+			info += " or are required by other packages:\n\n" + keptList(msg.plan.Kept)
+		} else {
+			info += "."
+		}
+		m.logsView = logsview.NewInfo(info)
+		return m
+	}
+
+	m.historyIndex = msg.index
+	m.undoPlan = msg.plan
+	m.directory = config.SourceDir(scripts.SourceUndo)
+	m.selectedCategory = config.Category{Name: "Undo " + entry.Title()}
+	m.itemNames = nil
+	m.selectedItems = make(map[int]struct{})
+	for i, pkg := range msg.plan.Remove {
+		m.selectedCategory.Items = append(m.selectedCategory.Items, config.Item{
+			Name:        pkg,
+			Description: "Installed by the archutils session of " + entry.Time().Local().Format("2006-01-02 15:04"),
+		})
+		m.itemNames = append(m.itemNames, pkg)
+		m.selectedItems[i] = struct{}{}
+	}
+	m.installedItems = make(map[int]bool)
+	m.cursor = 0
+	m.currentStage = stageItems
+	m.searchMode = false
+	m.searchQuery = ""
+	if info != "" {
+		m.logsView = logsview.NewInfo(strings.TrimRight(info, "\n"))
+		return m
+	}
+	return m.showInformation()
+}
+
+// keptList lists the packages an undo keeps with the packages requiring them.
+func keptList(kept map[string][]string) string {
+	var s string
+	for _, pkg := range slices.Sorted(maps.Keys(kept)) {
+		s += fmt.Sprintf("  • %s (required by %s)\n", pkg, strings.Join(kept[pkg], ", "))
+	}
+	return s
+}
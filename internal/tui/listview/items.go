@@ -6,6 +6,8 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/scripts"
 	"github.com/fcarp10/archutils/internal/tui/logsview"
 )
 
@@ -29,6 +31,10 @@ func (m Model) viewItems() string {
 
 			if m.installedItems[origIdx] {
 				displayChoice = installedItemStyle.Render(displayChoice + " ✓")
+			} else if origIdx < len(m.selectedCategory.Items) {
+				if reason, _ := unmetCondition(m.hardware, m.selectedCategory.Items[origIdx].Conditions); reason != "" {
+					displayChoice += hardwareFlagStyle.Render(" (" + reason + ")")
+				}
 			}
 
 			if m.cursor == displayIdx {
@@ -65,23 +71,180 @@ func (m Model) handleInstall() (Model, tea.Cmd) {
 	}
 
 	m.currentStage = stageConfirm
+	if config.SourceKind(m.directory) == scripts.SourceConfigs {
+		return m.openForm(formConfigActions, m.configActionsForm()), nil
+	}
+	if m.directory == config.PkgsDir() {
+		m.providerChoices = m.installer.ProviderChoices(m.selectedPackages())
+		if len(m.providerChoices) > 0 {
+			return m.openForm(formProviders, m.providersForm()), nil
+		}
+	}
+	return m.showConfirm(), nil
+}
+
+// showConfirm shows the confirm message, or the conflicts among the selected
+// packages and with installed ones when there are any to resolve first.
+func (m Model) showConfirm() Model {
+	if m.directory == config.PkgsDir() {
+		selected := m.selectedPackages()
+		blocked := m.blockedItems(selected)
+		var items []string
+		for _, name := range selected {
+			if _, ok := blocked[name]; !ok {
+				items = append(items, name)
+			}
+		}
+		m.conflicts = m.installer.Conflicts(items)
+		if len(m.conflicts) > 0 {
+			return m.openForm(formConflicts, m.conflictsForm())
+		}
+	}
 	m.logsVisible = true
+	m.logsView = logsview.NewInfo(m.confirmMessage())
+	return m
+}
+
+// selectedItemNames returns the names of the selected items in list order.
+func (m Model) selectedItemNames() []string {
+	var names []string
+	for idx := range m.itemNames {
+		if _, ok := m.selectedItems[idx]; ok {
+			names = append(names, m.itemNames[idx])
+		}
+	}
+	return names
+}
+
+// selectedPackages returns the selected items of a packages category that
+// install as packages, leaving out the ones routed to another source.
+func (m Model) selectedPackages() []string {
+	var packages []string
+	for _, name := range m.selectedItemNames() {
+		if kind, _ := config.ItemSource(name, scripts.SourcePackages); kind == scripts.SourcePackages {
+			packages = append(packages, name)
+		}
+	}
+	return packages
+}
 
-	var selectedList []string
-	for idx := range m.selectedItems {
-		if idx < len(m.itemNames) {
-			selectedList = append(selectedList, m.itemNames[idx])
+// reviewableItems returns the selected AUR packages that can be built, i.e.
+// the ones whose PKGBUILDs can be reviewed before installing.
+func (m Model) reviewableItems() []string {
+	if m.directory != config.PkgsDir() {
+		return nil
+	}
+	selected := m.selectedPackages()
+	blocked := m.blockedItems(selected)
+	var items []string
+	for _, name := range selected {
+		if _, ok := blocked[name]; ok {
+			continue
+		}
+		if !m.installer.IsRepoPackage(name) {
+			items = append(items, name)
 		}
 	}
+	return items
+}
 
-	confirmMsg := fmt.Sprintf("Confirm installation of %d item(s):\n\n", len(selectedList))
+// confirmMessage lists the selection for the confirm stage, including AUR
+// items that will be skipped because no AUR helper is installed.
+func (m Model) confirmMessage() string {
+	selectedList := m.selectedItemNames()
+	blocked := m.blockedItems(selectedList)
+	title := "Confirm installation of %d item(s):"
+	if task, ok := maintenanceTaskOf(config.SourceKind(m.directory)); ok && m.maintenance {
+		title = task.confirm
+	}
+	undo := config.SourceKind(m.directory) == scripts.SourceUndo
+	if undo {
+		title = "Confirm undo: remove %d package(s) the session installed:"
+	}
+	confirmMsg := fmt.Sprintf(title+"\n\n", len(selectedList)-len(blocked))
 	for _, name := range selectedList {
+		if _, ok := blocked[name]; ok {
+			continue
+		}
 		confirmMsg += "  • " + name + "\n"
 	}
+	if undo && len(m.undoPlan.Kept) > 0 {
+		confirmMsg += "\nKept, still required by other packages:\n\n" + keptList(m.undoPlan.Kept)
+	}
+	if len(blocked) > 0 {
+		_, helperMsg := m.installer.CheckHelperInstalled()
+		confirmMsg += fmt.Sprintf("\nSkipping %d AUR item(s):\n\n", len(blocked))
+		for _, name := range selectedList {
+			if _, ok := blocked[name]; ok {
+				confirmMsg += "  • " + name + "\n"
+			}
+		}
+		confirmMsg += "\n" + helperMsg + "\n"
+	}
 	confirmMsg += "\n  y: Confirm   n: Cancel"
+	if len(m.reviewableItems()) > 0 {
+		confirmMsg += "   r: Review AUR PKGBUILDs"
+	}
+	return confirmMsg
+}
 
-	m.logsView = logsview.NewInfo(confirmMsg)
-	return m, nil
+// blockedItems returns the selected AUR packages that cannot be installed
+// because no AUR helper is installed. Repo packages still go through pacman.
+func (m Model) blockedItems(items []string) map[string]struct{} {
+	blocked := make(map[string]struct{})
+	if m.directory != config.PkgsDir() {
+		return blocked
+	}
+	if installed, _ := m.installer.CheckHelperInstalled(); installed {
+		return blocked
+	}
+	for _, item := range items {
+		if kind, _ := config.ItemSource(item, scripts.SourcePackages); kind == scripts.SourcePackages && !m.installer.IsRepoPackage(item) {
+			blocked[item] = struct{}{}
+		}
+	}
+	return blocked
+}
+
+// showOptionalDeps replaces the item list with the optional dependencies of
+// the packages just installed. None are preselected; the chosen ones are
+// installed as dependencies.
+func (m Model) showOptionalDeps(deps logsview.OptionalDepsFound) Model {
+	if len(deps) == 0 || m.currentStage != stageItems || m.logsView.IsActive() {
+		return m
+	}
+	info := strings.TrimRight(m.resultsSummary, "\n")
+	if info != "" {
+		info += "\n\n"
+	}
+	info += "Optional dependencies of the installed packages:\n\n"
+
+	category := config.Category{Name: "Optional dependencies", Key: "optdepends"}
+	names := make([]string, len(deps))
+	for i, dep := range deps {
+		names[i] = scripts.AsDependency(dep.Name)
+		description := "Optional dependency of " + dep.For
+		if dep.Reason != "" {
+			description += ": " + dep.Reason
+		}
+		category.Items = append(category.Items, config.Item{Name: names[i], Description: description})
+		info += fmt.Sprintf("  • %s (%s)", dep.Name, dep.For)
+		if dep.Reason != "" {
+			info += ": " + dep.Reason
+		}
+		info += "\n"
+	}
+	info += "\nSelect the ones to install with space/enter and press i to install them as dependencies."
+
+	m.selectedCategory = category
+	m.itemNames = names
+	m.selectedItems = make(map[int]struct{})
+	m.installedItems = make(map[int]bool)
+	m.directory = config.PkgsDir()
+	m.cursor = 0
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo(info)
+	return m
 }
 
 func (m Model) handleSelectAll() Model {
@@ -2,13 +2,17 @@ package listview
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/hardware"
+	"github.com/fcarp10/archutils/internal/history"
 	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/session"
 	helpkeys "github.com/fcarp10/archutils/internal/tui/helpkeys"
 	"github.com/fcarp10/archutils/internal/tui/logsview"
 )
@@ -19,18 +23,30 @@ const (
 	stageCategory
 	stageItems
 	stageConfirm
+	stagePreflight
 	stageInstalling
+	stageReview
 )
 
 // Menu option indices.
 const (
 	menuPackages = iota
-	menuInstallParu
+	menuSystemUpgrade
+	menuMaintenance
+	menuHistory
+	menuInstallHelper
 	menuVSCodeExtensions
+	menuFlatpak
+	menuPipx
+	menuCargo
+	menuNpm
+	menuGo
 	menuAutologin
+	menuDisableAutologin
 	menuPasswordlessSSH
-	menuPasswordlessSudo
+	menuPasswordlessPrivilege
 	menuAddUserToWheel
+	menuSelectUser
 )
 
 // Minimum terminal dimensions for usable layout.
@@ -71,47 +87,94 @@ var (
 
 // Model is the main list view model that manages all UI stages.
 type Model struct {
-	width                int
-	height               int
-	logsView             logsview.Model
-	categories           []config.Category
-	categoryNames        []string
-	selectedCategory     config.Category
-	cursor               int
-	currentStage         int
-	selectedItems        map[int]struct{}
-	itemNames            []string
-	installedItems       map[int]bool
-	logsVisible          bool
-	directory            string
-	installer            scripts.Installer
-	searchMode           bool
-	searchQuery          string
-	paruReinstallConfirm bool
+	width            int
+	height           int
+	logsView         logsview.Model
+	categories       []config.Category
+	categoryNames    []string
+	selectedCategory config.Category
+	cursor           int
+	currentStage     int
+	selectedItems    map[int]struct{}
+	itemNames        []string
+	installedItems   map[int]bool
+	logsVisible      bool
+	directory        string
+	installer        scripts.Installer
+	searchMode       bool
+	searchQuery      string
+	helperBootstrap  scripts.BootstrapOptions
+	helperStartStep  int
+	helperFailure    *logsview.HelperInstallFailed
+	formKind         int
+	form             form
+	resumeConfirm    bool
+	resumeSession    session.State
+	upgradeConfirm   bool
+	pendingUpgrades  []scripts.PackageUpdate
+	// upgradesUnsynced is set when pendingUpgrades were listed from sync
+	// databases that were not refreshed.
+	upgradesUnsynced bool
+	// snapshotOffer is what a snapshot is offered for, and snapshotTool the
+	// tool that would take it.
+	snapshotOffer int
+	snapshotTool  string
+	review        reviewState
+	// providerChoices are the selected items waiting for a provider choice.
+	providerChoices []scripts.ProviderChoice
+	// conflicts are the conflicts of the selection waiting to be resolved.
+	conflicts []scripts.Conflict
+	// pendingInstall are the confirmed items waiting for the pre-flight checks.
+	pendingInstall []string
+	// pendingResume is the session pendingInstall resumes, if any.
+	pendingResume *session.State
+	preflight     []scripts.CheckResult
+	// resultsSummary is the summary of the last install run.
+	resultsSummary string
+	// maintenance is set while the categories are the maintenance tasks.
+	maintenance    bool
+	cacheRetention scripts.CacheRetention
+	// configFiles are the config files found by the configs task by path.
+	configFiles map[string]scripts.ConfigFile
+	// pendingMerges are the config files still to merge in the diff program.
+	pendingMerges []scripts.ConfigFile
+	mergeErrors   []string
+	// historyEntries are set while the categories are the pacman history.
+	historyEntries []history.Entry
+	// historyIndex is the history entry being undone and undoPlan its plan.
+	historyIndex int
+	undoPlan     scripts.UndoPlan
+	// hardware decides which items are preselected, flagged or hidden.
+	hardware hardware.Info
 }
 
 // New creates a new Model starting at the main menu.
 func New(installer scripts.Installer) Model {
-	menuItemsTitles = make([]string, len(menuItems))
-	for i, item := range menuItems {
-		menuItemsTitles[i] = item.title
-	}
+	refreshMenuTitles(installer)
 	return Model{
 		cursor:         0,
 		currentStage:   stageMenu,
 		installer:      installer,
 		installedItems: make(map[int]bool),
+		cacheRetention: scripts.CacheRetention{Keep: 3},
 	}
 }
 
+// WithHardware sets the detected hardware, which decides the items that are
+// preselected, flagged or hidden.
+func (m Model) WithHardware(hw hardware.Info) Model {
+	m.hardware = hw
+	return m
+}
+
 func (m Model) Init() tea.Cmd {
-	return m.logsView.Init()
+	return tea.Batch(m.logsView.Init(), loadSession)
 }
 
 // SelectionCount returns the number of selected items and total items,
 // or (-1, -1) if the current stage does not show selections.
 func (m Model) SelectionCount() (selected, total int) {
-	if m.currentStage != stageItems && m.currentStage != stageConfirm && m.currentStage != stageInstalling {
+	if m.currentStage != stageItems && m.currentStage != stageConfirm && m.currentStage != stagePreflight && m.currentStage != stageInstalling && m.currentStage != stageReview {
 		return -1, -1
 	}
 	return len(m.selectedItems), len(m.itemNames)
@@ -128,19 +191,32 @@ func (m Model) getFilteredIndices() []int {
 	query := strings.ToLower(m.searchQuery)
 	var indices []int
 	for i, name := range m.itemNames {
-		if strings.Contains(strings.ToLower(name), query) {
+		if strings.Contains(strings.ToLower(name), query) || m.tagMatches(i, query) {
 			indices = append(indices, i)
 		}
 	}
 	return indices
 }
 
-func initCategories(dir string) ([]config.Category, []string, error) {
+// tagMatches reports whether a tag of the item at index i contains query.
+func (m Model) tagMatches(i int, query string) bool {
+	if i >= len(m.selectedCategory.Items) {
+		return false
+	}
+	return slices.ContainsFunc(m.selectedCategory.Items[i].Tags, func(tag string) bool {
+		return strings.Contains(strings.ToLower(tag), query)
+	})
+}
+
+// initCategories reads the categories of dir that the hardware does not
+// hide, see visibleCategories.
+func initCategories(dir string, hw hardware.Info) ([]config.Category, []string, error) {
 	categories, err := config.ReadCategories(dir)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read config directory %s: %w", dir, err)
 	}
-	return categories, config.CategoryNames(categories), nil
+	categories, names := visibleCategories(hw, categories)
+	return categories, names, nil
 }
 
 func initializeSelection(items []string) ([]string, map[int]struct{}) {
@@ -162,19 +238,57 @@ func (m Model) showInformation() Model {
 		return m
 	}
 	m.logsVisible = true
+	if m.resumeConfirm {
+		m.logsView = logsview.NewInfo(m.resumePrompt())
+		return m
+	}
+	if m.snapshotOffer != snapshotNone {
+		m.logsView = logsview.NewInfo(m.snapshotPrompt())
+		return m
+	}
+	if m.upgradeConfirm {
+		m.logsView = logsview.NewInfo(m.upgradePrompt())
+		return m
+	}
+	if m.reviewActive() {
+		m.logsView = logsview.NewInfo(m.reviewView())
+		return m
+	}
+	if m.formKind != formNone {
+		m.logsView = logsview.NewInfo(m.form.view())
+		return m
+	}
 	switch m.currentStage {
 	case stageMenu:
 		m.logsView = logsview.NewInfo(menuItems[m.cursor].description)
+	case stageCategory:
+		if m.historyEntries != nil && m.cursor < len(m.historyEntries) {
+			m.logsView = logsview.NewInfo(m.historyDetails(m.historyEntries[m.cursor]))
+			break
+		}
+		if !m.maintenance && m.cursor < len(m.categories) && m.categories[m.cursor].Description != "" {
+			m.logsView = logsview.NewInfo(m.categories[m.cursor].Description)
+			break
+		}
+		if !m.maintenance || m.cursor >= len(maintenanceTasks) {
+			m.logsVisible = false
+			break
+		}
+		m.logsView = logsview.NewInfo(maintenanceTasks[m.cursor].description)
 	case stageItems:
 		indices := m.getFilteredIndices()
 		if len(indices) == 0 {
 			m.logsView = logsview.NewInfo("No matching items")
 		} else if m.cursor < len(indices) {
 			origIdx := indices[m.cursor]
-			description := m.selectedCategory.Items[origIdx].Description
+			item := m.selectedCategory.Items[origIdx]
+			description := item.Description
 			if description == "" {
 				description = "No information available for this item"
 			}
+			if note := hardwareNote(m.hardware, item.Conditions); note != "" {
+				description += "\n\n" + note
+			}
 			m.logsView = logsview.NewInfo(description)
 		}
 	case stageConfirm:
@@ -223,22 +337,39 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		if m.paruReinstallConfirm {
+		if m.formKind != formNone {
+			return m.handleFormInput(msg)
+		}
+
+		if m.resumeConfirm {
 			switch {
 			case key.Matches(msg, helpkeys.Keys.ConfirmYes):
-				m.paruReinstallConfirm = false
-				m, cmd = m.startParuInstall()
+				m, cmd = m.handleResumeYes()
 				cmds = append(cmds, cmd)
 			case key.Matches(msg, helpkeys.Keys.ConfirmNo), key.Matches(msg, helpkeys.Keys.Back):
-				m.paruReinstallConfirm = false
-				m.logsVisible = true
-				m.logsView = logsview.NewInfo("Paru reinstall cancelled.")
+				m = m.handleResumeNo()
 			case key.Matches(msg, helpkeys.Keys.Quit):
 				return m, tea.Quit
 			}
 			return m, tea.Batch(cmds...)
 		}
 
+		if m.snapshotOffer != snapshotNone {
+			return m.handleSnapshotInput(msg)
+		}
+
+		if m.upgradeConfirm {
+			return m.handleUpgradeInput(msg)
+		}
+
+		if m.reviewActive() {
+			return m.handleReviewInput(msg)
+		}
+
+		if m.currentStage == stagePreflight {
+			return m.handlePreflightInput(msg)
+		}
+
 		if m.currentStage == stageConfirm {
 			switch {
 			case key.Matches(msg, helpkeys.Keys.ConfirmYes):
@@ -246,6 +377,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				cmds = append(cmds, cmd)
 			case key.Matches(msg, helpkeys.Keys.ConfirmNo), key.Matches(msg, helpkeys.Keys.Back):
 				m = m.handleConfirmNo()
+			case key.Matches(msg, helpkeys.Keys.Review):
+				if items := m.reviewableItems(); len(items) > 0 {
+					m, cmd = m.startReview(items, false)
+					cmds = append(cmds, cmd)
+				}
 			}
 			return m, tea.Batch(cmds...)
 		}
@@ -331,12 +467,47 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.currentStage = stageItems
 		m.searchMode = false
 		m.searchQuery = ""
+		m.resultsSummary = string(msg)
 		if string(msg) != "" {
 			m.logsVisible = true
 			m.logsView = logsview.NewInfo(string(msg))
 		} else {
 			m.logsVisible = false
 		}
+		if m.maintenance {
+			// The run changed what the task finds, e.g. removed orphans.
+			m, cmd = m.findMaintenanceItems(true)
+			cmds = append(cmds, cmd)
+		}
+		if m.historyEntries != nil && config.SourceKind(m.directory) == scripts.SourceUndo {
+			cmds = append(cmds, planUndo(m.installer, m.historyIndex, m.historyEntries[m.historyIndex], true))
+		}
+	case historyLoaded:
+		m = m.handleHistoryLoaded(msg)
+	case undoPlanned:
+		m = m.handleUndoPlanned(msg)
+	case maintenanceFound:
+		m = m.handleMaintenanceFound(msg)
+	case configMerged:
+		return m.handleConfigMerged(msg)
+	case upgradesChecked:
+		m = m.handleUpgradesChecked(msg)
+	case preflightDone:
+		return m.handlePreflightDone(msg)
+	case logsview.OptionalDepsFound:
+		m = m.showOptionalDeps(msg)
+	case buildFilesFetched:
+		m = m.handleBuildFilesFetched(msg)
+	case logsview.HelperInstallFailed:
+		m.helperFailure = &msg
+	case logsview.HelperInstalled:
+		m.helperFailure = nil
+	case sessionFound:
+		if m.currentStage == stageMenu && !m.logsView.IsActive() {
+			m.resumeConfirm = true
+			m.resumeSession = session.State(msg)
+			m = m.showInformation()
+		}
 	case tea.WindowSizeMsg:
 		m.logsVisible = true
 		m = m.showInformation()
@@ -350,12 +521,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
-// startParuInstall initialises and runs the paru installation scripts.
-func (m Model) startParuInstall() (Model, tea.Cmd) {
+// startHelperInstall initialises and runs the AUR helper installation scripts.
+func (m Model) startHelperInstall() (Model, tea.Cmd) {
 	m.logsVisible = true
-	m.logsView = logsview.NewScript(m.installer)
+	m.logsView = logsview.NewScript(m.installer).WithHelperBootstrap(m.helperBootstrap, m.helperStartStep)
 	var cmd tea.Cmd
-	m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptParu))
+	m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptAURHelper))
 	return m, cmd
 }
 
@@ -417,7 +588,7 @@ func (m Model) View() string {
 		list = m.viewCategory()
 	case stageItems:
 		list = m.viewItems()
-	case stageConfirm, stageInstalling:
+	case stageConfirm, stagePreflight, stageInstalling, stageReview:
 		list = m.viewConfirmInstalling()
 	}
 
@@ -430,18 +601,7 @@ func (m Model) View() string {
 
 	var s string
 	if m.logsVisible && m.width > 0 {
-		// Left pane: capped at maxListWidth, right pane: remaining space
-		listWidth := m.width*60/100 - 4
-		if listWidth > maxListWidth {
-			listWidth = maxListWidth
-		}
-		logsWidth := m.width - listWidth - 8 // subtract both panes' border/padding
-		if listWidth < 26 {
-			listWidth = 26
-		}
-		if logsWidth < 16 {
-			logsWidth = 16
-		}
+		listWidth, logsWidth := m.paneWidths()
 		// Pad content to desired width first (without border), then add border
 		paddedList := lipgloss.NewStyle().Width(listWidth).Render(list)
 		paddedLogs := lipgloss.NewStyle().Width(logsWidth).Render(m.logsView.View())
@@ -458,3 +618,20 @@ func (m Model) View() string {
 	}
 	return s
 }
+
+// paneWidths returns the content widths of the left and right panes.
+func (m Model) paneWidths() (int, int) {
+	// Left pane: capped at maxListWidth, right pane: remaining space
+	listWidth := m.width*60/100 - 4
+	if listWidth > maxListWidth {
+		listWidth = maxListWidth
+	}
+	logsWidth := m.width - listWidth - 8 // subtract both panes' border/padding
+	if listWidth < 26 {
+		listWidth = 26
+	}
+	if logsWidth < 16 {
+		logsWidth = 16
+	}
+	return listWidth, logsWidth
+}
@@ -1,51 +1,210 @@
 package listview
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/hardware"
+	"github.com/fcarp10/archutils/internal/history"
+	"github.com/fcarp10/archutils/internal/review"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/session"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "archutils-listview")
+	if err != nil {
+		panic(err)
+	}
+	session.SetPath(filepath.Join(dir, "session.json"))
+	review.SetDir(filepath.Join(dir, "reviews"))
+	// An empty root detects no hardware, whatever the machine running the tests.
+	hardware.SetRoot(dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 // mockInstaller implements scripts.Installer for use in tests.
 type mockInstaller struct {
-	installedPkgs    map[string]string
-	packageInstalled map[string]bool
-}
-
-func (m mockInstaller) InstallPackage(pkg string) (bool, string) {
-	return true, pkg + ": installed"
-}
-func (m mockInstaller) InstallVSCodeExtension(ext string) (bool, string) {
-	return true, ext + ": installed"
-}
-func (m mockInstaller) EnableAutologin() (bool, string)           { return true, "autologin enabled" }
-func (m mockInstaller) EnablePasswordlessSSH() (bool, string)     { return true, "ssh configured" }
-func (m mockInstaller) EnablePasswordlessSudo() (bool, string)    { return true, "sudo configured" }
-func (m mockInstaller) AddUserToWheel() (bool, string)            { return true, "user added to wheel" }
-func (m mockInstaller) WheelGroupCmd() *exec.Cmd                  { return exec.Command("true") }
-func (m mockInstaller) ParuStepCount() int                        { return 4 }
-func (m mockInstaller) ParuStepCmd(step int) *exec.Cmd            { return exec.Command("true") }
-func (m mockInstaller) GetPackageDescription(item string) string  { return "description of " + item }
-func (m mockInstaller) GetExtensionDescription(ext string) string { return "description of " + ext }
-func (m mockInstaller) CheckParuInstalled() (bool, string)        { return true, "" }
-func (m mockInstaller) IsPackageInstalled(pkg string) bool {
-	if m.packageInstalled == nil {
-		return false
-	}
-	return m.packageInstalled[pkg]
-}
-func (m mockInstaller) IsExtensionInstalled(ext string) bool { return false }
-func (m mockInstaller) SudoValidateCmd() *exec.Cmd {
-	return exec.Command("true")
+	installedItems map[string]string
+	loginUsers     []string
+	helperMissing  bool
+	repoPkgs       map[string]bool
+	user           string
+	providers      map[string]scripts.ProviderChoice
+	conflicts      []scripts.Conflict
+	preflight      []scripts.CheckResult
+	upgrades       []scripts.PackageUpdate
+	// unsynced lists the upgrades from databases that were not refreshed.
+	unsynced bool
+	orphans  []string
+	cached   []scripts.CachedPackage
+	configs  []scripts.ConfigFile
+	// files are the contents of the config files by path.
+	files    map[string]string
+	mergeErr bool
+	entries  []history.Entry
+	plan     scripts.UndoPlan
+	snapshot string
+}
+
+// mockSource implements scripts.Source; every kind shares the installed items.
+type mockSource struct {
+	kind      string
+	installed map[string]string
 }
-func (m mockInstaller) GetInstalledPackages() map[string]string {
-	if m.installedPkgs == nil {
+
+func (s mockSource) Kind() string         { return s.kind }
+func (s mockSource) NeedsPrivilege() bool { return s.kind == scripts.SourcePackages }
+func (s mockSource) Installed() map[string]string {
+	if s.installed == nil {
 		return map[string]string{}
 	}
-	return m.installedPkgs
+	return s.installed
+}
+func (s mockSource) Install(item string) (bool, string) { return true, item + ": installed" }
+func (s mockSource) Remove(item string) (bool, string)  { return true, item + ": removed" }
+func (s mockSource) Describe(item string) string        { return "description of " + item }
+
+func (m mockInstaller) Source(kind string) scripts.Source {
+	return mockSource{kind: kind, installed: m.installedItems}
+}
+func (m mockInstaller) EnableAutologin(opts scripts.AutologinOptions) (bool, string) {
+	return true, "autologin enabled"
+}
+func (m mockInstaller) DisableAutologin() (bool, string)            { return true, "autologin disabled" }
+func (m mockInstaller) LoginUsers() []string                        { return m.loginUsers }
+func (m mockInstaller) EnablePasswordlessSSH() (bool, string)       { return true, "ssh configured" }
+func (m mockInstaller) EnablePasswordlessPrivilege() (bool, string) { return true, "sudo configured" }
+func (m mockInstaller) AddUserToWheel() (bool, string)              { return true, "user added to wheel" }
+func (m mockInstaller) WheelGroupCmd() *exec.Cmd                    { return exec.Command("true") }
+func (m mockInstaller) AURHelperName() string                       { return "paru" }
+func (m mockInstaller) TargetUser() string                          { return m.user }
+func (m mockInstaller) WithUser(user string) scripts.Installer {
+	m.user = user
+	return m
+}
+func (m mockInstaller) HelperStrategies() []string {
+	return []string{scripts.BootstrapSource, scripts.BootstrapBinary, scripts.BootstrapLocalFile}
+}
+func (m mockInstaller) ValidateBootstrap(opts scripts.BootstrapOptions) error {
+	if opts.Strategy == scripts.BootstrapLocalFile && opts.Path == "" {
+		return errors.New("missing package file")
+	}
+	return nil
+}
+func (m mockInstaller) HelperBootstrapPackage(opts scripts.BootstrapOptions) string {
+	if opts.Strategy == scripts.BootstrapBinary {
+		return "paru-bin"
+	}
+	return "paru"
+}
+func (m mockInstaller) HelperStepNames(opts scripts.BootstrapOptions) []string {
+	return []string{"remove", "deps", "clone", "build", "install"}
+}
+func (m mockInstaller) HelperStepCmd(opts scripts.BootstrapOptions, step int) *exec.Cmd {
+	return exec.Command("true")
+}
+func (m mockInstaller) CheckHelperInstalled() (bool, string) {
+	if m.helperMissing {
+		return false, "paru is not installed"
+	}
+	return true, ""
+}
+func (m mockInstaller) IsRepoPackage(pkg string) bool { return m.repoPkgs[pkg] }
+func (m mockInstaller) OptionalDeps(pkg string) []scripts.OptionalDep {
+	return nil
+}
+
+// Conflicts returns the mock conflicts whose items are all in items; the
+// replaced packages of an item resolve its conflicts with installed ones.
+func (m mockInstaller) Conflicts(items []string) []scripts.Conflict {
+	var conflicts []scripts.Conflict
+	for _, c := range m.conflicts {
+		if slices.Contains(items, c.Item) && (c.Installed || slices.Contains(items, c.With)) {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+func (m mockInstaller) PreflightChecks(source string) []scripts.CheckResult {
+	return m.preflight
+}
+func (m mockInstaller) PendingUpgrades() ([]scripts.PackageUpdate, bool, error) {
+	return m.upgrades, !m.unsynced, nil
+}
+func (m mockInstaller) UpgradeCmd() *exec.Cmd { return exec.Command("true") }
+func (m mockInstaller) Orphans() ([]string, error) {
+	return m.orphans, nil
+}
+func (m mockInstaller) StaleCachedPackages(retention scripts.CacheRetention) ([]scripts.CachedPackage, error) {
+	return m.cached, nil
+}
+func (m mockInstaller) ConfigFiles() ([]scripts.ConfigFile, error) {
+	return m.configs, nil
+}
+func (m mockInstaller) ReadConfigFile(path string) (string, error) {
+	return m.files[path], nil
+}
+func (m mockInstaller) History() ([]history.Entry, error) {
+	return m.entries, nil
+}
+func (m mockInstaller) PlanUndo(pkgs []string) scripts.UndoPlan {
+	return m.plan
+}
+func (m mockInstaller) SnapshotTool() string { return m.snapshot }
+func (m mockInstaller) CreateSnapshot(tool, description string) (session.Snapshot, error) {
+	return session.Snapshot{Tool: tool, ID: "1"}, nil
+}
+func (m mockInstaller) MergeConfigCmd(file scripts.ConfigFile) *exec.Cmd {
+	if m.mergeErr {
+		return exec.Command("false")
+	}
+	return exec.Command("true")
+}
+func (m mockInstaller) ProviderChoices(items []string) []scripts.ProviderChoice {
+	var choices []scripts.ProviderChoice
+	for _, item := range items {
+		if choice, ok := m.providers[scripts.ItemID(item)]; ok {
+			choice.Item = item
+			choices = append(choices, choice)
+		}
+	}
+	return choices
+}
+
+// mockCommit is the AUR commit mockInstaller fetches build files at.
+const mockCommit = "0123456789abcdef0123456789abcdef01234567"
+
+func (m mockInstaller) FetchBuildFiles(pkg string) (map[string]string, string, error) {
+	return map[string]string{"PKGBUILD": "pkgname=" + scripts.ItemID(pkg) + "\n"}, mockCommit, nil
+}
+func (m mockInstaller) PrivilegeTool() string { return "sudo" }
+func (m mockInstaller) PrivilegeValidateCmd() *exec.Cmd {
+	return exec.Command("true")
+}
+func (m mockInstaller) RefreshPrivilege() error    { return nil }
+func (m mockInstaller) CheckPrivilegeCache() error { return nil }
+
+// passPreflight reports the pre-flight checks started by cmd back to m.
+func passPreflight(t *testing.T, m Model, cmd tea.Cmd) (Model, tea.Cmd) {
+	t.Helper()
+	if m.currentStage != stagePreflight || cmd == nil {
+		t.Fatalf("expected the pre-flight checks to run, stage %d", m.currentStage)
+	}
+	return m.handlePreflightDone(cmd().(preflightDone))
 }
 
 func TestNew(t *testing.T) {
@@ -152,6 +311,25 @@ func TestGetFilteredIndices_WithSearch(t *testing.T) {
 	}
 }
 
+func TestGetFilteredIndices_MatchesTags(t *testing.T) {
+	m := New(mockInstaller{})
+	m.itemNames = []string{"ripgrep", "bat", "zoxide"}
+	m.selectedCategory = config.Category{Items: []config.Item{
+		{Name: "ripgrep", Tags: []string{"search", "grep"}},
+		{Name: "bat", Tags: []string{"Pager"}},
+		{Name: "zoxide"},
+	}}
+
+	m.searchQuery = "pager"
+	if indices := m.getFilteredIndices(); len(indices) != 1 || indices[0] != 1 {
+		t.Errorf("expected the tagged item to match, got %v", indices)
+	}
+	m.searchQuery = "grep"
+	if indices := m.getFilteredIndices(); len(indices) != 1 || indices[0] != 0 {
+		t.Errorf("expected a single match for a name that is also a tag, got %v", indices)
+	}
+}
+
 func TestHandleSelectAll(t *testing.T) {
 	m := New(mockInstaller{})
 	m.currentStage = stageItems
@@ -235,6 +413,7 @@ func TestHandleConfirmYes(t *testing.T) {
 	m.selectedItems = map[int]struct{}{0: {}, 1: {}}
 
 	m, cmd := m.handleConfirmYes()
+	m, cmd = passPreflight(t, m, cmd)
 	if m.currentStage != stageInstalling {
 		t.Errorf("expected stageInstalling (%d), got %d", stageInstalling, m.currentStage)
 	}
@@ -331,6 +510,39 @@ func TestHandleCategoryEnter_Extensions(t *testing.T) {
 	}
 }
 
+func TestHandleCategoryEnter_Flatpaks(t *testing.T) {
+	m := New(mockInstaller{installedItems: map[string]string{"org.videolan.VLC": "description of org.videolan.VLC"}})
+	m.cursor = menuFlatpak
+	m, _ = m.handleMenuEnter()
+	if m.directory != config.FlatpakDir() {
+		t.Fatalf("expected the flatpak directory, got %q", m.directory)
+	}
+
+	m.currentStage = stageCategory
+	m.categories = []config.Category{
+		{Name: "Media", Key: "media", Items: []config.Item{{Name: "org.videolan.VLC"}, {Name: "# com.spotify.Client [user]"}}},
+	}
+	m.cursor = 0
+	m, _ = m.handleCategoryEnter()
+	if !m.installedItems[0] || m.installedItems[1] {
+		t.Errorf("unexpected installed state %v", m.installedItems)
+	}
+	if got := m.categories[0].Items[0].Description; got != "description of org.videolan.VLC" {
+		t.Errorf("expected the flatpak description, got %q", got)
+	}
+
+	m.selectedItems = map[int]struct{}{1: {}}
+	m.currentStage = stageConfirm
+	m, cmd := m.handleConfirmYes()
+	m, cmd = passPreflight(t, m, cmd)
+	if m.currentStage != stageInstalling || cmd == nil {
+		t.Errorf("expected the flatpak install to start, stage %d", m.currentStage)
+	}
+	if got := directoryForInstallType(logsview.InstallFlatpaks); got != config.FlatpakDir() {
+		t.Errorf("expected resumed flatpak sessions to map to %q, got %q", config.FlatpakDir(), got)
+	}
+}
+
 func TestCursorNavigation_Menu(t *testing.T) {
 	m := New(mockInstaller{})
 	// Down
@@ -384,3 +596,784 @@ func TestStyles(t *testing.T) {
 	_ = noMatchStyle
 	_ = lipgloss.NewStyle
 }
+
+func TestAutologinForm(t *testing.T) {
+	m := New(mockInstaller{loginUsers: []string{"alice", "bob"}})
+	m.cursor = menuAutologin
+
+	m, _ = m.handleMenuEnter()
+	if m.formKind != formAutologin {
+		t.Fatalf("expected autologin form to be open, got %d", m.formKind)
+	}
+
+	// Move to the user field and pick the other user.
+	if user := m.form.value("User"); user != "alice" {
+		t.Fatalf("expected the first login user to be preselected, got %q", user)
+	}
+	m, _ = m.handleFormInput(tea.KeyMsg{Type: tea.KeyTab})
+	m, _ = m.handleFormInput(tea.KeyMsg{Type: tea.KeyRight})
+	if user := m.form.value("User"); user != "bob" {
+		t.Errorf("expected the user to move from alice to bob, got %q", user)
+	}
+
+	// An unterminated quote in the environment keeps the form open.
+	m.form.fields[3].value = `FOO="a b`
+	m, _ = m.handleFormInput(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.formKind != formAutologin || !strings.Contains(m.form.err, "unterminated quote") {
+		t.Errorf("expected a quoting error, got %q", m.form.err)
+	}
+	m.form.fields[3].value = `FOO="a b" BAR=c`
+
+	// An invalid tty keeps the form open with an error.
+	m.form.fields[0].value = "bogus"
+	m, _ = m.handleFormInput(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.formKind != formAutologin || m.form.err == "" {
+		t.Error("expected form to stay open with a validation error")
+	}
+
+	m.form.fields[0].value = "tty1"
+	m, cmd := m.handleFormInput(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.formKind != formNone {
+		t.Error("expected form to close after a valid submit")
+	}
+	if cmd == nil {
+		t.Error("expected a command to run the autologin script")
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	defer session.Clear()
+	m := New(mockInstaller{installedItems: map[string]string{"git": "the fast distributed vcs"}})
+
+	state := session.State{
+		Source:  string(logsview.InstallPackages),
+		Pending: []string{"git", "docker [docker]"},
+		Results: []session.Result{{Item: "vim", Success: true, Log: "vim: Installed successfully"}},
+	}
+	updated, _ := m.Update(sessionFound(state))
+	m = updated.(Model)
+	if !m.resumeConfirm {
+		t.Fatal("expected resume prompt on startup")
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m, _ = passPreflight(t, updated.(Model), cmd)
+	if m.currentStage != stageInstalling {
+		t.Errorf("expected stageInstalling (%d), got %d", stageInstalling, m.currentStage)
+	}
+	if len(m.itemNames) != 1 || m.itemNames[0] != "docker [docker]" {
+		t.Errorf("expected only the uninstalled item to resume, got %v", m.itemNames)
+	}
+}
+
+func TestResumeSession_Discard(t *testing.T) {
+	m := New(mockInstaller{})
+	if err := session.Save(session.State{Pending: []string{"git"}}); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := m.Update(sessionFound(session.State{Pending: []string{"git"}}))
+	m = updated.(Model)
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	m = updated.(Model)
+	if m.resumeConfirm {
+		t.Error("expected resume prompt to close")
+	}
+	if _, ok, _ := session.Load(); ok {
+		t.Error("expected discarded session to be removed")
+	}
+}
+
+func TestPackagesCategory_RoutedItemsAreNotAUR(t *testing.T) {
+	m := New(mockInstaller{helperMissing: true, repoPkgs: map[string]bool{"git": true}})
+	m.currentStage = stageItems
+	m.directory = config.PkgsDir()
+	m.itemNames = []string{"git", "org.gimp.GIMP [source:flatpak]"}
+	m.selectedItems = map[int]struct{}{0: {}, 1: {}}
+
+	if blocked := m.blockedItems(m.itemNames); len(blocked) != 0 {
+		t.Errorf("expected the flatpak item not to need an AUR helper, got %v", blocked)
+	}
+	if got := m.selectedPackages(); len(got) != 1 || got[0] != "git" {
+		t.Errorf("expected only git to go through the package checks, got %v", got)
+	}
+	if items := m.reviewableItems(); len(items) != 0 {
+		t.Errorf("expected nothing to review, got %v", items)
+	}
+}
+
+func TestHandleConfirmYes_ParuMissingSkipsAURItems(t *testing.T) {
+	m := New(mockInstaller{helperMissing: true, repoPkgs: map[string]bool{"git": true}})
+	m.currentStage = stageItems
+	m.directory = config.PkgsDir()
+	m.itemNames = []string{"git", "paru-only-pkg"}
+	m.selectedItems = map[int]struct{}{0: {}, 1: {}}
+
+	m, _ = m.handleInstall()
+	if m.currentStage != stageConfirm {
+		t.Fatalf("expected stageConfirm (%d), got %d", stageConfirm, m.currentStage)
+	}
+	if blocked := m.blockedItems(m.itemNames); len(blocked) != 1 {
+		t.Errorf("expected 1 blocked AUR item, got %v", blocked)
+	}
+
+	m, cmd := m.handleConfirmYes()
+	m, cmd = passPreflight(t, m, cmd)
+	if m.currentStage != stageInstalling || cmd == nil {
+		t.Errorf("expected repo items to install without paru, stage %d", m.currentStage)
+	}
+}
+
+func TestHandleConfirmYes_ParuMissingOnlyAURItems(t *testing.T) {
+	m := New(mockInstaller{helperMissing: true})
+	m.currentStage = stageConfirm
+	m.directory = config.PkgsDir()
+	m.itemNames = []string{"aur-pkg"}
+	m.selectedItems = map[int]struct{}{0: {}}
+
+	m, cmd := m.handleConfirmYes()
+	if m.currentStage != stageItems {
+		t.Errorf("expected to return to stageItems (%d), got %d", stageItems, m.currentStage)
+	}
+	if cmd != nil {
+		t.Error("expected no install command when every item is blocked")
+	}
+}
+
+func TestReviewStage_ApproveAndReject(t *testing.T) {
+	m := New(mockInstaller{repoPkgs: map[string]bool{"git": true}})
+	m.currentStage = stageItems
+	m.directory = config.PkgsDir()
+	m.itemNames = []string{"git", "aur-one [asdeps]", "aur-two"}
+	m.selectedItems = map[int]struct{}{0: {}, 1: {}, 2: {}}
+
+	m, _ = m.handleInstall()
+	if items := m.reviewableItems(); len(items) != 2 {
+		t.Fatalf("expected 2 reviewable AUR items, got %v", items)
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = updated.(Model)
+	if m.currentStage != stageReview || cmd == nil {
+		t.Fatalf("expected review stage with a fetch command, got stage %d", m.currentStage)
+	}
+
+	// Approve the first package.
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m = updated.(Model)
+	if approved, _ := review.Approved("aur-one"); approved["PKGBUILD"] != "pkgname=aur-one\n" {
+		t.Errorf("expected aur-one approval to be stored by package name, got %v", approved)
+	}
+	if m.itemNames[1] != "aur-one [asdeps] [approved:"+mockCommit+"]" {
+		t.Errorf("expected the approved item to be pinned to the reviewed commit, got %q", m.itemNames[1])
+	}
+
+	// Reject the second one; the install should start without it.
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	m, _ = passPreflight(t, updated.(Model), cmd)
+	if m.currentStage != stageInstalling {
+		t.Fatalf("expected stageInstalling (%d), got %d", stageInstalling, m.currentStage)
+	}
+	if _, ok := m.selectedItems[2]; ok {
+		t.Error("expected rejected package to be deselected")
+	}
+	if len(m.selectedItems) != 2 {
+		t.Errorf("expected 2 items left to install, got %d", len(m.selectedItems))
+	}
+}
+
+func TestRenderReviewLines_DiffAgainstApproval(t *testing.T) {
+	if err := review.Approve("difftest", map[string]string{"PKGBUILD": "pkgver=1\n"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := renderReviewLines("difftest", map[string]string{"PKGBUILD": "pkgver=2\n"})
+	if lines[0] != "Changed since the last approval:" {
+		t.Errorf("expected change notice, got %q", lines[0])
+	}
+
+	lines = renderReviewLines("difftest", map[string]string{"PKGBUILD": "pkgver=1\n"})
+	if lines[0] != "Unchanged since the last approval." {
+		t.Errorf("expected unchanged notice, got %q", lines[0])
+	}
+}
+
+func TestHelperInstallForm_StrategyAndResume(t *testing.T) {
+	m := New(mockInstaller{helperMissing: true})
+	m.cursor = menuInstallHelper
+	m, _ = m.handleMenuEnter()
+	if m.formKind != formHelperInstall {
+		t.Fatalf("expected the helper install form, got %d", m.formKind)
+	}
+	if m.form.value("Start") != "" {
+		t.Error("expected no resume field without a failed attempt")
+	}
+
+	// Binary strategy with review goes through the -bin PKGBUILD first.
+	m.form.fields[0].value = scripts.BootstrapBinary
+	m.form.fields[3].value = "yes"
+	m, cmd := m.submitForm()
+	if !m.reviewActive() || m.review.current() != "paru-bin" || cmd == nil {
+		t.Fatalf("expected a paru-bin review, got %v", m.review.queue)
+	}
+	m.review = reviewState{}
+
+	// A failed attempt is recorded and offered for resumption.
+	failure := logsview.HelperInstallFailed{Step: 3, Opts: scripts.BootstrapOptions{Strategy: scripts.BootstrapBinary}}
+	updated, _ := m.Update(failure)
+	m = updated.(Model)
+	m, _ = m.handleMenuEnter()
+	if m.form.value("Strategy") != scripts.BootstrapBinary || m.form.value("Start") != "resume" {
+		t.Fatalf("expected the failed strategy preselected with resume, got %q/%q",
+			m.form.value("Strategy"), m.form.value("Start"))
+	}
+	m, cmd = m.submitForm()
+	if m.formKind != formNone || cmd == nil {
+		t.Fatal("expected the bootstrap to start")
+	}
+	if m.helperStartStep != 3 || m.helperBootstrap.Strategy != scripts.BootstrapBinary {
+		t.Errorf("expected resume at step 3 with binary, got %d/%q", m.helperStartStep, m.helperBootstrap.Strategy)
+	}
+
+	// Resuming with a different strategy is refused.
+	m, _ = m.handleMenuEnter()
+	m.form.fields[0].value = scripts.BootstrapSource
+	m, _ = m.submitForm()
+	if m.form.err == "" {
+		t.Error("expected an error when resuming with another strategy")
+	}
+
+	updated, _ = m.Update(logsview.HelperInstalled{})
+	if updated.(Model).helperFailure != nil {
+		t.Error("expected a successful install to clear the failure")
+	}
+}
+
+func TestSelectUser_AppliesToUserScopedTasks(t *testing.T) {
+	m := New(mockInstaller{loginUsers: []string{"alice", "bob"}})
+	if menuItemsTitles[menuSelectUser] != "Select User (none)" {
+		t.Errorf("unexpected title %q", menuItemsTitles[menuSelectUser])
+	}
+
+	// Without a user, user-scoped tasks ask for one first.
+	m.cursor = menuAddUserToWheel
+	m, cmd := m.handleMenuEnter()
+	if m.formKind != formUser || m.form.err == "" || cmd != nil {
+		t.Fatalf("expected the user picker with a hint, got form %d", m.formKind)
+	}
+
+	m.form.cycle(1)
+	m, _ = m.submitForm()
+	if got := m.installer.TargetUser(); got != "bob" {
+		t.Fatalf("expected bob to be selected, got %q", got)
+	}
+	if menuItemsTitles[menuSelectUser] != "Select User (bob)" {
+		t.Errorf("expected the menu to show the selected user, got %q", menuItemsTitles[menuSelectUser])
+	}
+
+	// The autologin form preselects the picked user.
+	m.cursor = menuAutologin
+	m, _ = m.handleMenuEnter()
+	if got := m.form.value("User"); got != "bob" {
+		t.Errorf("expected autologin to default to bob, got %q", got)
+	}
+}
+
+func TestOptionalDepsFollowUp(t *testing.T) {
+	m := New(mockInstaller{})
+	m.directory = config.PkgsDir()
+	updated, _ := m.Update(logsview.DisableLogs("\nFailed items:\n  ✗ foo\n"))
+	m = updated.(Model)
+
+	deps := logsview.OptionalDepsFound{
+		{OptionalDep: scripts.OptionalDep{Name: "pipewire-jack", Reason: "JACK support"}, For: "pipewire"},
+	}
+	updated, _ = m.Update(deps)
+	m = updated.(Model)
+	if len(m.itemNames) != 1 || m.itemNames[0] != scripts.AsDependency("pipewire-jack") {
+		t.Fatalf("expected the optional dependency to be listed, got %v", m.itemNames)
+	}
+	if len(m.selectedItems) != 0 {
+		t.Error("expected no optional dependency to be preselected")
+	}
+	if got := m.selectedCategory.Items[0].Description; got != "Optional dependency of pipewire: JACK support" {
+		t.Errorf("unexpected description %q", got)
+	}
+	if view := m.logsView.View(); !strings.Contains(view, "Failed items") || !strings.Contains(view, "pipewire-jack (pipewire): JACK support") {
+		t.Errorf("expected the summary and the optional dependencies, got %q", view)
+	}
+}
+
+func TestProviderChoicesInConfirmStage(t *testing.T) {
+	m := New(mockInstaller{providers: map[string]scripts.ProviderChoice{
+		"java-runtime": {Options: []string{"jre-openjdk", "jre21-openjdk", "jre17-openjdk"}},
+		"xorg-apps":    {Group: true, Options: []string{"xorg-xrandr", "xorg-xset"}},
+	}})
+	m.directory = config.PkgsDir()
+	m.currentStage = stageItems
+	m.itemNames = []string{"java-runtime", "xorg-apps", "git"}
+	m.selectedItems = map[int]struct{}{0: {}, 1: {}, 2: {}}
+
+	m, _ = m.handleInstall()
+	if m.formKind != formProviders || len(m.form.fields) != 2 {
+		t.Fatalf("expected the provider picker with two fields, got form %d %+v", m.formKind, m.form.fields)
+	}
+	if got := m.form.value("xorg-apps"); got != "xorg-xrandr xorg-xset" {
+		t.Errorf("expected every group member by default, got %q", got)
+	}
+
+	m.form.cycle(1)
+	m.form.fields[1].value = "xorg-xrandr xorg-bogus"
+	m, _ = m.submitForm()
+	if m.formKind != formProviders || !strings.Contains(m.form.err, "xorg-bogus") {
+		t.Fatalf("expected an error for a non-member, got %q", m.form.err)
+	}
+	m.form.fields[1].value = "xorg-xrandr"
+	m, _ = m.submitForm()
+	if m.formKind != formNone || m.currentStage != stageConfirm {
+		t.Fatalf("expected the confirm stage, got form %d stage %d", m.formKind, m.currentStage)
+	}
+	want := []string{"java-runtime [provider:jre21-openjdk]", "xorg-apps [provider:xorg-xrandr]", "git"}
+	for i, name := range want {
+		if m.itemNames[i] != name {
+			t.Errorf("item %d: expected %q, got %q", i, name, m.itemNames[i])
+		}
+	}
+
+	// Going back and confirming again preselects the earlier choices.
+	m = m.handleConfirmNo()
+	m, _ = m.handleInstall()
+	if got := m.form.value("java-runtime"); got != "jre21-openjdk" {
+		t.Errorf("expected the earlier provider to be preselected, got %q", got)
+	}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if m = updated.(Model); m.currentStage != stageItems {
+		t.Errorf("expected esc to return to the items, got stage %d", m.currentStage)
+	}
+}
+
+func TestConflictsResolvedBeforeConfirm(t *testing.T) {
+	m := New(mockInstaller{conflicts: []scripts.Conflict{
+		{Item: "tlp", With: "auto-cpufreq", Reason: "auto-cpufreq conflicts with tlp"},
+		{Item: "thermald [thermald]", With: "power-profiles-daemon", Installed: true, Reason: "thermald conflicts with power-profiles-daemon"},
+	}})
+	m.directory = config.PkgsDir()
+	m.currentStage = stageItems
+	m.itemNames = []string{"tlp", "auto-cpufreq", "thermald [thermald]"}
+	m.selectedItems = map[int]struct{}{0: {}, 1: {}, 2: {}}
+
+	m, _ = m.handleInstall()
+	if m.formKind != formConflicts || len(m.form.fields) != 2 {
+		t.Fatalf("expected the conflicts form with two fields, got form %d", m.formKind)
+	}
+	if !strings.Contains(m.form.title, "auto-cpufreq conflicts with tlp") {
+		t.Errorf("expected the conflict reasons in the title, got %q", m.form.title)
+	}
+
+	m.form.focus = 1
+	m.form.cycle(1)
+	m, _ = m.submitForm()
+	if m.formKind != formNone || m.currentStage != stageConfirm {
+		t.Fatalf("expected the confirm stage, got form %d stage %d", m.formKind, m.currentStage)
+	}
+	if _, ok := m.selectedItems[1]; ok {
+		t.Error("expected auto-cpufreq to be deselected")
+	}
+	if m.itemNames[2] != "thermald [thermald] [replace:power-profiles-daemon]" {
+		t.Errorf("expected thermald to replace the installed package, got %q", m.itemNames[2])
+	}
+}
+
+func TestPreflight_WarningsContinueFailuresBlock(t *testing.T) {
+	warn := scripts.CheckResult{Name: "pending upgrades", Status: scripts.CheckWarn, Message: "3 package(s) are older"}
+	fail := scripts.CheckResult{Name: "pacman database lock", Status: scripts.CheckFail, Message: "stale lock"}
+	yes := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}}
+
+	m := New(mockInstaller{preflight: []scripts.CheckResult{warn}})
+	m.directory = config.PkgsDir()
+	m.itemNames = []string{"git"}
+	m.selectedItems = map[int]struct{}{0: {}}
+	m.currentStage = stageConfirm
+	m, cmd := m.handleConfirmYes()
+	m, _ = passPreflight(t, m, cmd)
+	if m.currentStage != stagePreflight || !strings.Contains(m.logsView.View(), "3 package(s) are older") {
+		t.Fatalf("expected the warning to be shown, stage %d", m.currentStage)
+	}
+	updated, _ := m.Update(yes)
+	if m = updated.(Model); m.currentStage != stageInstalling {
+		t.Errorf("expected y to continue past warnings, stage %d", m.currentStage)
+	}
+
+	m = New(mockInstaller{preflight: []scripts.CheckResult{warn, fail}})
+	m.directory = config.PkgsDir()
+	m.itemNames = []string{"git"}
+	m.selectedItems = map[int]struct{}{0: {}}
+	m.currentStage = stageConfirm
+	m, cmd = m.handleConfirmYes()
+	m, _ = passPreflight(t, m, cmd)
+	updated, _ = m.Update(yes)
+	if m = updated.(Model); m.currentStage != stagePreflight {
+		t.Errorf("expected failures to block the install, stage %d", m.currentStage)
+	}
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	if m = updated.(Model); m.currentStage != stageItems || m.pendingInstall != nil {
+		t.Errorf("expected n to return to the items, stage %d", m.currentStage)
+	}
+}
+
+func TestSystemUpgrade_ConfirmAndCancel(t *testing.T) {
+	updates := []scripts.PackageUpdate{
+		{Name: "linux", From: "6.9.1", To: "6.9.2"},
+		{Name: "yay", From: "12.3.0", To: "12.3.1", AUR: true},
+	}
+	m := New(mockInstaller{upgrades: updates})
+	m.cursor = menuSystemUpgrade
+	m, cmd := m.handleMenuEnter()
+	m = m.handleUpgradesChecked(cmd().(upgradesChecked))
+	if !m.upgradeConfirm {
+		t.Fatal("expected the upgrade to wait for confirmation")
+	}
+	view := m.logsView.View()
+	for _, want := range []string{"2 package(s) will be upgraded", "linux 6.9.1 -> 6.9.2", "(AUR)", "need a reboot"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected %q in the prompt, got %q", want, view)
+		}
+	}
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	if m = updated.(Model); m.upgradeConfirm || m.logsView.IsActive() {
+		t.Error("expected n to cancel the upgrade")
+	}
+
+	m.cursor = menuSystemUpgrade
+	m, cmd = m.handleMenuEnter()
+	m = m.handleUpgradesChecked(cmd().(upgradesChecked))
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	if m = updated.(Model); m.upgradeConfirm || !m.logsView.IsActive() {
+		t.Error("expected y to start the upgrade")
+	}
+}
+
+func TestSystemUpgrade_UpToDate(t *testing.T) {
+	m := New(mockInstaller{})
+	m.cursor = menuSystemUpgrade
+	m, cmd := m.handleMenuEnter()
+	m = m.handleUpgradesChecked(cmd().(upgradesChecked))
+	if m.upgradeConfirm || !strings.Contains(m.logsView.View(), "up to date") {
+		t.Errorf("expected the system to be reported up to date, got %q", m.logsView.View())
+	}
+}
+
+func TestSystemUpgrade_UnsyncedDatabases(t *testing.T) {
+	m := New(mockInstaller{unsynced: true})
+	m.cursor = menuSystemUpgrade
+	m, cmd := m.handleMenuEnter()
+	m = m.handleUpgradesChecked(cmd().(upgradesChecked))
+	if !m.upgradeConfirm {
+		t.Fatal("expected the upgrade to be offered when the databases were not refreshed")
+	}
+	view := m.logsView.View()
+	if strings.Contains(view, "up to date") || !strings.Contains(view, "not refreshed") {
+		t.Errorf("expected the prompt to say the databases were not refreshed, got %q", view)
+	}
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	if m = updated.(Model); m.upgradeConfirm || !m.logsView.IsActive() {
+		t.Error("expected y to start the upgrade")
+	}
+}
+
+// openTask opens the maintenance task at index and loads its items.
+func openTask(t *testing.T, m Model, index int) Model {
+	t.Helper()
+	m.cursor = menuMaintenance
+	m, _ = m.handleMenuEnter()
+	if m.currentStage != stageCategory || !m.maintenance {
+		t.Fatalf("expected the maintenance tasks, stage %d", m.currentStage)
+	}
+	m.cursor = index
+	m, cmd := m.handleCategoryEnter()
+	if m.formKind == formCacheRetention {
+		m, cmd = m.submitForm()
+	}
+	return m.handleMaintenanceFound(cmd().(maintenanceFound))
+}
+
+func TestMaintenance_Orphans(t *testing.T) {
+	m := openTask(t, New(mockInstaller{orphans: []string{"libfoo", "libbar"}}), 0)
+	if m.currentStage != stageItems || len(m.selectedItems) != 2 {
+		t.Fatalf("expected both orphans to be listed and selected, stage %d", m.currentStage)
+	}
+	if desc := m.selectedCategory.Items[0].Description; desc != "description of libfoo" {
+		t.Errorf("unexpected description %q", desc)
+	}
+	m, _ = m.handleInstall()
+	if view := m.logsView.View(); !strings.Contains(view, "Confirm removal of 2 orphaned package(s)") {
+		t.Errorf("unexpected confirm message %q", view)
+	}
+
+	m = openTask(t, New(mockInstaller{}), 0)
+	if m.currentStage != stageCategory || !strings.Contains(m.logsView.View(), "No orphaned packages") {
+		t.Errorf("expected nothing to do, stage %d: %q", m.currentStage, m.logsView.View())
+	}
+}
+
+func TestMaintenance_CacheRetention(t *testing.T) {
+	m := New(mockInstaller{cached: []scripts.CachedPackage{
+		{Path: "/var/cache/pacman/pkg/git-2.44.0-1-x86_64.pkg.tar.zst", Name: "git", Version: "2.44.0-1", Arch: "x86_64", Size: 8 << 20},
+	}})
+	m.cursor = menuMaintenance
+	m, _ = m.handleMenuEnter()
+	m.cursor = 1
+	m, _ = m.handleCategoryEnter()
+	if m.formKind != formCacheRetention || m.form.fields[0].value != "3" {
+		t.Fatalf("expected the retention form with 3 versions kept, form %d", m.formKind)
+	}
+	m.form.fields[0].value = "-1"
+	m, _ = m.submitForm()
+	if m.form.err == "" {
+		t.Error("expected a negative retention to be rejected")
+	}
+	m.form.fields[0].value = "1"
+	m, cmd := m.submitForm()
+	if m.cacheRetention.Keep != 1 || cmd == nil {
+		t.Fatalf("expected the retention to be applied, got %+v", m.cacheRetention)
+	}
+	m = m.handleMaintenanceFound(cmd().(maintenanceFound))
+	if m.currentStage != stageItems || !strings.Contains(m.logsView.View(), "git 2.44.0-1 (x86_64), 8 MiB") {
+		t.Errorf("expected the cached file to be listed, got %q", m.logsView.View())
+	}
+}
+
+func TestMaintenance_ConfigActions(t *testing.T) {
+	installer := mockInstaller{
+		configs: []scripts.ConfigFile{
+			{Path: "/etc/pacman.conf.pacnew", Original: "/etc/pacman.conf"},
+			{Path: "/etc/locale.gen.pacnew", Original: "/etc/locale.gen"},
+		},
+		files: map[string]string{
+			"/etc/pacman.conf":        "[options]\nColor\nParallelDownloads = 5\n",
+			"/etc/pacman.conf.pacnew": "[options]\n#Color\nParallelDownloads = 5\n",
+		},
+	}
+	m := openTask(t, New(installer), 2)
+	if m.currentStage != stageItems || len(m.itemNames) != 2 {
+		t.Fatalf("expected both config files, stage %d", m.currentStage)
+	}
+	if desc := m.selectedCategory.Items[0].Description; !strings.Contains(desc, "#Color") || !strings.Contains(desc, "│") {
+		t.Errorf("expected a side-by-side diff, got %q", desc)
+	}
+	if len(m.selectedItems) != 0 {
+		t.Fatalf("expected no config file to be preselected, got %v", m.selectedItems)
+	}
+	m.selectedItems[0] = struct{}{}
+	m.selectedItems[1] = struct{}{}
+
+	m, _ = m.handleInstall()
+	if m.formKind != formConfigActions || len(m.form.fields) != 2 {
+		t.Fatalf("expected the config actions form, form %d", m.formKind)
+	}
+	m.form.fields[0].value = scripts.ConfigMerge
+	m, _ = m.submitForm()
+	if m.formKind != formConfigActions || m.form.focus != 1 || !strings.Contains(m.form.err, "/etc/locale.gen.pacnew") {
+		t.Fatalf("expected an action to be required for each file, got %q", m.form.err)
+	}
+	m.form.fields[1].value = scripts.ConfigReplace
+	m, cmd := m.submitForm()
+	if cmd == nil {
+		t.Fatal("expected the merge to open the diff program")
+	}
+	m, _ = m.handleConfigMerged(configMerged{file: installer.configs[0]})
+	want := []string{"/etc/pacman.conf.pacnew [merge]", "/etc/locale.gen.pacnew [replace]"}
+	if !slices.Equal(m.itemNames, want) || m.currentStage != stageConfirm {
+		t.Errorf("expected the chosen actions, got %v, stage %d", m.itemNames, m.currentStage)
+	}
+	if view := m.logsView.View(); !strings.Contains(view, "Confirm resolving 2 config file(s)") {
+		t.Errorf("unexpected confirm message %q", view)
+	}
+
+	// A merge that fails leaves the file alone.
+	m.currentStage = stageItems
+	m, _ = m.handleInstall()
+	m, _ = m.submitForm()
+	m, _ = m.handleConfigMerged(configMerged{file: installer.configs[0], err: errors.New("exit status 1")})
+	if _, ok := m.selectedItems[0]; ok || len(m.selectedItems) != 1 {
+		t.Errorf("expected the failed merge to be deselected, got %v", m.selectedItems)
+	}
+	if view := m.logsView.View(); !strings.Contains(view, "could not be merged") {
+		t.Errorf("expected the merge error, got %q", view)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\n"
+	new := "a\nb\nc\nd\ne\nF\ng\n"
+	lines := sideBySide(review.Diff(old, new), 23, 1)
+	got := lipgloss.NewStyle().Render(strings.Join(lines, "\n"))
+	for _, want := range []string{"…", "e          │ e", "f          │ F", "g          │ g"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "a          │ a") {
+		t.Errorf("expected unchanged lines away from the change to be hidden:\n%s", got)
+	}
+}
+
+func TestHistory_UndoSession(t *testing.T) {
+	started := time.Date(2026, 10, 19, 14, 2, 0, 0, time.UTC)
+	entries := []history.Entry{
+		{Transactions: []history.Transaction{{Time: started.Add(time.Hour), Command: "pacman -Syu", Changes: []history.Change{
+			{Action: history.Upgraded, Name: "linux", From: "6.17-1", To: "6.18-1"},
+		}}}},
+		{Session: &session.Record{Source: "packages", StartedAt: started, FinishedAt: started.Add(time.Minute)},
+			Transactions: []history.Transaction{{Time: started, Changes: []history.Change{
+				{Action: history.Installed, Name: "pcre2", To: "10.44-1"},
+				{Action: history.Installed, Name: "ripgrep", To: "14.1.1-1"},
+			}}}},
+	}
+	plan := scripts.UndoPlan{Remove: []string{"ripgrep"}, Kept: map[string][]string{"pcre2": {"git"}}}
+	m := New(mockInstaller{entries: entries, plan: plan})
+	m.cursor = menuHistory
+	m, cmd := m.handleMenuEnter()
+	m = m.handleHistoryLoaded(cmd().(historyLoaded))
+	if m.currentStage != stageCategory || len(m.categoryNames) != 2 {
+		t.Fatalf("expected both entries to be listed, stage %d", m.currentStage)
+	}
+	if view := m.logsView.View(); !strings.Contains(view, "linux 6.17-1 -> 6.18-1") {
+		t.Errorf("expected the details of the first entry, got %q", view)
+	}
+
+	m, cmd = m.handleCategoryEnter()
+	if cmd != nil || !strings.Contains(m.logsView.View(), "Only archutils sessions") {
+		t.Errorf("expected a plain transaction not to be undone, got %q", m.logsView.View())
+	}
+
+	m.cursor = 1
+	m, cmd = m.handleCategoryEnter()
+	m = m.handleUndoPlanned(cmd().(undoPlanned))
+	if m.currentStage != stageItems || config.SourceKind(m.directory) != scripts.SourceUndo || len(m.selectedItems) != 1 {
+		t.Fatalf("expected the undo items, stage %d, directory %q", m.currentStage, m.directory)
+	}
+	m, _ = m.handleInstall()
+	view := m.logsView.View()
+	if !strings.Contains(view, "Confirm undo: remove 1 package(s)") || !strings.Contains(view, "pcre2 (required by git)") {
+		t.Errorf("unexpected confirm message %q", view)
+	}
+
+	// After the run, the plan is made again and finds nothing left.
+	m.currentStage = stageItems
+	m.resultsSummary = "ripgrep: Removed\n"
+	m = m.handleUndoPlanned(undoPlanned{index: 1, plan: scripts.UndoPlan{}, refresh: true})
+	if m.currentStage != stageCategory || !strings.Contains(m.logsView.View(), "ripgrep: Removed\n\nNothing left to undo") {
+		t.Errorf("expected nothing left to undo, stage %d: %q", m.currentStage, m.logsView.View())
+	}
+}
+
+func TestSnapshot_OfferedBeforeLargeRuns(t *testing.T) {
+	confirmed := func(installer mockInstaller, n int) Model {
+		m := New(installer)
+		m.directory = config.PkgsDir()
+		m.selectedItems = make(map[int]struct{})
+		for i := range n {
+			m.itemNames = append(m.itemNames, fmt.Sprintf("pkg%d", i))
+			m.selectedItems[i] = struct{}{}
+		}
+		m.currentStage = stageConfirm
+		m, cmd := m.handleConfirmYes()
+		m, _ = passPreflight(t, m, cmd)
+		return m
+	}
+
+	m := confirmed(mockInstaller{snapshot: "snapper"}, snapshotMinItems-1)
+	if m.snapshotOffer != snapshotNone || m.currentStage != stageInstalling {
+		t.Errorf("expected a small run to start without a snapshot offer, stage %d", m.currentStage)
+	}
+	m = confirmed(mockInstaller{}, snapshotMinItems)
+	if m.snapshotOffer != snapshotNone || m.currentStage != stageInstalling {
+		t.Errorf("expected no offer without a snapshot tool, stage %d", m.currentStage)
+	}
+
+	m = confirmed(mockInstaller{snapshot: "snapper"}, snapshotMinItems)
+	if m.snapshotOffer != snapshotInstall || !strings.Contains(m.logsView.View(), "Create a snapper snapshot before running 10 item(s)?") {
+		t.Fatalf("expected a snapshot offer, got %q", m.logsView.View())
+	}
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if m = updated.(Model); m.snapshotOffer != snapshotNone || m.currentStage != stageItems || m.pendingInstall != nil {
+		t.Errorf("expected esc to cancel the run, stage %d", m.currentStage)
+	}
+
+	m = confirmed(mockInstaller{snapshot: "snapper"}, snapshotMinItems)
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	if m = updated.(Model); m.snapshotOffer != snapshotNone || m.currentStage != stageInstalling || !m.logsView.IsActive() {
+		t.Errorf("expected y to start the run, stage %d", m.currentStage)
+	}
+}
+
+func TestSnapshot_OfferedBeforeUpgrade(t *testing.T) {
+	m := New(mockInstaller{snapshot: "timeshift", upgrades: []scripts.PackageUpdate{{Name: "git", From: "1", To: "2"}}})
+	m.cursor = menuSystemUpgrade
+	m, cmd := m.handleMenuEnter()
+	m = m.handleUpgradesChecked(cmd().(upgradesChecked))
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	if m = updated.(Model); m.snapshotOffer != snapshotUpgrade || !strings.Contains(m.logsView.View(), "Create a timeshift snapshot before upgrading 1 package(s)?") {
+		t.Fatalf("expected a snapshot offer, got %q", m.logsView.View())
+	}
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	if m = updated.(Model); m.snapshotOffer != snapshotNone || m.upgradeConfirm || !m.logsView.IsActive() {
+		t.Error("expected n to start the upgrade without a snapshot")
+	}
+}
+
+func TestHardware_ConditionsSelectFlagAndHide(t *testing.T) {
+	battery := config.Condition{Feature: hardware.Battery}
+	categories := []config.Category{
+		{Name: "Laptop", Conditions: []config.Condition{battery}, Items: []config.Item{{Name: "tlp"}}},
+		{Name: "Hidden", Conditions: []config.Condition{{Feature: hardware.Battery, Hide: true}}},
+		{Name: "Hardware", Items: []config.Item{
+			{Name: "amd-ucode", Conditions: []config.Condition{{Feature: hardware.CPU, Value: hardware.AMD, Hide: true}}},
+			{Name: "intel-ucode", Conditions: []config.Condition{{Feature: hardware.CPU, Value: hardware.Intel, Hide: true}}},
+			{Name: "vulkan-radeon", Conditions: []config.Condition{{Feature: hardware.GPU, Value: hardware.AMD}}},
+			{Name: "nvidia-utils", Conditions: []config.Condition{{Feature: hardware.GPU, Value: hardware.Nvidia}}},
+			{Name: "# radeontop", Conditions: []config.Condition{{Feature: hardware.GPU, Value: hardware.AMD}}},
+		}},
+	}
+	hw := hardware.Info{CPU: hardware.AMD, GPUs: []string{hardware.AMD}}
+	visible, names := visibleCategories(hw, categories)
+	if len(visible) != 2 || names[0] != "Laptop (no battery detected)" || names[1] != "Hardware" {
+		t.Fatalf("unexpected categories %v", names)
+	}
+
+	m := New(mockInstaller{}).WithHardware(hw)
+	m.directory = config.PkgsDir()
+	m.categories, m.categoryNames = visible, names
+	m.currentStage = stageCategory
+	m.cursor = 1
+	m, _ = m.handleCategoryEnter()
+	if !slices.Equal(m.itemNames, []string{"amd-ucode", "vulkan-radeon", "nvidia-utils", "radeontop"}) {
+		t.Fatalf("expected intel-ucode to be hidden, got %v", m.itemNames)
+	}
+	for i, want := range []bool{true, true, false, false} {
+		if _, ok := m.selectedItems[i]; ok != want {
+			t.Errorf("item %s: selected %v, want %v", m.itemNames[i], ok, want)
+		}
+	}
+	m.cursor = 2
+	m = m.showInformation()
+	if view := m.logsView.View(); !strings.Contains(view, "Hardware: no NVIDIA GPU detected, not preselected.") {
+		t.Errorf("unexpected description %q", view)
+	}
+	if view := m.viewItems(); !strings.Contains(view, "nvidia-utils (no NVIDIA GPU detected)") {
+		t.Errorf("expected the item to be flagged, got %q", view)
+	}
+
+	m.currentStage = stageCategory
+	m.cursor = 0
+	m, _ = m.handleCategoryEnter()
+	if len(m.selectedItems) != 0 || len(m.selectedCategory.Items[0].Conditions) != 1 {
+		t.Errorf("expected the category condition to deselect its items, got %v", m.selectedItems)
+	}
+}
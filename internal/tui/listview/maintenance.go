@@ -0,0 +1,429 @@
+package listview
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/review"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+// maintenanceTask is a category of the maintenance menu. Its items are found
+// on the system rather than read from category files.
+type maintenanceTask struct {
+	kind        string
+	title       string
+	description string
+	// none is shown when the task finds nothing to do.
+	none string
+	// confirm is the confirm stage title, formatted with the item count.
+	confirm string
+	// unselected is set when the found items are not preselected, as each
+	// needs a decision of its own.
+	unselected bool
+}
+
+var maintenanceTasks = []maintenanceTask{
+	{
+		kind:        scripts.SourceOrphans,
+		title:       "Orphaned packages",
+		description: "Packages installed as dependencies that no installed package requires anymore (pacman -Qdt).\n\nThey are removed with their own unneeded dependencies.",
+		none:        "No orphaned packages found.",
+		confirm:     "Confirm removal of %d orphaned package(s):",
+	},
+	{
+		kind:        scripts.SourceCache,
+		title:       "Package cache",
+		description: "Old package files in /var/cache/pacman/pkg.\n\nLike paccache, the newest versions of each package are kept: how many is asked first, separately for packages that are no longer installed.",
+		none:        "Nothing to delete from the package cache.",
+		confirm:     "Confirm deletion of %d cached package file(s):",
+	},
+	{
+		kind:        scripts.SourceConfigs,
+		title:       "Config files (.pacnew/.pacsave)",
+		description: "Config files pacman did not overwrite (.pacnew) or left behind on removal (.pacsave) under /etc, shown side by side with the current file.\n\nNone is selected up front: select the files to resolve, then choose for each whether to keep the current one, replace it, or merge both in $DIFFPROG (vim -d by default).",
+		none:        "No .pacnew or .pacsave files found under /etc.",
+		confirm:     "Confirm resolving %d config file(s):",
+		unselected:  true,
+	},
+}
+
+// maintenanceTaskOf returns the maintenance task of a source kind.
+func maintenanceTaskOf(kind string) (maintenanceTask, bool) {
+	for _, task := range maintenanceTasks {
+		if task.kind == kind {
+			return task, true
+		}
+	}
+	return maintenanceTask{}, false
+}
+
+// maintenanceFound carries the items a maintenance task found. refresh is set
+// when the list is reloaded after a run.
+type maintenanceFound struct {
+	kind    string
+	items   []config.Item
+	configs map[string]scripts.ConfigFile
+	err     error
+	refresh bool
+}
+
+// configMerged reports that the diff program merging a config file exited.
+type configMerged struct {
+	file scripts.ConfigFile
+	err  error
+}
+
+// openMaintenance shows the maintenance tasks as categories.
+func (m Model) openMaintenance() Model {
+	m.maintenance = true
+	m.historyEntries = nil
+	m.directory = ""
+	m.categories = nil
+	m.categoryNames = nil
+	for _, task := range maintenanceTasks {
+		m.categories = append(m.categories, config.Category{Name: task.title})
+		m.categoryNames = append(m.categoryNames, task.title)
+	}
+	m.cursor = 0
+	m.currentStage = stageCategory
+	return m.showInformation()
+}
+
+func (m Model) handleMaintenanceEnter() (Model, tea.Cmd) {
+	if m.cursor >= len(maintenanceTasks) {
+		return m, nil
+	}
+	task := maintenanceTasks[m.cursor]
+	m.directory = config.SourceDir(task.kind)
+	m.selectedCategory = config.Category{Name: task.title}
+	if task.kind == scripts.SourceCache {
+		return m.openForm(formCacheRetention, m.cacheRetentionForm()), nil
+	}
+	return m.findMaintenanceItems(false)
+}
+
+// findMaintenanceItems looks for the items of the current maintenance task.
+func (m Model) findMaintenanceItems(refresh bool) (Model, tea.Cmd) {
+	kind := config.SourceKind(m.directory)
+	if !refresh {
+		m.logsVisible = true
+		m.logsView = logsview.NewInfo("Searching...")
+	}
+	installer, retention := m.installer, m.cacheRetention
+	_, width := m.paneWidths()
+	lines := m.reviewPageSize()
+	return m, func() tea.Msg {
+		msg := maintenanceFound{kind: kind, refresh: refresh}
+		switch kind {
+		case scripts.SourceOrphans:
+			var orphans []string
+			orphans, msg.err = installer.Orphans()
+			src := installer.Source(kind)
+			for _, pkg := range orphans {
+				msg.items = append(msg.items, config.Item{Name: pkg, Description: src.Describe(pkg)})
+			}
+		case scripts.SourceCache:
+			var pkgs []scripts.CachedPackage
+			pkgs, msg.err = installer.StaleCachedPackages(retention)
+			for _, pkg := range pkgs {
+				msg.items = append(msg.items, config.Item{Name: pkg.Path, Description: pkg.Describe()})
+			}
+		case scripts.SourceConfigs:
+			var files []scripts.ConfigFile
+			files, msg.err = installer.ConfigFiles()
+			msg.configs = make(map[string]scripts.ConfigFile)
+			for _, file := range files {
+				msg.configs[file.Path] = file
+				msg.items = append(msg.items, config.Item{
+					Name:        file.Path,
+					Description: configDiffView(installer, file, width, lines),
+				})
+			}
+		}
+		return msg
+	}
+}
+
+func (m Model) handleMaintenanceFound(msg maintenanceFound) Model {
+	stage := stageCategory
+	if msg.refresh {
+		stage = stageItems
+	}
+	if !m.maintenance || msg.kind != config.SourceKind(m.directory) || m.currentStage != stage || m.logsView.IsActive() {
+		return m
+	}
+	task, _ := maintenanceTaskOf(msg.kind)
+	info := ""
+	if msg.refresh {
+		info = strings.TrimRight(m.resultsSummary, "\n")
+		if info != "" {
+			info += "\n\n"
+		}
+	}
+	switch {
+	case msg.err != nil:
+		m.logsVisible = true
+		m.logsView = logsview.NewInfo(info + fmt.Sprintf("Error: %v", msg.err))
+		return m
+	case len(msg.items) == 0:
+		m.currentStage = stageCategory
+		m.itemNames = nil
+		m.selectedItems = make(map[int]struct{})
+		m.logsVisible = true
+		m.logsView = logsview.NewInfo(info + task.none)
+		return m
+	}
+
+	m.selectedCategory = config.Category{Name: task.title, Items: msg.items}
+	m.configFiles = msg.configs
+	m.itemNames = nil
+	m.selectedItems = make(map[int]struct{})
+	for i, item := range msg.items {
+		m.itemNames = append(m.itemNames, item.Name)
+		if !task.unselected {
+			m.selectedItems[i] = struct{}{}
+		}
+	}
+	m.installedItems = make(map[int]bool)
+	m.cursor = 0
+	m.currentStage = stageItems
+	m.searchMode = false
+	m.searchQuery = ""
+	if msg.refresh && info != "" {
+		m.logsVisible = true
+		m.logsView = logsview.NewInfo(strings.TrimRight(info, "\n"))
+		return m
+	}
+	return m.showInformation()
+}
+
+// cacheRetentionForm asks how many versions of each package stay cached.
+func (m Model) cacheRetentionForm() form {
+	return form{
+		title: "Trim the package cache",
+		fields: []formField{
+			{label: "Versions to keep", value: strconv.Itoa(m.cacheRetention.Keep)},
+			{label: "Versions to keep of uninstalled packages", value: strconv.Itoa(m.cacheRetention.KeepUninstalled)},
+		},
+	}
+}
+
+func (m Model) submitCacheRetentionForm() (Model, tea.Cmd) {
+	var values []int
+	for _, field := range m.form.fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field.value))
+		if err != nil || n < 0 {
+			m.form.err = fmt.Sprintf("%s must be a number of 0 or more", strings.ToLower(field.label))
+			m.logsView = logsview.NewInfo(m.form.view())
+			return m, nil
+		}
+		values = append(values, n)
+	}
+	m.cacheRetention = scripts.CacheRetention{Keep: values[0], KeepUninstalled: values[1]}
+	m = m.closeForm("")
+	return m.findMaintenanceItems(false)
+}
+
+// configActionsForm asks what to do with each selected config file. No
+// action is chosen up front, as keep deletes the .pacnew/.pacsave file.
+func (m Model) configActionsForm() form {
+	f := form{title: "Choose what to do with each file:\n\n  keep: delete the .pacnew/.pacsave file\n  replace: move it over the current file\n  merge: edit both in $DIFFPROG, then delete it"}
+	for _, item := range m.selectedItemNames() {
+		f.fields = append(f.fields, formField{
+			label:        scripts.ItemID(item),
+			value:        scripts.ItemConfigAction(item),
+			options:      append([]string{""}, scripts.ConfigActions...),
+			optionLabels: map[string]string{"": "choose"},
+		})
+	}
+	return f
+}
+
+func (m Model) submitConfigActionsForm() (Model, tea.Cmd) {
+	actions := make(map[string]string)
+	for i, field := range m.form.fields {
+		if field.value == "" {
+			m.form.focus = i
+			m.form.err = "choose an action for " + field.label
+			m.logsView = logsview.NewInfo(m.form.view())
+			return m, nil
+		}
+		actions[field.label] = field.value
+	}
+	m.pendingMerges = nil
+	for i, name := range m.itemNames {
+		path := scripts.ItemID(name)
+		action, ok := actions[path]
+		if _, selected := m.selectedItems[i]; !ok || !selected {
+			continue
+		}
+		m.itemNames[i] = scripts.WithConfigAction(path, action)
+		if action == scripts.ConfigMerge {
+			m.pendingMerges = append(m.pendingMerges, m.configFiles[path])
+		}
+	}
+	m = m.closeForm("")
+	return m.nextMerge()
+}
+
+// nextMerge opens the next config file to merge in the diff program, and
+// shows the confirm stage once all are merged.
+func (m Model) nextMerge() (Model, tea.Cmd) {
+	if len(m.pendingMerges) == 0 {
+		return m.showConfirm(), nil
+	}
+	file := m.pendingMerges[0]
+	m.pendingMerges = m.pendingMerges[1:]
+	m.logsView = logsview.NewInfo(fmt.Sprintf("Merging %s and %s...", file.Original, file.Path))
+	return m, tea.ExecProcess(m.installer.MergeConfigCmd(file), func(err error) tea.Msg {
+		return configMerged{file: file, err: err}
+	})
+}
+
+// handleConfigMerged continues with the next merge. A file whose diff program
+// failed is deselected so that it is not deleted unmerged.
+func (m Model) handleConfigMerged(msg configMerged) (Model, tea.Cmd) {
+	if msg.err != nil {
+		for i, name := range m.itemNames {
+			if scripts.ItemID(name) == msg.file.Path {
+				delete(m.selectedItems, i)
+			}
+		}
+		m.mergeErrors = append(m.mergeErrors, fmt.Sprintf("%s: %v", msg.file.Path, msg.err))
+	}
+	if len(m.pendingMerges) > 0 {
+		return m.nextMerge()
+	}
+	mergeErrors := m.mergeErrors
+	m.mergeErrors = nil
+	if len(m.selectedItems) == 0 {
+		m.currentStage = stageItems
+		m.logsView = logsview.NewInfo("Merging failed, nothing to do:\n\n  " + strings.Join(mergeErrors, "\n  "))
+		return m, nil
+	}
+	m = m.showConfirm()
+	if len(mergeErrors) > 0 && m.formKind == formNone {
+		m.logsView = logsview.NewInfo("Skipping the files that could not be merged:\n\n  " +
+			strings.Join(mergeErrors, "\n  ") + "\n\n" + m.confirmMessage())
+	}
+	return m, nil
+}
+
+// configDiffView shows a config file next to its .pacnew or .pacsave file,
+// limited to the changed lines with some context.
+func configDiffView(installer scripts.Installer, file scripts.ConfigFile, width, maxLines int) string {
+	current, err := installer.ReadConfigFile(file.Original)
+	if err != nil {
+		return fmt.Sprintf("Cannot read %s: %v", file.Original, err)
+	}
+	updated, err := installer.ReadConfigFile(file.Path)
+	if err != nil {
+		return fmt.Sprintf("Cannot read %s: %v", file.Path, err)
+	}
+	lines := sideBySide(review.Diff(current, updated), width, 2)
+	header := sideBySideRow(file.Original, file.Path, (width-3)/2)
+	s := reviewHeaderStyle.Render(header) + "\n"
+	if len(lines) == 0 {
+		return s + "\nThe files are identical."
+	}
+	if len(lines) > maxLines {
+		more := len(lines) - maxLines
+		lines = append(lines[:maxLines], scrollDownStyle.Render(fmt.Sprintf("▼ %d more lines, merge to see all", more)))
+	}
+	return s + strings.Join(lines, "\n")
+}
+
+// sideBySide renders a diff in two columns, old on the left and new on the
+// right, keeping context lines of unchanged text around each change.
+func sideBySide(diff []review.Line, width, context int) []string {
+	col := (width - 3) / 2
+	if col < 8 {
+		col = 8
+	}
+	type row struct {
+		left, right string
+		changed     bool
+	}
+	var rows []row
+	for i := 0; i < len(diff); {
+		if diff[i].Op == review.Equal {
+			rows = append(rows, row{left: diff[i].Text, right: diff[i].Text})
+			i++
+			continue
+		}
+		// Pair a run of deleted lines with the inserted lines that follow.
+		var deleted, inserted []string
+		for ; i < len(diff) && diff[i].Op == review.Delete; i++ {
+			deleted = append(deleted, diff[i].Text)
+		}
+		for ; i < len(diff) && diff[i].Op == review.Insert; i++ {
+			inserted = append(inserted, diff[i].Text)
+		}
+		for j := 0; j < max(len(deleted), len(inserted)); j++ {
+			r := row{changed: true}
+			if j < len(deleted) {
+				r.left = diffDeleteStyle.Render(sideBySideCell(deleted[j], col))
+			}
+			if j < len(inserted) {
+				r.right = diffInsertStyle.Render(sideBySideCell(inserted[j], col))
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	keep := make([]bool, len(rows))
+	for i, r := range rows {
+		if !r.changed {
+			continue
+		}
+		for j := max(i-context, 0); j <= min(i+context, len(rows)-1); j++ {
+			keep[j] = true
+		}
+	}
+	var lines []string
+	for i, r := range rows {
+		if !keep[i] {
+			continue
+		}
+		if i > 0 && !keep[i-1] {
+			lines = append(lines, scrollUpStyle.Render("…"))
+		}
+		if r.changed {
+			lines = append(lines, sideBySideJoin(r.left, r.right, col))
+		} else {
+			lines = append(lines, sideBySideRow(r.left, r.right, col))
+		}
+	}
+	if len(lines) > 0 && !keep[len(rows)-1] {
+		lines = append(lines, scrollUpStyle.Render("…"))
+	}
+	return lines
+}
+
+// sideBySideCell cuts or pads text to the column width.
+func sideBySideCell(text string, col int) string {
+	text = strings.ReplaceAll(text, "\t", "    ")
+	runes := []rune(text)
+	if len(runes) > col {
+		return string(runes[:col-1]) + "…"
+	}
+	return text + strings.Repeat(" ", col-len(runes))
+}
+
+func sideBySideRow(left, right string, col int) string {
+	return sideBySideCell(left, col) + " │ " + sideBySideCell(right, col)
+}
+
+// sideBySideJoin joins cells that are already cut to the column width and
+// may be styled; an empty left cell is padded.
+func sideBySideJoin(left, right string, col int) string {
+	if left == "" {
+		left = strings.Repeat(" ", col)
+	}
+	return left + " │ " + right
+}
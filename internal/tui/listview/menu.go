@@ -5,6 +5,7 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/scripts"
 	"github.com/fcarp10/archutils/internal/tui/logsview"
 )
 
@@ -19,16 +20,52 @@ var menuItems = []menuItem{
 		description: "A categorized collection of Arch Linux packages",
 	},
 	{
-		title:       "Install Paru",
-		description: "Paru AUR helper - a package manager for the Arch Linux community repository",
+		title:       "System Upgrade",
+		description: "Upgrade every installed package, including AUR packages through the AUR helper when it is installed.\n\nThe packages that will change are listed before anything runs; kernel, systemd and glibc updates are highlighted since they need a reboot.\n\nInstalling new packages on a system that is not up to date is a partial upgrade, which Arch Linux does not support.",
+	},
+	{
+		title:       "Maintenance",
+		description: "Keep the system tidy: remove orphaned packages, trim the package cache and resolve .pacnew/.pacsave config files.\n\nEach task lists what it found for review before anything changes.",
+	},
+	{
+		title:       "History",
+		description: "pacman's transaction history from /var/log/pacman.log, newest first. Transactions that ran during an archutils session are grouped under it.\n\nA session can be undone: the packages it newly installed are removed again, except those other packages still require. Upgrades are left alone.",
+	},
+	{
+		title:       "Install AUR Helper",
+		description: "AUR helper - a package manager for the Arch Linux community repository.\n\nThe helper (paru, yay or pikaur) is auto-detected, or chosen with --aur-helper or ARCHUTILS_AUR_HELPER.",
 	},
 	{
 		title:       "VSCode Extensions",
 		description: "A collection of VSCode extensions",
 	},
+	{
+		title:       "Flatpak Applications",
+		description: "A categorized collection of Flathub applications.\n\nApplications are installed system-wide, or for the selected user when marked [user]. flatpak and the flathub remote are set up on first use.",
+	},
+	{
+		title:       "Python Tools (pipx)",
+		description: "Python applications installed in isolated environments with pipx for the selected user.\n\npipx is installed on first use.",
+	},
+	{
+		title:       "Rust Tools (cargo)",
+		description: "Rust command-line tools built with cargo install for the selected user.\n\nrust is installed on first use.",
+	},
+	{
+		title:       "Node.js Packages (npm)",
+		description: "Node.js command-line tools installed with npm into ~/.local of the selected user.\n\nnpm is installed on first use.",
+	},
+	{
+		title:       "Go Tools (go install)",
+		description: "Go command-line tools built with go install for the selected user. Items default to @latest, or pin a version with [v1.2.3].\n\ngo is installed on first use.",
+	},
 	{
 		title:       "Enable Autologin",
-		description: "Enable and configure autologin for the current user",
+		description: "Enable and configure autologin on a tty.\n\nChoose the tty, the user to log in and the session type or extra environment lines (space-separated KEY=VALUE).",
+	},
+	{
+		title:       "Disable Autologin",
+		description: "Remove the autologin getty drop-ins and reload systemd",
 	},
 	{
 		title:       "Enable Passwordless SSH",
@@ -36,16 +73,57 @@ var menuItems = []menuItem{
 	},
 	{
 		title:       "Configure Passwordless Sudo",
-		description: "Configure passwordless sudo for the current user (will prompt for password once).\n\nPrerequisite: You must be in the wheel group and sudo must be enabled.\nUse 'Add User to Wheel Group' first if you cannot run sudo.",
+		description: "Configure passwordless root access for the selected user with the detected privilege tool (will prompt for password once).\n\nsudo: writes a sudoers drop-in.\ndoas: adds a 'permit nopass' rule to /etc/doas.conf.\nrun0: installs a polkit rule.\n\nrun0 never caches credentials and doas only does with \"persist\", so with either of them package installs and upgrades need this rule first.\n\nPrerequisite for sudo: You must be in the wheel group and sudo must be enabled.\nUse 'Add User to Wheel Group' first if you cannot run sudo.",
 	},
 	{
 		title:       "Add User to Wheel Group",
-		description: "Add the current user to the wheel group and enable sudo access for the wheel group (requires root password via su, not sudo).\n\nPrerequisite for 'Configure Passwordless Sudo' if you are unable to use sudo.\nAfter running this option, log out and back in for changes to take effect.",
+		description: "Add the selected user to the wheel group and enable sudo access for the wheel group (requires root password via su, not sudo).\n\nPrerequisite for 'Configure Passwordless Sudo' if you are unable to use sudo.\nAfter running this option, log out and back in for changes to take effect.",
+	},
+	{
+		title:       "Select User",
+		description: "Choose the user that user-scoped tasks apply to: autologin, passwordless access, the wheel group, VSCode extensions, [user] Flatpak applications, pipx/cargo/npm/go tools and user-level services.\n\nDefaults to the user who started archutils (SUDO_USER or DOAS_USER under sudo/doas).",
 	},
 }
 
 var menuItemsTitles []string
 
+// menuSources maps the item menu entries to the source they list.
+var menuSources = map[int]string{
+	menuPackages:         scripts.SourcePackages,
+	menuVSCodeExtensions: scripts.SourceVSCode,
+	menuFlatpak:          scripts.SourceFlatpak,
+	menuPipx:             scripts.SourcePipx,
+	menuCargo:            scripts.SourceCargo,
+	menuNpm:              scripts.SourceNpm,
+	menuGo:               scripts.SourceGo,
+}
+
+// refreshMenuTitles fills in the titles that depend on the installer: the
+// helper and privilege tool names and the selected user.
+func refreshMenuTitles(installer scripts.Installer) {
+	menuItemsTitles = make([]string, len(menuItems))
+	for i, item := range menuItems {
+		menuItemsTitles[i] = item.title
+	}
+	menuItemsTitles[menuInstallHelper] = "Install " + scripts.HelperTitle(installer.AURHelperName())
+	menuItemsTitles[menuPasswordlessPrivilege] = "Configure Passwordless " + installer.PrivilegeTool()
+	user := installer.TargetUser()
+	if user == "" {
+		user = "none"
+	}
+	menuItemsTitles[menuSelectUser] = fmt.Sprintf("Select User (%s)", user)
+}
+
+// userScoped reports whether the menu entry applies to the selected user.
+func userScoped(item int) bool {
+	switch item {
+	case menuVSCodeExtensions, menuPipx, menuCargo, menuNpm, menuGo,
+		menuPasswordlessPrivilege, menuAddUserToWheel:
+		return true
+	}
+	return false
+}
+
 func (m Model) viewMenu() string {
 	var list string
 	total := len(menuItemsTitles)
@@ -74,31 +152,39 @@ func (m Model) handleMenuEnter() (Model, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
 
+	if userScoped(m.cursor) && m.installer.TargetUser() == "" {
+		f := m.userForm()
+		f.err = "Select a user first."
+		return m.openForm(formUser, f), nil
+	}
+
 	switch m.cursor {
-	case menuInstallParu:
-		installed, _ := m.installer.CheckParuInstalled()
-		if installed {
-			m.paruReinstallConfirm = true
-			m.logsVisible = true
-			m.logsView = logsview.NewInfo("Paru is already installed. Press 'y' to reinstall or 'n' to cancel.")
-			return m, nil
-		}
-		m, cmd = m.startParuInstall()
-		cmds = append(cmds, cmd)
+	case menuSelectUser:
+		return m.openForm(formUser, m.userForm()), nil
+	case menuSystemUpgrade:
+		return m.startUpgradeCheck()
+	case menuMaintenance:
+		return m.openMaintenance(), nil
+	case menuHistory:
+		return m.startHistory()
+	case menuInstallHelper:
+		return m.openForm(formHelperInstall, m.helperInstallForm()), nil
 	case menuAutologin:
+		return m.openForm(formAutologin, m.autologinForm()), nil
+	case menuDisableAutologin:
 		m.logsVisible = true
 		m.logsView = logsview.NewScript(m.installer)
-		m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptAutologin))
+		m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptDisableAutologin))
 		cmds = append(cmds, cmd)
 	case menuPasswordlessSSH:
 		m.logsVisible = true
 		m.logsView = logsview.NewScript(m.installer)
 		m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptPasswordlessSSH))
 		cmds = append(cmds, cmd)
-	case menuPasswordlessSudo:
+	case menuPasswordlessPrivilege:
 		m.logsVisible = true
 		m.logsView = logsview.NewScript(m.installer)
-		m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptPasswordlessSudo))
+		m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptPasswordlessPrivilege))
 		cmds = append(cmds, cmd)
 	case menuAddUserToWheel:
 		m.logsVisible = true
@@ -106,14 +192,11 @@ func (m Model) handleMenuEnter() (Model, tea.Cmd) {
 		m.logsView, cmd = m.logsView.Update(logsview.RunningScript(logsview.ScriptAddUserToWheel))
 		cmds = append(cmds, cmd)
 	default:
-		switch m.cursor {
-		case menuPackages:
-			m.directory = config.PkgsDir()
-		case menuVSCodeExtensions:
-			m.directory = config.ExtDir()
-		}
+		m.maintenance = false
+		m.historyEntries = nil
+		m.directory = config.SourceDir(menuSources[m.cursor])
 		var err error
-		m.categories, m.categoryNames, err = initCategories(m.directory)
+		m.categories, m.categoryNames, err = initCategories(m.directory, m.hardware)
 		if err != nil {
 			m.logsVisible = true
 			m.logsView = logsview.NewInfo(fmt.Sprintf("Error: %v", err))
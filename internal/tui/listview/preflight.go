@@ -0,0 +1,92 @@
+package listview
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/scripts"
+	helpkeys "github.com/fcarp10/archutils/internal/tui/helpkeys"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+var warnMark = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).SetString("!")
+
+// preflightDone carries the results of the pre-flight checks.
+type preflightDone []scripts.CheckResult
+
+func runPreflight(installer scripts.Installer, source string) tea.Cmd {
+	return func() tea.Msg {
+		return preflightDone(installer.PreflightChecks(source))
+	}
+}
+
+// startPreflight runs the pre-flight checks before the confirmed items are
+// installed.
+func (m Model) startPreflight() (Model, tea.Cmd) {
+	m.currentStage = stagePreflight
+	m.preflight = nil
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo("Running pre-flight checks...")
+	return m, runPreflight(m.installer, config.SourceKind(m.directory))
+}
+
+// handlePreflightDone starts the install when every check passed, and
+// otherwise shows the results: warnings can be accepted, failures block.
+func (m Model) handlePreflightDone(results preflightDone) (Model, tea.Cmd) {
+	if m.currentStage != stagePreflight {
+		return m, nil
+	}
+	m.preflight = results
+	if preflightStatus(m.preflight) == scripts.CheckPass {
+		return m.beforeInstall()
+	}
+	m.logsView = logsview.NewInfo(m.preflightMessage())
+	return m, nil
+}
+
+func (m Model) handlePreflightInput(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, helpkeys.Keys.ConfirmYes):
+		if m.preflight != nil && preflightStatus(m.preflight) == scripts.CheckWarn {
+			return m.beforeInstall()
+		}
+	case key.Matches(msg, helpkeys.Keys.ConfirmNo), key.Matches(msg, helpkeys.Keys.Back):
+		m.pendingInstall = nil
+		m.pendingResume = nil
+		m.preflight = nil
+		return m.handleConfirmNo(), nil
+	case key.Matches(msg, helpkeys.Keys.Quit):
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// preflightStatus returns the worst status of the results.
+func preflightStatus(results []scripts.CheckResult) scripts.CheckStatus {
+	status := scripts.CheckPass
+	for _, r := range results {
+		status = max(status, r.Status)
+	}
+	return status
+}
+
+func (m Model) preflightMessage() string {
+	s := "Pre-flight checks:\n\n"
+	for _, r := range m.preflight {
+		mark := logsview.CheckMark.String()
+		switch r.Status {
+		case scripts.CheckWarn:
+			mark = warnMark.String()
+		case scripts.CheckFail:
+			mark = logsview.CrossMark.String()
+		}
+		s += fmt.Sprintf("  %s %s: %s\n", mark, r.Name, r.Message)
+	}
+	if preflightStatus(m.preflight) == scripts.CheckFail {
+		return s + "\nFix the failed checks and confirm the installation again.\n\n  n: Back"
+	}
+	return s + "\n  y: Continue anyway   n: Cancel"
+}
@@ -0,0 +1,108 @@
+package listview
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/session"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+// sessionFound carries an unfinished install run found on startup.
+type sessionFound session.State
+
+// loadSession looks for an install run that was interrupted by a quit or crash.
+func loadSession() tea.Msg {
+	s, ok, err := session.Load()
+	if err != nil || !ok || !s.Unfinished() {
+		return nil
+	}
+	return sessionFound(s)
+}
+
+// directoryForInstallType maps a saved item type back to its config directory.
+func directoryForInstallType(installType logsview.ItemsInstallType) string {
+	return config.SourceDir(string(installType))
+}
+
+func (m Model) resumePrompt() string {
+	s := m.resumeSession
+	msg := fmt.Sprintf("An unfinished install session was found (started %s).\n\n", s.StartedAt.Format("2006-01-02 15:04"))
+	msg += fmt.Sprintf("%d item(s) done, %d item(s) pending:\n\n", len(s.Results), len(s.Pending))
+	for _, name := range s.Pending {
+		msg += "  • " + name + "\n"
+	}
+	msg += "\n  y: Resume   n: Discard"
+	return msg
+}
+
+// pendingNotInstalled drops the pending items that are already installed,
+// e.g. because they finished after the session file was last written.
+func (m Model) pendingNotInstalled(s session.State) (remaining []string, skipped []string) {
+	installed := scripts.InstalledItems(m.installer, s.Source, s.Pending)
+	for i, item := range s.Pending {
+		if scripts.ItemID(item) == "" {
+			continue
+		}
+		if _, isInstalled := installed[i]; isInstalled {
+			skipped = append(skipped, item)
+		} else {
+			remaining = append(remaining, item)
+		}
+	}
+	return remaining, skipped
+}
+
+func (m Model) handleResumeYes() (Model, tea.Cmd) {
+	s := m.resumeSession
+	m.resumeConfirm = false
+	m.resumeSession = session.State{}
+
+	remaining, skipped := m.pendingNotInstalled(s)
+	for _, item := range skipped {
+		s = s.Record(item, true, item+": Already installed, skipped")
+	}
+	if len(remaining) == 0 {
+		session.Clear()
+		m.logsVisible = true
+		m.logsView = logsview.NewInfo("All pending items are already installed. Nothing to resume.")
+		return m, nil
+	}
+
+	installType := logsview.ItemsInstallType(s.Source)
+	// Load the categories so that going back after the run lands on the usual
+	// category list. A read error only affects that, not the resumed run.
+	if _, ok := maintenanceTaskOf(s.Source); ok {
+		m = m.openMaintenance()
+	} else {
+		m.maintenance = false
+		m.historyEntries = nil
+		m.categories, m.categoryNames, _ = initCategories(directoryForInstallType(installType), m.hardware)
+	}
+	m.directory = directoryForInstallType(installType)
+
+	category := config.Category{Name: "Resumed session"}
+	m.selectedItems = make(map[int]struct{})
+	for i, item := range remaining {
+		category.Items = append(category.Items, config.Item{Name: item})
+		m.selectedItems[i] = struct{}{}
+	}
+	m.selectedCategory = category
+	m.itemNames = remaining
+	m.installedItems = make(map[int]bool)
+	m.cursor = 0
+	m.pendingInstall = remaining
+	m.pendingResume = &s
+	return m.startPreflight()
+}
+
+func (m Model) handleResumeNo() Model {
+	m.resumeConfirm = false
+	m.resumeSession = session.State{}
+	session.Clear()
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo("Unfinished session discarded.")
+	return m
+}
@@ -0,0 +1,300 @@
+package listview
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/fcarp10/archutils/internal/review"
+	"github.com/fcarp10/archutils/internal/scripts"
+	helpkeys "github.com/fcarp10/archutils/internal/tui/helpkeys"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+var (
+	diffInsertStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("42"))
+	diffDeleteStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196"))
+	reviewHeaderStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("205")).
+				Bold(true)
+)
+
+// buildFilesFetched carries the build files of an AUR package under review.
+type buildFilesFetched struct {
+	pkg    string
+	files  map[string]string
+	commit string
+	err    error
+}
+
+// reviewState tracks the PKGBUILD review of a queue of AUR packages.
+type reviewState struct {
+	queue []string
+	index int
+	files map[string]string
+	// commit is the AUR commit the files are at.
+	commit   string
+	lines    []string
+	offset   int
+	loading  bool
+	rejected map[string]bool
+	// forHelper is set when reviewing the AUR helper before bootstrapping it
+	// rather than the selected items.
+	forHelper bool
+}
+
+func (r reviewState) current() string {
+	if r.index < len(r.queue) {
+		return r.queue[r.index]
+	}
+	return ""
+}
+
+func fetchBuildFilesCmd(installer scripts.Installer, pkg string) tea.Cmd {
+	return func() tea.Msg {
+		files, commit, err := installer.FetchBuildFiles(pkg)
+		return buildFilesFetched{pkg: pkg, files: files, commit: commit, err: err}
+	}
+}
+
+// startReview enters the review stage for the given AUR packages.
+func (m Model) startReview(items []string, forHelper bool) (Model, tea.Cmd) {
+	m.review = reviewState{
+		queue:     items,
+		loading:   true,
+		rejected:  make(map[string]bool),
+		forHelper: forHelper,
+	}
+	if !forHelper {
+		m.currentStage = stageReview
+	}
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo(m.reviewView())
+	return m, fetchBuildFilesCmd(m.installer, items[0])
+}
+
+// reviewActive reports whether a PKGBUILD review is in progress.
+func (m Model) reviewActive() bool {
+	return len(m.review.queue) > 0
+}
+
+func (m Model) handleBuildFilesFetched(msg buildFilesFetched) Model {
+	if !m.reviewActive() || msg.pkg != m.review.current() {
+		return m
+	}
+	m.review.loading = false
+	m.review.offset = 0
+	m.review.files = msg.files
+	m.review.commit = msg.commit
+	if msg.err != nil {
+		m.review.files = nil
+		m.review.lines = []string{diffDeleteStyle.Render(fmt.Sprintf("Failed to fetch build files: %v", msg.err))}
+	} else {
+		m.review.lines = renderReviewLines(scripts.ItemID(msg.pkg), msg.files)
+	}
+	m.logsView = logsview.NewInfo(m.reviewView())
+	return m
+}
+
+// renderReviewLines shows the build files of pkg, diffed against the version
+// approved last time when there is one. Approvals are stored by package name,
+// so that they survive changes to the annotations of its line.
+func renderReviewLines(pkg string, files map[string]string) []string {
+	approved, err := review.Approved(pkg)
+	var lines []string
+	switch {
+	case err != nil:
+		lines = append(lines, diffDeleteStyle.Render(fmt.Sprintf("Could not read previous approval: %v", err)))
+	case approved == nil:
+		lines = append(lines, "First review: no approved version stored.")
+	default:
+		changed := false
+		for _, name := range review.FileNames(approved, files) {
+			if review.Changed(review.Diff(approved[name], files[name])) {
+				changed = true
+			}
+		}
+		if changed {
+			lines = append(lines, "Changed since the last approval:")
+		} else {
+			lines = append(lines, "Unchanged since the last approval.")
+		}
+	}
+
+	for _, name := range review.FileNames(approved, files) {
+		lines = append(lines, "", reviewHeaderStyle.Render("── "+name+" ──"))
+		for _, l := range review.Diff(approved[name], files[name]) {
+			text := string(l.Op) + " " + l.Text
+			switch {
+			case approved == nil:
+				text = l.Text
+			case l.Op == review.Insert:
+				text = diffInsertStyle.Render(text)
+			case l.Op == review.Delete:
+				text = diffDeleteStyle.Render(text)
+			}
+			lines = append(lines, text)
+		}
+	}
+	return lines
+}
+
+// reviewPageSize is the number of review lines that fit in the right pane.
+func (m Model) reviewPageSize() int {
+	// Reserve lines for the pane border/padding, the header, the key hints
+	// and the help bar below the panes.
+	size := m.height - 12
+	if size < 5 {
+		size = 5
+	}
+	return size
+}
+
+func (m Model) reviewView() string {
+	r := m.review
+	s := reviewHeaderStyle.Render(fmt.Sprintf("PKGBUILD review %d/%d: %s", r.index+1, len(r.queue), r.current())) + "\n\n"
+	if r.loading {
+		return s + "Fetching build files from the AUR..."
+	}
+
+	page := m.reviewPageSize()
+	end := r.offset + page
+	if end > len(r.lines) {
+		end = len(r.lines)
+	}
+	if r.offset > 0 {
+		s += scrollUpStyle.Render(fmt.Sprintf("▲ %d more", r.offset)) + "\n"
+	}
+	s += strings.Join(r.lines[r.offset:end], "\n") + "\n"
+	if end < len(r.lines) {
+		s += scrollDownStyle.Render(fmt.Sprintf("▼ %d more", len(r.lines)-end)) + "\n"
+	}
+	if r.files != nil {
+		s += "\n  y: Approve   n: Reject   ↑/↓ pgup/pgdn: Scroll   esc: Stop review"
+	} else {
+		s += "\n  n: Skip package   esc: Stop review"
+	}
+	return s
+}
+
+func (m Model) scrollReview(delta int) Model {
+	maxOffset := len(m.review.lines) - m.reviewPageSize()
+	if maxOffset < 0 {
+		maxOffset = 0
+	}
+	m.review.offset += delta
+	if m.review.offset > maxOffset {
+		m.review.offset = maxOffset
+	}
+	if m.review.offset < 0 {
+		m.review.offset = 0
+	}
+	m.logsView = logsview.NewInfo(m.reviewView())
+	return m
+}
+
+func (m Model) handleReviewInput(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch {
+	case msg.Type == tea.KeyPgUp:
+		return m.scrollReview(-m.reviewPageSize()), nil
+	case msg.Type == tea.KeyPgDown:
+		return m.scrollReview(m.reviewPageSize()), nil
+	case key.Matches(msg, helpkeys.Keys.Up):
+		return m.scrollReview(-1), nil
+	case key.Matches(msg, helpkeys.Keys.Down):
+		return m.scrollReview(1), nil
+	case key.Matches(msg, helpkeys.Keys.ConfirmYes):
+		if m.review.loading || m.review.files == nil {
+			return m, nil
+		}
+		if err := review.Approve(scripts.ItemID(m.review.current()), m.review.files); err != nil {
+			m.review.lines = append([]string{diffDeleteStyle.Render(fmt.Sprintf("Failed to store approval: %v", err))}, m.review.lines...)
+			m.logsView = logsview.NewInfo(m.reviewView())
+			return m, nil
+		}
+		m = m.pinApproval()
+		return m.nextReview()
+	case key.Matches(msg, helpkeys.Keys.ConfirmNo):
+		if m.review.loading {
+			return m, nil
+		}
+		m.review.rejected[m.review.current()] = true
+		return m.nextReview()
+	case key.Matches(msg, helpkeys.Keys.Back):
+		return m.stopReview(), nil
+	case key.Matches(msg, helpkeys.Keys.Quit):
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// pinApproval pins the build of the approved package to the reviewed commit,
+// so that the install builds what was approved rather than whatever the AUR
+// serves by then.
+func (m Model) pinApproval() Model {
+	if m.review.forHelper {
+		m.helperBootstrap.Commit = m.review.commit
+		return m
+	}
+	current := m.review.current()
+	for i, name := range m.itemNames {
+		if name == current {
+			m.itemNames[i] = scripts.WithApproval(name, m.review.commit)
+		}
+	}
+	return m
+}
+
+// stopReview abandons the review without installing anything.
+func (m Model) stopReview() Model {
+	forHelper := m.review.forHelper
+	m.review = reviewState{}
+	if forHelper {
+		m.logsView = logsview.NewInfo("PKGBUILD review stopped, nothing was installed.")
+		return m
+	}
+	m.currentStage = stageConfirm
+	m.logsView = logsview.NewInfo(m.confirmMessage())
+	return m
+}
+
+// nextReview moves on to the next package, or finishes the review and starts
+// installing whatever was approved.
+func (m Model) nextReview() (Model, tea.Cmd) {
+	m.review.index++
+	if m.review.index < len(m.review.queue) {
+		m.review.loading = true
+		m.review.files = nil
+		m.review.commit = ""
+		m.review.lines = nil
+		m.logsView = logsview.NewInfo(m.reviewView())
+		return m, fetchBuildFilesCmd(m.installer, m.review.current())
+	}
+
+	r := m.review
+	m.review = reviewState{}
+	if r.forHelper {
+		if r.rejected[r.queue[0]] {
+			m.logsView = logsview.NewInfo(scripts.HelperTitle(r.queue[0]) + " PKGBUILD rejected, nothing was installed.")
+			return m, nil
+		}
+		return m.startHelperInstall()
+	}
+
+	for idx, name := range m.itemNames {
+		if r.rejected[name] {
+			delete(m.selectedItems, idx)
+		}
+	}
+	m.currentStage = stageConfirm
+	if len(m.selectedItems) == 0 {
+		m.logsView = logsview.NewInfo("All packages were rejected, nothing to install.")
+		m.currentStage = stageItems
+		return m, nil
+	}
+	return m.handleConfirmYes()
+}
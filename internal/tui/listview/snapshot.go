@@ -0,0 +1,96 @@
+package listview
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fcarp10/archutils/internal/config"
+	"github.com/fcarp10/archutils/internal/scripts"
+	helpkeys "github.com/fcarp10/archutils/internal/tui/helpkeys"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+// snapshotMinItems is the size from which a pacman run is offered a
+// snapshot. System upgrades always are.
+const snapshotMinItems = 10
+
+// What a snapshot offer is for.
+const (
+	snapshotNone = iota
+	snapshotInstall
+	snapshotUpgrade
+)
+
+// snapshotSources are the sources whose runs change system packages.
+var snapshotSources = []string{scripts.SourcePackages, scripts.SourceOrphans, scripts.SourceUndo}
+
+// beforeInstall offers a snapshot before large pacman runs, and otherwise
+// starts the install.
+func (m Model) beforeInstall() (Model, tea.Cmd) {
+	kind := config.SourceKind(m.directory)
+	resumed := m.pendingResume != nil && m.pendingResume.Snapshot != nil
+	if len(m.pendingInstall) < snapshotMinItems || resumed || !slices.Contains(snapshotSources, kind) {
+		return m.startInstall("")
+	}
+	if m.snapshotTool = m.installer.SnapshotTool(); m.snapshotTool == "" {
+		return m.startInstall("")
+	}
+	m.snapshotOffer = snapshotInstall
+	return m.showInformation(), nil
+}
+
+// offerUpgradeSnapshot offers a snapshot before the confirmed upgrade, and
+// otherwise starts it.
+func (m Model) offerUpgradeSnapshot() (Model, tea.Cmd) {
+	if m.snapshotTool = m.installer.SnapshotTool(); m.snapshotTool == "" {
+		return m.startUpgrade("")
+	}
+	m.snapshotOffer = snapshotUpgrade
+	return m.showInformation(), nil
+}
+
+func (m Model) snapshotPrompt() string {
+	what := fmt.Sprintf("running %d item(s)", len(m.pendingInstall))
+	if m.snapshotOffer == snapshotUpgrade {
+		what = "upgrading " + m.upgradeSize()
+	}
+	return fmt.Sprintf("Create a %s snapshot before %s?\n\n", m.snapshotTool, what) +
+		"If the run breaks the system, the snapshot can be restored.\n\n" +
+		"  y: Create snapshot   n: Skip   esc: Cancel"
+}
+
+func (m Model) handleSnapshotInput(msg tea.KeyMsg) (Model, tea.Cmd) {
+	tool := m.snapshotTool
+	offer := m.snapshotOffer
+	switch {
+	case key.Matches(msg, helpkeys.Keys.ConfirmYes), key.Matches(msg, helpkeys.Keys.ConfirmNo):
+		m.snapshotOffer = snapshotNone
+		m.snapshotTool = ""
+		if key.Matches(msg, helpkeys.Keys.ConfirmNo) {
+			tool = ""
+		}
+		if offer == snapshotUpgrade {
+			return m.startUpgrade(tool)
+		}
+		return m.startInstall(tool)
+	case key.Matches(msg, helpkeys.Keys.Back):
+		m.snapshotOffer = snapshotNone
+		m.snapshotTool = ""
+		if offer == snapshotUpgrade {
+			m.upgradeConfirm = false
+			m.pendingUpgrades = nil
+			m.upgradesUnsynced = false
+			m.logsView = logsview.NewInfo("Upgrade cancelled.")
+			return m, nil
+		}
+		m.pendingInstall = nil
+		m.pendingResume = nil
+		m.preflight = nil
+		return m.handleConfirmNo(), nil
+	case key.Matches(msg, helpkeys.Keys.Quit):
+		return m, tea.Quit
+	}
+	return m, nil
+}
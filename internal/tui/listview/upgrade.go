@@ -0,0 +1,112 @@
+package listview
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fcarp10/archutils/internal/scripts"
+	helpkeys "github.com/fcarp10/archutils/internal/tui/helpkeys"
+	"github.com/fcarp10/archutils/internal/tui/logsview"
+)
+
+// upgradesChecked carries the packages a system upgrade would change.
+// synced is unset when they were listed from sync databases that were not
+// refreshed, which can miss updates.
+type upgradesChecked struct {
+	updates []scripts.PackageUpdate
+	synced  bool
+	err     error
+}
+
+func checkUpgrades(installer scripts.Installer) tea.Cmd {
+	return func() tea.Msg {
+		updates, synced, err := installer.PendingUpgrades()
+		return upgradesChecked{updates: updates, synced: synced, err: err}
+	}
+}
+
+// startUpgradeCheck lists the pending updates before asking to upgrade.
+func (m Model) startUpgradeCheck() (Model, tea.Cmd) {
+	m.logsVisible = true
+	m.logsView = logsview.NewInfo("Checking for updates...")
+	return m, checkUpgrades(m.installer)
+}
+
+func (m Model) handleUpgradesChecked(msg upgradesChecked) Model {
+	if m.currentStage != stageMenu || m.logsView.IsActive() {
+		return m
+	}
+	m.logsVisible = true
+	switch {
+	case msg.err != nil:
+		m.logsView = logsview.NewInfo(fmt.Sprintf("Error: %v", msg.err))
+	case len(msg.updates) == 0 && msg.synced:
+		m.logsView = logsview.NewInfo("The system is up to date.")
+	default:
+		m.upgradeConfirm = true
+		m.pendingUpgrades = msg.updates
+		m.upgradesUnsynced = !msg.synced
+		m.logsView = logsview.NewInfo(m.upgradePrompt())
+	}
+	return m
+}
+
+func (m Model) upgradePrompt() string {
+	var s string
+	if len(m.pendingUpgrades) == 0 {
+		s = "No updates are listed in the local sync databases.\n"
+	} else {
+		s = fmt.Sprintf("%d package(s) will be upgraded:\n\n", len(m.pendingUpgrades))
+		s += logsview.FormatUpdates(m.pendingUpgrades)
+		if len(logsview.RebootUpdates(m.pendingUpgrades)) > 0 {
+			s += "\nHighlighted updates need a reboot to take effect.\n"
+		}
+	}
+	if m.upgradesUnsynced {
+		s += "\nThe sync databases were not refreshed, as checkupdates (pacman-contrib) is not available,\n" +
+			"so newer updates may be missing. The upgrade syncs them first and installs all of them.\n"
+	}
+	return s + "\n  y: Upgrade   n: Cancel"
+}
+
+// upgradeSize describes what the confirmed upgrade changes.
+func (m Model) upgradeSize() string {
+	if m.upgradesUnsynced {
+		return "the system"
+	}
+	return fmt.Sprintf("%d package(s)", len(m.pendingUpgrades))
+}
+
+// startUpgrade runs the confirmed upgrade, after taking a snapshot with
+// snapshotTool unless it is "".
+func (m Model) startUpgrade(snapshotTool string) (Model, tea.Cmd) {
+	updates := m.pendingUpgrades
+	size := m.upgradeSize()
+	m.upgradeConfirm = false
+	m.pendingUpgrades = nil
+	m.upgradesUnsynced = false
+	m.logsView = logsview.NewScript(m.installer)
+	if snapshotTool != "" {
+		description := "archutils: before system upgrade of " + size
+		m.logsView = m.logsView.WithSnapshot(snapshotTool, description)
+	}
+	var cmd tea.Cmd
+	m.logsView, cmd = m.logsView.Update(logsview.RunUpgrade{Updates: updates})
+	return m, cmd
+}
+
+func (m Model) handleUpgradeInput(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, helpkeys.Keys.ConfirmYes):
+		return m.offerUpgradeSnapshot()
+	case key.Matches(msg, helpkeys.Keys.ConfirmNo), key.Matches(msg, helpkeys.Keys.Back):
+		m.upgradeConfirm = false
+		m.pendingUpgrades = nil
+		m.upgradesUnsynced = false
+		m.logsView = logsview.NewInfo("Upgrade cancelled.")
+	case key.Matches(msg, helpkeys.Keys.Quit):
+		return m, tea.Quit
+	}
+	return m, nil
+}
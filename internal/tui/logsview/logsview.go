@@ -3,13 +3,16 @@ package logsview
 import (
 	"fmt"
 	"log"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/session"
 )
 
 var (
@@ -26,7 +29,9 @@ type ScriptType int
 type RunningScript ScriptType
 type successScript string
 type failedScript string
-type ItemsInstallType int
+
+// ItemsInstallType is the kind of the source items are installed from.
+type ItemsInstallType string
 type InstallItems ItemsInstallType
 type successInstalledItem string
 type failedInstalledItem string
@@ -34,28 +39,81 @@ type finishedInstallItems string
 type CancelInstall struct{}
 type SudoValidated struct{ err error }
 type WheelGroupValidated struct{ err error }
-type ParuStepValidated struct{ err error }
+type HelperStepValidated struct{ err error }
+
+// HelperInstallFailed reports the AUR helper bootstrap step that failed so
+// that a later attempt can resume from it.
+type HelperInstallFailed struct {
+	Step int
+	Opts scripts.BootstrapOptions
+}
+
+// HelperInstalled reports that the AUR helper bootstrap finished.
+type HelperInstalled struct{}
+
+// OptionalDependency is an optional dependency offered after an install run.
+type OptionalDependency struct {
+	scripts.OptionalDep
+	// For is the installed package that lists the dependency.
+	For string
+}
 
-var paruStepNames = []string{
-	"Removing old paru",
-	"Installing build dependencies",
-	"Cloning repository",
-	"Building and installing paru",
+// OptionalDepsFound lists the missing optional dependencies of the packages
+// installed in a run.
+type OptionalDepsFound []OptionalDependency
+
+// keepaliveTick and keepaliveChecked carry the keepalive run they belong to,
+// so that a tick still pending from a finished run does not start a second
+// refresh chain in the next one.
+type keepaliveTick struct{ run int }
+type keepaliveChecked struct {
+	run int
+	err error
 }
+type privilegeReauthenticated struct{ err error }
+
+// keepaliveInterval is how often cached credentials are refreshed during an
+// install run or an upgrade. It stays well below sudo's default 5 minute timestamp timeout.
+const keepaliveInterval = time.Minute
+
+// maxReauthAttempts bounds how often a paused queue re-prompts before stopping.
+const maxReauthAttempts = 3
 
 const (
-	ScriptParu ScriptType = iota
+	ScriptAURHelper ScriptType = iota
 	ScriptAutologin
+	ScriptDisableAutologin
 	ScriptPasswordlessSSH
-	ScriptPasswordlessSudo
+	ScriptPasswordlessPrivilege
 	ScriptAddUserToWheel
 )
 
 const (
-	InstallPackages ItemsInstallType = iota
-	InstallExtensions
+	InstallPackages   ItemsInstallType = scripts.SourcePackages
+	InstallExtensions ItemsInstallType = scripts.SourceVSCode
+	InstallFlatpaks   ItemsInstallType = scripts.SourceFlatpak
+	InstallPipx       ItemsInstallType = scripts.SourcePipx
+	InstallCargo      ItemsInstallType = scripts.SourceCargo
+	InstallNpm        ItemsInstallType = scripts.SourceNpm
+	InstallGo         ItemsInstallType = scripts.SourceGo
+	InstallOrphans    ItemsInstallType = scripts.SourceOrphans
+	InstallCache      ItemsInstallType = scripts.SourceCache
+	InstallConfigs    ItemsInstallType = scripts.SourceConfigs
+	InstallUndo       ItemsInstallType = scripts.SourceUndo
 )
 
+// progressVerbs returns how runs of this type describe the item in progress
+// and the items done, e.g. "Installing" and "installed".
+func progressVerbs(t ItemsInstallType) (string, string) {
+	switch t {
+	case InstallOrphans, InstallCache, InstallUndo:
+		return "Removing", "removed"
+	case InstallConfigs:
+		return "Resolving", "resolved"
+	}
+	return "Installing", "installed"
+}
+
 type Model struct {
 	progressBar     progress.Model
 	spinner         spinner.Model
@@ -68,11 +126,25 @@ type Model struct {
 	logs            string
 	installer       scripts.Installer
 	failedItemLogs  []string
+	// joinedGroups are the groups the user was added to during the run.
+	joinedGroups    []string
 	cancelRequested bool
 	pendingScript   ScriptType
 	validatingSudo  bool
 	scriptRunning   bool
-	paruStepIndex   int
+	helperStepIndex int
+	helperSteps     []string
+	bootstrapOpts   scripts.BootstrapOptions
+	autologinOpts   scripts.AutologinOptions
+	keepalive       bool
+	keepaliveRun    int
+	reauthRequired  bool
+	reauthAttempts  int
+	session         session.State
+	upgrade         upgradeState
+	snapshot        snapshotRequest
+	// creatingSnapshot is set while the snapshot before the run is taken.
+	creatingSnapshot bool
 }
 
 func (m Model) Init() tea.Cmd {
@@ -99,6 +171,20 @@ func NewItems(itemNames []string, installer scripts.Installer) Model {
 	}
 }
 
+// NewResumedItems continues an interrupted run. itemNames are the items still
+// to install; the results already recorded in prev are kept for the summary.
+func NewResumedItems(prev session.State, itemNames []string, installer scripts.Installer) Model {
+	m := NewItems(itemNames, installer)
+	m.session = prev
+	m.session.Pending = itemNames
+	for _, result := range prev.Results {
+		if !result.Success {
+			m.failedItemLogs = append(m.failedItemLogs, fmt.Sprintf("%s %s", CrossMark, result.Log))
+		}
+	}
+	return m
+}
+
 func NewScript(installer scripts.Installer) Model {
 	s := spinner.New()
 	s.Style = spinnerStyle
@@ -116,16 +202,44 @@ func NewInfo(info string) Model {
 	}
 }
 
+// WithAutologinOptions sets the settings used by ScriptAutologin.
+func (m Model) WithAutologinOptions(opts scripts.AutologinOptions) Model {
+	m.autologinOpts = opts
+	return m
+}
+
+// WithHelperBootstrap sets how ScriptAURHelper installs the helper and the
+// step it starts at, so that a failed attempt can be resumed.
+func (m Model) WithHelperBootstrap(opts scripts.BootstrapOptions, startStep int) Model {
+	m.bootstrapOpts = opts
+	m.helperStepIndex = startStep
+	return m
+}
+
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 
+	case RunUpgrade, upgradeValidated, upgradeOutput, upgradeFinished, upgradeReauthenticated:
+		return m.handleUpgrade(msg)
+
+	case snapshotCreated:
+		return m.handleSnapshotCreated(msg)
+
 	case InstallItems:
 		m.itemLogs = true
 		m.itemType = ItemsInstallType(msg)
-		if ItemsInstallType(msg) == InstallPackages {
+		m.session.Source = string(m.itemType)
+		m.session.Pending = m.itemNames
+		if m.session.StartedAt.IsZero() {
+			m.session.StartedAt = time.Now()
+		}
+		m.saveSession()
+		// Sources that run commands as root validate credentials first and
+		// keep them alive during the run.
+		if m.needsPrivilege() {
 			m.validatingSudo = true
-			return m, tea.ExecProcess(m.installer.SudoValidateCmd(), func(err error) tea.Msg {
+			return m, tea.ExecProcess(m.installer.PrivilegeValidateCmd(), func(err error) tea.Msg {
 				return SudoValidated{err: err}
 			})
 		}
@@ -138,17 +252,33 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 		m.validatingSudo = false
 		if msg.err != nil {
 			m.itemLogs = false
-			return m, func() tea.Msg { return DisableLogs("Sudo authentication failed: password is required") }
+			// Nothing was installed; don't offer to resume a run that never started.
+			session.Clear()
+			return m, func() tea.Msg {
+				return DisableLogs(fmt.Sprintf("%s authentication failed: password is required", m.installer.PrivilegeTool()))
+			}
 		}
 		if m.itemLogs {
+			if err := m.installer.CheckPrivilegeCache(); err != nil {
+				m.itemLogs = false
+				session.Clear()
+				return m, func() tea.Msg { return DisableLogs(fmt.Sprintf("Nothing was run: %v", err)) }
+			}
+			m.keepalive = true
+			m.keepaliveRun++
+			if m.wantsSnapshot() {
+				m.creatingSnapshot = true
+				return m, tea.Batch(m.spinner.Tick, keepaliveCmd(m.keepaliveRun), m.createSnapshot())
+			}
 			return m, tea.Batch(
 				m.spinner.Tick,
+				keepaliveCmd(m.keepaliveRun),
 				func() tea.Msg { return m.installItem(m.itemType) },
 			)
 		}
 		m.scriptRunning = true
 		installer := m.installer
-		return m, tea.Batch(m.spinner.Tick, func() tea.Msg { return runScript(installer, m.pendingScript) })
+		return m, tea.Batch(m.spinner.Tick, func() tea.Msg { return runScript(installer, m.pendingScript, m.autologinOpts) })
 
 	case WheelGroupValidated:
 		m.validatingSudo = false
@@ -156,59 +286,142 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			return m, func() tea.Msg { return failedScript("Authentication failed: " + msg.err.Error()) }
 		}
 		m.scriptRunning = true
-		return m, tea.Batch(m.spinner.Tick, func() tea.Msg { return runScript(m.installer, m.pendingScript) })
+		return m, tea.Batch(m.spinner.Tick, func() tea.Msg { return runScript(m.installer, m.pendingScript, m.autologinOpts) })
 
-	case ParuStepValidated:
+	case HelperStepValidated:
 		m.validatingSudo = false
-		step := m.paruStepIndex
-		total := m.installer.ParuStepCount()
+		step := m.helperStepIndex
+		total := len(m.helperSteps)
+		helper := scripts.HelperTitle(m.installer.AURHelperName())
+		failed := HelperInstallFailed{Step: step, Opts: m.bootstrapOpts}
 		if msg.err != nil {
-			name := "step"
-			if step >= 0 && step < len(paruStepNames) {
-				name = paruStepNames[step]
-			}
-			return m, func() tea.Msg {
-				return failedScript(fmt.Sprintf("Paru installation failed at step %d/%d (%s): %v", step+1, total, name, msg.err))
-			}
+			name := m.helperSteps[step]
+			return m, tea.Batch(
+				func() tea.Msg {
+					return failedScript(fmt.Sprintf("%s installation failed at step %d/%d (%s): %v", helper, step+1, total, name, msg.err))
+				},
+				func() tea.Msg { return failed },
+			)
 		}
-		m.paruStepIndex = step + 1
-		if m.paruStepIndex >= total {
-			ok, result := m.installer.CheckParuInstalled()
+		m.helperStepIndex = step + 1
+		if m.helperStepIndex >= total {
+			ok, result := m.installer.CheckHelperInstalled()
 			if ok {
-				return m, func() tea.Msg { return successScript("Paru installed successfully!") }
+				return m, tea.Batch(
+					func() tea.Msg { return successScript(helper + " installed successfully!") },
+					func() tea.Msg { return HelperInstalled{} },
+				)
 			}
-			return m, func() tea.Msg { return failedScript(result) }
+			return m, tea.Batch(
+				func() tea.Msg { return failedScript(result) },
+				func() tea.Msg { return failed },
+			)
 		}
 		m.validatingSudo = true
-		return m, tea.ExecProcess(m.installer.ParuStepCmd(m.paruStepIndex), func(err error) tea.Msg {
-			return ParuStepValidated{err: err}
+		return m, tea.ExecProcess(m.installer.HelperStepCmd(m.bootstrapOpts, m.helperStepIndex), func(err error) tea.Msg {
+			return HelperStepValidated{err: err}
 		})
 
+	case keepaliveTick:
+		if msg.run != m.keepaliveRun || !m.keepalive || !(m.itemLogs || m.scriptRunning) {
+			return m, nil
+		}
+		installer := m.installer
+		return m, tea.Batch(
+			keepaliveCmd(msg.run),
+			func() tea.Msg { return keepaliveChecked{run: msg.run, err: installer.RefreshPrivilege()} },
+		)
+
+	case keepaliveChecked:
+		switch {
+		case msg.err == nil || !m.keepalive || msg.run != m.keepaliveRun:
+		case m.itemLogs:
+			// Let the running item finish; the queue pauses before the next one.
+			m.reauthRequired = true
+		case m.scriptRunning && !m.validatingSudo:
+			return m.reauthenticateUpgrade()
+		}
+		return m, nil
+
+	case privilegeReauthenticated:
+		m.validatingSudo = false
+		if msg.err != nil {
+			m.reauthAttempts++
+			if m.reauthAttempts >= maxReauthAttempts {
+				m.cancelRequested = true
+				m.logs = fmt.Sprintf("%s %s authentication failed, stopping the queue", CrossMark, m.installer.PrivilegeTool())
+				return m.selectNextItem(m.itemType)
+			}
+			return m.reauthenticate()
+		}
+		m.reauthRequired = false
+		m.reauthAttempts = 0
+		return m.advance(m.itemType)
+
 	case successInstalledItem:
 		m.logs = fmt.Sprintf("%s %s", CheckMark, strings.Trim(string(msg), "\n"))
 		m.successItemsNum++
+		if m.itemType == InstallPackages {
+			m.joinedGroups = appendNew(m.joinedGroups, scripts.ItemGroups(m.itemNames[m.itemIndex])...)
+		}
+		m.session = m.session.Record(m.itemNames[m.itemIndex], true, strings.Trim(string(msg), "\n"))
+		m.saveSession()
 		return m.selectNextItem(m.itemType)
 
 	case failedInstalledItem:
 		m.logs = fmt.Sprintf("%s %s", CrossMark, strings.Trim(string(msg), "\n"))
 		m.failedItemsNum++
 		m.failedItemLogs = append(m.failedItemLogs, m.logs)
+		m.session = m.session.Record(m.itemNames[m.itemIndex], false, strings.Trim(string(msg), "\n"))
+		m.saveSession()
 		return m.selectNextItem(m.itemType)
 
 	case finishedInstallItems:
 		var summary string
+		if m.session.Snapshot != nil {
+			summary = fmt.Sprintf("\nSnapshot before the run: %s\n", m.session.Snapshot)
+		}
 		if len(m.failedItemLogs) > 0 {
-			summary = "\nFailed items:\n"
+			summary += "\nFailed items:\n"
 			for _, errLog := range m.failedItemLogs {
 				summary += "  " + errLog + "\n"
 			}
 		}
+		if len(m.joinedGroups) > 0 {
+			summary += fmt.Sprintf("\n%s was added to %s: log out and back in for the changes to take effect.\n",
+				m.installer.TargetUser(), strings.Join(m.joinedGroups, ", "))
+		}
+		var installed []string
+		if m.itemType == InstallPackages {
+			for _, result := range m.session.Results {
+				src, _ := scripts.RouteItem(m.installer, string(m.itemType), result.Item)
+				if result.Success && !scripts.IsDependency(result.Item) && src != nil && src.Kind() == scripts.SourcePackages {
+					installed = append(installed, scripts.ItemID(result.Item))
+				}
+			}
+		}
 		m.itemIndex = 0
 		m.failedItemsNum = 0
 		m.successItemsNum = 0
 		m.cancelRequested = false
 		m.failedItemLogs = nil
-		return m, func() tea.Msg { return DisableLogs(summary) }
+		m.joinedGroups = nil
+		m.keepalive = false
+		m.reauthRequired = false
+		m.reauthAttempts = 0
+		// The archive ties the run to the pacman transactions it caused.
+		if err := session.Archive(m.session, time.Now()); err != nil {
+			log.Printf("warning: failed to archive session in %s: %v", session.ArchivePath(), err)
+		}
+		m.session = session.State{}
+		if err := session.Clear(); err != nil {
+			log.Printf("warning: failed to clear session %s: %v", session.Path(), err)
+		}
+		cmds := []tea.Cmd{func() tea.Msg { return DisableLogs(summary) }}
+		if len(installed) > 0 {
+			cmds = append(cmds, findOptionalDeps(m.installer, installed))
+		}
+		return m, tea.Batch(cmds...)
 
 	case CancelInstall:
 		m.cancelRequested = true
@@ -216,11 +429,14 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 	case RunningScript:
 		m.pendingScript = ScriptType(msg)
-		if ScriptType(msg) == ScriptParu {
-			m.paruStepIndex = 0
+		if ScriptType(msg) == ScriptAURHelper {
+			m.helperSteps = m.installer.HelperStepNames(m.bootstrapOpts)
+			if m.helperStepIndex >= len(m.helperSteps) {
+				m.helperStepIndex = 0
+			}
 			m.validatingSudo = true
-			return m, tea.ExecProcess(m.installer.ParuStepCmd(0), func(err error) tea.Msg {
-				return ParuStepValidated{err: err}
+			return m, tea.ExecProcess(m.installer.HelperStepCmd(m.bootstrapOpts, m.helperStepIndex), func(err error) tea.Msg {
+				return HelperStepValidated{err: err}
 			})
 		}
 		if ScriptType(msg) == ScriptAddUserToWheel {
@@ -230,7 +446,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			})
 		}
 		m.validatingSudo = true
-		return m, tea.ExecProcess(m.installer.SudoValidateCmd(), func(err error) tea.Msg {
+		return m, tea.ExecProcess(m.installer.PrivilegeValidateCmd(), func(err error) tea.Msg {
 			return SudoValidated{err: err}
 		})
 
@@ -270,38 +486,79 @@ func (m Model) selectNextItem(itemsType ItemsInstallType) (Model, tea.Cmd) {
 	isCancelled := m.cancelRequested
 
 	if isCancelled || isFinished {
+		_, done := progressVerbs(itemsType)
 		var doneMsg string
 		if isCancelled {
-			doneMsg = doneStyle.Render(fmt.Sprintf("Cancelled! %d/%d items installed.", m.successItemsNum, n))
+			doneMsg = doneStyle.Render(fmt.Sprintf("Cancelled! %d/%d items %s.", m.successItemsNum, n, done))
 		} else if m.failedItemsNum > 0 {
-			doneMsg = doneStyle.Render(fmt.Sprintf("Done! %d items installed, %d items failed.", m.successItemsNum, m.failedItemsNum))
+			doneMsg = doneStyle.Render(fmt.Sprintf("Done! %d items %s, %d items failed.", m.successItemsNum, done, m.failedItemsNum))
 		} else {
-			doneMsg = doneStyle.Render(fmt.Sprintf("Done! All %d items installed successfully.", n))
+			doneMsg = doneStyle.Render(fmt.Sprintf("Done! All %d items %s successfully.", n, done))
 		}
 		return m, tea.Sequence(
 			tea.Printf("%s", m.logs),
 			tea.Printf("%s", doneMsg),
 			func() tea.Msg { return finishedInstallItems(prevPkg) })
 	}
-	progressCmd := m.progressBar.SetPercent(float64(m.successItemsNum) / float64(n))
+	if m.reauthRequired {
+		// Credentials expired mid-run: pause the queue and prompt before the
+		// next item instead of letting it fail.
+		logs := m.logs
+		m, cmd := m.reauthenticate()
+		return m, tea.Sequence(tea.Printf("%s", logs), cmd)
+	}
+	m, cmd := m.advance(itemsType)
+	return m, tea.Batch(tea.Printf("%s", m.logs), cmd)
+}
+
+// advance moves the queue to the next item and starts installing it.
+func (m Model) advance(itemsType ItemsInstallType) (Model, tea.Cmd) {
+	progressCmd := m.progressBar.SetPercent(float64(m.successItemsNum) / float64(len(m.itemNames)))
 	m.itemIndex++
 	return m, tea.Batch(
 		progressCmd,
-		tea.Printf("%s", m.logs),
 		func() tea.Msg { return m.installItem(ItemsInstallType(itemsType)) },
 		m.spinner.Tick,
 	)
 }
 
+// saveSession persists the run so it can be resumed after a quit or crash.
+func (m Model) saveSession() {
+	if err := session.Save(m.session); err != nil {
+		log.Printf("warning: failed to save session %s: %v", session.Path(), err)
+	}
+}
+
+// keepaliveCmd schedules the next credential refresh of the keepalive run.
+func keepaliveCmd(run int) tea.Cmd {
+	return tea.Tick(keepaliveInterval, func(time.Time) tea.Msg { return keepaliveTick{run: run} })
+}
+
+// reauthenticate prompts for credentials through the terminal while the queue
+// is paused. The queue resumes with advance once it succeeds.
+func (m Model) reauthenticate() (Model, tea.Cmd) {
+	m.validatingSudo = true
+	return m, tea.ExecProcess(m.installer.PrivilegeValidateCmd(), func(err error) tea.Msg {
+		return privilegeReauthenticated{err: err}
+	})
+}
+
+// needsPrivilege reports whether the source of any item runs commands as root.
+func (m Model) needsPrivilege() bool {
+	for _, item := range m.itemNames {
+		if src, _ := scripts.RouteItem(m.installer, string(m.itemType), item); src != nil && src.NeedsPrivilege() {
+			return true
+		}
+	}
+	return false
+}
+
 func (m Model) installItem(itemsType ItemsInstallType) tea.Msg {
-	var success bool
-	var logs string
-	switch itemsType {
-	case InstallPackages:
-		success, logs = m.installer.InstallPackage(m.itemNames[m.itemIndex])
-	case InstallExtensions:
-		success, logs = m.installer.InstallVSCodeExtension(m.itemNames[m.itemIndex])
+	src, item := scripts.RouteItem(m.installer, string(itemsType), m.itemNames[m.itemIndex])
+	if src == nil {
+		return failedInstalledItem(fmt.Sprintf("%s: unknown item source %q", m.itemNames[m.itemIndex], itemsType))
 	}
+	success, logs := src.Install(item)
 	if success {
 		return successInstalledItem(logs)
 	} else {
@@ -309,7 +566,7 @@ func (m Model) installItem(itemsType ItemsInstallType) tea.Msg {
 	}
 }
 
-func runScript(installer scripts.Installer, script ScriptType) tea.Msg {
+func runScript(installer scripts.Installer, script ScriptType, autologinOpts scripts.AutologinOptions) tea.Msg {
 	if installer == nil {
 		return failedScript("Installer not available")
 	}
@@ -317,11 +574,13 @@ func runScript(installer scripts.Installer, script ScriptType) tea.Msg {
 	var logs string
 	switch script {
 	case ScriptAutologin:
-		success, logs = installer.EnableAutologin()
+		success, logs = installer.EnableAutologin(autologinOpts)
+	case ScriptDisableAutologin:
+		success, logs = installer.DisableAutologin()
 	case ScriptPasswordlessSSH:
 		success, logs = installer.EnablePasswordlessSSH()
-	case ScriptPasswordlessSudo:
-		success, logs = installer.EnablePasswordlessSudo()
+	case ScriptPasswordlessPrivilege:
+		success, logs = installer.EnablePasswordlessPrivilege()
 	case ScriptAddUserToWheel:
 		success, logs = installer.AddUserToWheel()
 	default:
@@ -337,19 +596,24 @@ func (m Model) View() string {
 	var s string
 	if m.validatingSudo {
 		spin := m.spinner.View() + " "
-		if m.pendingScript == ScriptAddUserToWheel {
+		if m.reauthRequired {
+			s = spin + fmt.Sprintf("Queue paused: %s credentials expired, please enter your password...", m.installer.PrivilegeTool())
+		} else if m.upgrade.running {
+			s = spin + fmt.Sprintf("Upgrade running: %s credentials expired, please enter your password...", m.installer.PrivilegeTool())
+		} else if m.pendingScript == ScriptAddUserToWheel {
 			s = spin + "Authenticating with root, please enter your password..."
-		} else if m.pendingScript == ScriptParu {
-			total := m.installer.ParuStepCount()
-			step := m.paruStepIndex
-			name := ""
-			if step >= 0 && step < len(paruStepNames) {
-				name = paruStepNames[step]
-			}
-			s = spin + fmt.Sprintf("Installing paru (step %d/%d): %s...", step+1, total, name)
+		} else if m.pendingScript == ScriptAURHelper && m.helperStepIndex < len(m.helperSteps) {
+			total := len(m.helperSteps)
+			step := m.helperStepIndex
+			name := m.helperSteps[step]
+			s = spin + fmt.Sprintf("Installing %s (step %d/%d): %s...", m.installer.AURHelperName(), step+1, total, name)
 		} else {
-			s = spin + "Authenticating with sudo, please enter your password..."
+			s = spin + fmt.Sprintf("Authenticating with %s, please enter your password...", m.installer.PrivilegeTool())
 		}
+	} else if m.creatingSnapshot {
+		s = m.snapshotView()
+	} else if m.upgrade.running {
+		s = m.upgradeView()
 	} else if m.itemLogs {
 		n := len(m.itemNames)
 		w := lipgloss.Width(fmt.Sprintf("%d", n))
@@ -359,7 +623,8 @@ func (m Model) View() string {
 		progBar := m.progressBar.View()
 
 		itemName := currentPkgNameStyle.Render(m.itemNames[m.itemIndex])
-		info := lipgloss.NewStyle().Render("Installing " + itemName)
+		doing, _ := progressVerbs(m.itemType)
+		info := lipgloss.NewStyle().Render(doing + " " + itemName)
 
 		gap := strings.Repeat(" ", 5)
 		s = spin + info + gap + progBar + itemCount
@@ -376,3 +641,38 @@ func (m Model) View() string {
 	}
 	return s
 }
+
+// findOptionalDeps looks up the optional dependencies of the installed
+// packages that are neither installed nor among the packages themselves.
+func findOptionalDeps(installer scripts.Installer, packages []string) tea.Cmd {
+	return func() tea.Msg {
+		var found OptionalDepsFound
+		seen := make(map[string]bool)
+		for _, pkg := range packages {
+			seen[pkg] = true
+		}
+		for _, pkg := range packages {
+			for _, dep := range installer.OptionalDeps(pkg) {
+				if dep.Installed || seen[dep.Name] {
+					continue
+				}
+				seen[dep.Name] = true
+				found = append(found, OptionalDependency{OptionalDep: dep, For: pkg})
+			}
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		return found
+	}
+}
+
+// appendNew appends the values that are not in list yet.
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -1,43 +1,87 @@
 package logsview
 
 import (
+	"errors"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/fcarp10/archutils/internal/history"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/session"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "archutils-logsview")
+	if err != nil {
+		panic(err)
+	}
+	session.SetPath(filepath.Join(dir, "session.json"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 // mockScriptInstaller implements scripts.Installer with minimal stubs for logsview testing.
 type mockScriptInstaller struct {
 	installPkg     func(string) (bool, string)
 	installExt     func(string) (bool, string)
-	autologin      func() (bool, string)
+	autologin      func(scripts.AutologinOptions) (bool, string)
 	passwordless   func() (bool, string)
 	sudo           func() (bool, string)
 	addUserToWheel func() (bool, string)
 	wheelGroupCmd  func() *exec.Cmd
-	paruStepCmd    func(int) *exec.Cmd
+	helperStepCmd  func(int) *exec.Cmd
+	refreshErr     error
+	cacheErr       error
+	optionalDeps   map[string][]scripts.OptionalDep
+	upgradeCmd     func() *exec.Cmd
+	snapshotErr    error
 }
 
-func (m mockScriptInstaller) InstallPackage(pkg string) (bool, string) {
-	if m.installPkg != nil {
-		return m.installPkg(pkg)
+// mockSource implements scripts.Source, installing through the installer's
+// installPkg (packages) or installExt (every other kind) stubs.
+type mockSource struct {
+	kind    string
+	install func(string) (bool, string)
+}
+
+func (s mockSource) Kind() string { return s.kind }
+func (s mockSource) NeedsPrivilege() bool {
+	return s.kind == scripts.SourcePackages || s.kind == scripts.SourceFlatpak
+}
+func (s mockSource) Installed() map[string]string { return nil }
+func (s mockSource) Install(item string) (bool, string) {
+	if s.install != nil {
+		return s.install(item)
 	}
-	return true, pkg + ": installed"
+	return true, item + ": installed"
 }
+func (s mockSource) Remove(item string) (bool, string) { return true, item + ": removed" }
+func (s mockSource) Describe(item string) string       { return "desc of " + item }
 
-func (m mockScriptInstaller) InstallVSCodeExtension(ext string) (bool, string) {
-	if m.installExt != nil {
-		return m.installExt(ext)
+func (m mockScriptInstaller) Source(kind string) scripts.Source {
+	if kind == scripts.SourcePackages {
+		return mockSource{kind: kind, install: m.installPkg}
 	}
-	return true, ext + ": installed"
+	return mockSource{kind: kind, install: m.installExt}
 }
 
-func (m mockScriptInstaller) EnableAutologin() (bool, string) {
+func (m mockScriptInstaller) EnableAutologin(opts scripts.AutologinOptions) (bool, string) {
 	if m.autologin != nil {
-		return m.autologin()
+		return m.autologin(opts)
 	}
 	return true, "autologin enabled"
 }
 
+func (m mockScriptInstaller) DisableAutologin() (bool, string) { return true, "autologin disabled" }
+
+func (m mockScriptInstaller) LoginUsers() []string { return nil }
+
 func (m mockScriptInstaller) EnablePasswordlessSSH() (bool, string) {
 	if m.passwordless != nil {
 		return m.passwordless()
@@ -45,7 +89,7 @@ func (m mockScriptInstaller) EnablePasswordlessSSH() (bool, string) {
 	return true, "ssh configured"
 }
 
-func (m mockScriptInstaller) EnablePasswordlessSudo() (bool, string) {
+func (m mockScriptInstaller) EnablePasswordlessPrivilege() (bool, string) {
 	if m.sudo != nil {
 		return m.sudo()
 	}
@@ -66,30 +110,79 @@ func (m mockScriptInstaller) WheelGroupCmd() *exec.Cmd {
 	return exec.Command("true")
 }
 
-func (m mockScriptInstaller) ParuStepCount() int { return 4 }
+func (m mockScriptInstaller) AURHelperName() string { return "paru" }
+func (m mockScriptInstaller) TargetUser() string    { return "tester" }
+func (m mockScriptInstaller) WithUser(user string) scripts.Installer {
+	return m
+}
+func (m mockScriptInstaller) HelperStrategies() []string {
+	return []string{scripts.BootstrapSource, scripts.BootstrapBinary}
+}
+func (m mockScriptInstaller) ValidateBootstrap(opts scripts.BootstrapOptions) error { return nil }
+func (m mockScriptInstaller) HelperBootstrapPackage(opts scripts.BootstrapOptions) string {
+	return "paru"
+}
+func (m mockScriptInstaller) HelperStepNames(opts scripts.BootstrapOptions) []string {
+	return []string{"remove", "deps", "clone", "build"}
+}
 
-func (m mockScriptInstaller) ParuStepCmd(step int) *exec.Cmd {
-	if m.paruStepCmd != nil {
-		return m.paruStepCmd(step)
+func (m mockScriptInstaller) HelperStepCmd(opts scripts.BootstrapOptions, step int) *exec.Cmd {
+	if m.helperStepCmd != nil {
+		return m.helperStepCmd(step)
 	}
 	return exec.Command("true")
 }
 
-func (m mockScriptInstaller) GetPackageDescription(item string) string {
-	return "desc of " + item
+func (m mockScriptInstaller) CheckHelperInstalled() (bool, string) { return true, "" }
+func (m mockScriptInstaller) IsRepoPackage(pkg string) bool        { return true }
+func (m mockScriptInstaller) OptionalDeps(pkg string) []scripts.OptionalDep {
+	return m.optionalDeps[pkg]
 }
-
-func (m mockScriptInstaller) GetExtensionDescription(ext string) string {
-	return "desc of " + ext
+func (m mockScriptInstaller) ProviderChoices(items []string) []scripts.ProviderChoice {
+	return nil
 }
-
-func (m mockScriptInstaller) CheckParuInstalled() (bool, string)   { return true, "" }
-func (m mockScriptInstaller) IsPackageInstalled(pkg string) bool   { return false }
-func (m mockScriptInstaller) IsExtensionInstalled(ext string) bool { return false }
-func (m mockScriptInstaller) SudoValidateCmd() *exec.Cmd {
+func (m mockScriptInstaller) Conflicts(items []string) []scripts.Conflict { return nil }
+func (m mockScriptInstaller) PreflightChecks(source string) []scripts.CheckResult {
+	return nil
+}
+func (m mockScriptInstaller) PendingUpgrades() ([]scripts.PackageUpdate, bool, error) {
+	return nil, true, nil
+}
+func (m mockScriptInstaller) Orphans() ([]string, error) { return nil, nil }
+func (m mockScriptInstaller) StaleCachedPackages(retention scripts.CacheRetention) ([]scripts.CachedPackage, error) {
+	return nil, nil
+}
+func (m mockScriptInstaller) ConfigFiles() ([]scripts.ConfigFile, error) { return nil, nil }
+func (m mockScriptInstaller) ReadConfigFile(path string) (string, error) { return "", nil }
+func (m mockScriptInstaller) History() ([]history.Entry, error)          { return nil, nil }
+func (m mockScriptInstaller) PlanUndo(pkgs []string) scripts.UndoPlan {
+	return scripts.UndoPlan{Remove: pkgs}
+}
+func (m mockScriptInstaller) SnapshotTool() string { return "" }
+func (m mockScriptInstaller) CreateSnapshot(tool, description string) (session.Snapshot, error) {
+	if m.snapshotErr != nil {
+		return session.Snapshot{}, m.snapshotErr
+	}
+	return session.Snapshot{Tool: tool, ID: "42"}, nil
+}
+func (m mockScriptInstaller) MergeConfigCmd(file scripts.ConfigFile) *exec.Cmd {
+	return exec.Command("true")
+}
+func (m mockScriptInstaller) UpgradeCmd() *exec.Cmd {
+	if m.upgradeCmd != nil {
+		return m.upgradeCmd()
+	}
 	return exec.Command("true")
 }
-func (m mockScriptInstaller) GetInstalledPackages() map[string]string { return nil }
+func (m mockScriptInstaller) FetchBuildFiles(pkg string) (map[string]string, string, error) {
+	return nil, "", nil
+}
+func (m mockScriptInstaller) PrivilegeTool() string { return "sudo" }
+func (m mockScriptInstaller) PrivilegeValidateCmd() *exec.Cmd {
+	return exec.Command("true")
+}
+func (m mockScriptInstaller) RefreshPrivilege() error    { return m.refreshErr }
+func (m mockScriptInstaller) CheckPrivilegeCache() error { return m.cacheErr }
 
 func TestNewInfo(t *testing.T) {
 	m := NewInfo("test message")
@@ -133,6 +226,38 @@ func TestInstallItems_Extensions(t *testing.T) {
 	}
 }
 
+func TestInstallItems_FlatpaksValidatePrivilege(t *testing.T) {
+	m := NewItems([]string{"org.videolan.VLC"}, mockScriptInstaller{})
+	m, cmd := m.Update(InstallItems(InstallFlatpaks))
+	if !m.validatingSudo {
+		t.Error("expected validatingSudo true for flatpaks")
+	}
+	if cmd == nil {
+		t.Error("expected non-nil command")
+	}
+	if msg := m.installItem(InstallFlatpaks); msg != successInstalledItem("org.videolan.VLC: installed") {
+		t.Errorf("expected the flatpak to be installed through the installer, got %v", msg)
+	}
+}
+
+func TestInstallItems_RoutedToItemSource(t *testing.T) {
+	installer := mockScriptInstaller{installPkg: func(item string) (bool, string) { return true, item + ": installed as a package" }}
+	m := NewItems([]string{"black", "org.gimp.GIMP [source:flatpak]"}, installer)
+	m, _ = m.Update(InstallItems(InstallPipx))
+	if !m.validatingSudo {
+		t.Error("expected the flatpak item to validate privileges in a pipx run")
+	}
+	m.itemIndex = 1
+	if msg := m.installItem(InstallPipx); msg != successInstalledItem("org.gimp.GIMP: installed") {
+		t.Errorf("expected the item to be installed by flatpak without the annotation, got %v", msg)
+	}
+
+	m = NewItems([]string{"git [source:packages]"}, installer)
+	if msg := m.installItem(InstallFlatpaks); msg != successInstalledItem("git: installed as a package") {
+		t.Errorf("expected the item to be installed as a package, got %v", msg)
+	}
+}
+
 func TestSudoValidated_Success(t *testing.T) {
 	m := NewItems([]string{"pkg1"}, mockScriptInstaller{})
 	m.validatingSudo = true
@@ -160,6 +285,20 @@ func TestSudoValidated_Failure(t *testing.T) {
 	}
 }
 
+func TestSudoValidated_NoCredentialCache(t *testing.T) {
+	m := NewItems([]string{"pkg1"}, mockScriptInstaller{cacheErr: errors.New("run0 does not cache credentials")})
+	m.validatingSudo = true
+	m.itemLogs = true
+
+	m, cmd := m.Update(SudoValidated{})
+	if m.itemLogs || m.keepalive {
+		t.Error("expected the run not to start without a credential cache")
+	}
+	if msg, ok := cmd().(DisableLogs); !ok || !strings.Contains(string(msg), "run0 does not cache credentials") {
+		t.Errorf("unexpected message %v", msg)
+	}
+}
+
 func TestCancelInstall(t *testing.T) {
 	m := NewItems([]string{"pkg1", "pkg2"}, mockScriptInstaller{})
 	m.itemLogs = true
@@ -221,20 +360,112 @@ func TestFinishedInstallItems(t *testing.T) {
 	}
 }
 
+func TestFinishedInstallItems_GroupsNeedRelogin(t *testing.T) {
+	m := NewItems([]string{"docker [docker] [group:docker]", "virt-manager [group:libvirt] [group:docker]"}, mockScriptInstaller{})
+	m.itemType = InstallPackages
+	m, _ = m.Update(successInstalledItem("docker: Installed successfully"))
+	m.itemIndex = 1
+	m, _ = m.Update(successInstalledItem("virt-manager: Installed successfully"))
+
+	_, cmd := m.Update(finishedInstallItems(""))
+	var summary DisableLogs
+	for _, msg := range runCmd(cmd) {
+		if s, ok := msg.(DisableLogs); ok {
+			summary = s
+		}
+	}
+	if !strings.Contains(string(summary), "tester was added to docker, libvirt: log out and back in") {
+		t.Errorf("expected a re-login note for the joined groups, got %q", summary)
+	}
+}
+
+// runCmd runs cmd and returns its messages, flattening batches.
+func runCmd(cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	msg := cmd()
+	batch, ok := msg.(tea.BatchMsg)
+	if !ok {
+		return []tea.Msg{msg}
+	}
+	var msgs []tea.Msg
+	for _, c := range batch {
+		msgs = append(msgs, runCmd(c)...)
+	}
+	return msgs
+}
+
+func TestFinishedInstallItems_OffersOptionalDeps(t *testing.T) {
+	installer := mockScriptInstaller{optionalDeps: map[string][]scripts.OptionalDep{
+		"pipewire": {
+			{Name: "pipewire-alsa", Reason: "ALSA configuration", Installed: true},
+			{Name: "pipewire-jack", Reason: "JACK support"},
+			{Name: "docker", Reason: "already queued"},
+		},
+		"docker": {{Name: "pipewire-jack", Reason: "listed twice"}},
+	}}
+	m := NewItems([]string{"pipewire", "docker [docker]", scripts.AsDependency("libva")}, installer)
+	m.itemType = InstallPackages
+	for i := range m.itemNames {
+		m.itemIndex = i
+		m, _ = m.Update(successInstalledItem("installed"))
+	}
+
+	_, cmd := m.Update(finishedInstallItems(""))
+	var found OptionalDepsFound
+	for _, msg := range runCmd(cmd) {
+		if f, ok := msg.(OptionalDepsFound); ok {
+			found = f
+		}
+	}
+	if len(found) != 1 || found[0].Name != "pipewire-jack" || found[0].For != "pipewire" || found[0].Reason != "JACK support" {
+		t.Errorf("expected only the missing pipewire-jack to be offered, got %+v", found)
+	}
+}
+
 func TestRunningScript(t *testing.T) {
 	m := NewScript(mockScriptInstaller{})
-	m, cmd := m.Update(RunningScript(ScriptParu))
+	m, cmd := m.Update(RunningScript(ScriptAURHelper))
 	if !m.validatingSudo {
 		t.Error("expected validatingSudo true for script")
 	}
-	if m.pendingScript != ScriptParu {
-		t.Errorf("expected pendingScript ScriptParu, got %d", m.pendingScript)
+	if m.pendingScript != ScriptAURHelper {
+		t.Errorf("expected pendingScript ScriptAURHelper, got %d", m.pendingScript)
 	}
 	if cmd == nil {
 		t.Error("expected non-nil command")
 	}
 }
 
+func TestHelperBootstrap_ResumesAndReportsFailure(t *testing.T) {
+	var started []int
+	installer := mockScriptInstaller{helperStepCmd: func(step int) *exec.Cmd {
+		started = append(started, step)
+		return exec.Command("true")
+	}}
+	opts := scripts.BootstrapOptions{Strategy: scripts.BootstrapBinary}
+	m := NewScript(installer).WithHelperBootstrap(opts, 2)
+	m, _ = m.Update(RunningScript(ScriptAURHelper))
+	if len(started) != 1 || started[0] != 2 {
+		t.Fatalf("expected bootstrap to resume at step 2, started %v", started)
+	}
+
+	_, cmd := m.Update(HelperStepValidated{err: exec.ErrNotFound})
+	var failed *HelperInstallFailed
+	for _, msg := range cmd().(tea.BatchMsg) {
+		if f, ok := msg().(HelperInstallFailed); ok {
+			failed = &f
+		}
+	}
+	if failed == nil {
+		t.Fatal("expected a HelperInstallFailed message")
+	}
+	if failed.Step != 2 || failed.Opts != opts {
+		t.Errorf("unexpected failure report %+v", *failed)
+	}
+}
+
 func TestSuccessScript(t *testing.T) {
 	m := NewScript(mockScriptInstaller{})
 	m, _ = m.Update(successScript("script done"))
@@ -276,3 +507,299 @@ func TestViewModes(t *testing.T) {
 		t.Error("expected non-empty view for installing")
 	}
 }
+
+func TestKeepalive_FailurePausesQueue(t *testing.T) {
+	m := NewItems([]string{"pkg1", "pkg2"}, mockScriptInstaller{})
+	m.itemLogs = true
+	m.keepalive = true
+
+	m, _ = m.Update(keepaliveChecked{err: exec.ErrNotFound})
+	if !m.reauthRequired {
+		t.Fatal("expected reauthRequired after a failed refresh")
+	}
+
+	// The running item finishes; the queue should prompt instead of advancing.
+	m, cmd := m.Update(successInstalledItem("pkg1 installed"))
+	if m.itemIndex != 0 {
+		t.Errorf("expected queue to stay on item 0 while paused, got %d", m.itemIndex)
+	}
+	if !m.validatingSudo {
+		t.Error("expected re-authentication prompt while paused")
+	}
+	if cmd == nil {
+		t.Error("expected a re-authentication command")
+	}
+
+	m, _ = m.Update(privilegeReauthenticated{err: nil})
+	if m.reauthRequired || m.validatingSudo {
+		t.Error("expected pause to clear after re-authentication")
+	}
+	if m.itemIndex != 1 {
+		t.Errorf("expected queue to resume at item 1, got %d", m.itemIndex)
+	}
+}
+
+func TestKeepalive_RepeatedReauthFailureStopsQueue(t *testing.T) {
+	m := NewItems([]string{"pkg1", "pkg2", "pkg3"}, mockScriptInstaller{})
+	m.itemLogs = true
+	m.reauthRequired = true
+
+	for i := 0; i < maxReauthAttempts; i++ {
+		m, _ = m.Update(privilegeReauthenticated{err: exec.ErrNotFound})
+	}
+	if !m.cancelRequested {
+		t.Error("expected queue to stop after repeated authentication failures")
+	}
+}
+
+func TestKeepaliveTick_IgnoredWhenIdle(t *testing.T) {
+	m := NewInfo("idle")
+	_, cmd := m.Update(keepaliveTick{})
+	if cmd != nil {
+		t.Error("expected no keepalive when no install is running")
+	}
+}
+
+func TestKeepaliveTick_IgnoredFromFinishedRun(t *testing.T) {
+	m := NewItems([]string{"pkg1"}, mockScriptInstaller{})
+	m.itemLogs = true
+	m.validatingSudo = true
+	m, _ = m.Update(SudoValidated{})
+	if !m.keepalive {
+		t.Fatal("expected the credentials to be kept alive during the run")
+	}
+	stale := m.keepaliveRun - 1
+	if _, cmd := m.Update(keepaliveTick{run: stale}); cmd != nil {
+		t.Error("expected a tick of a finished run not to start another refresh chain")
+	}
+	if m, _ = m.Update(keepaliveChecked{run: stale, err: exec.ErrNotFound}); m.reauthRequired {
+		t.Error("expected a refresh of a finished run not to pause the queue")
+	}
+	if _, cmd := m.Update(keepaliveTick{run: m.keepaliveRun}); cmd == nil {
+		t.Error("expected the current run to refresh the credentials")
+	}
+}
+
+func TestSessionSavedAsItemsFinish(t *testing.T) {
+	defer session.Clear()
+	m := NewItems([]string{"pkg1", "pkg2"}, mockScriptInstaller{})
+	m, _ = m.Update(InstallItems(InstallExtensions))
+
+	saved, ok, err := session.Load()
+	if err != nil || !ok {
+		t.Fatalf("expected a saved session, ok=%v err=%v", ok, err)
+	}
+	if len(saved.Pending) != 2 || saved.Source != string(InstallExtensions) {
+		t.Errorf("unexpected session at start: %+v", saved)
+	}
+
+	m, _ = m.Update(failedInstalledItem("pkg1 failed"))
+	saved, _, _ = session.Load()
+	if len(saved.Pending) != 1 || saved.Pending[0] != "pkg2" {
+		t.Errorf("expected pkg2 pending, got %v", saved.Pending)
+	}
+	if len(saved.Results) != 1 || saved.Results[0].Success {
+		t.Errorf("expected one failed result, got %+v", saved.Results)
+	}
+
+	m, _ = m.Update(finishedInstallItems("pkg2"))
+	if _, ok, _ := session.Load(); ok {
+		t.Error("expected session to be cleared when the run finishes")
+	}
+}
+
+func TestRunUpgrade_StreamsOutput(t *testing.T) {
+	installer := mockScriptInstaller{upgradeCmd: func() *exec.Cmd {
+		return exec.Command("sh", "-c", "echo first; echo second")
+	}}
+	updates := []scripts.PackageUpdate{{Name: "linux", From: "1", To: "2"}, {Name: "git", From: "1", To: "2"}}
+	m := NewScript(installer)
+	m, _ = m.Update(RunUpgrade{Updates: updates})
+	if !m.validatingSudo {
+		t.Fatal("expected the privilege tool to be validated first")
+	}
+	m, _ = m.Update(upgradeValidated{})
+	if !m.upgrade.running || !m.IsActive() {
+		t.Fatal("expected the upgrade to be running")
+	}
+	// The keepalive tick of the batch is left out, it fires after a minute.
+	cmd := waitForOutput(m.upgrade.output)
+	for cmd != nil {
+		var next tea.Cmd
+		for _, msg := range runCmd(cmd) {
+			switch msg.(type) {
+			case upgradeOutput, upgradeFinished:
+				m, next = m.Update(msg)
+			}
+		}
+		cmd = next
+	}
+	if m.upgrade.running {
+		t.Fatal("expected the upgrade to finish")
+	}
+	if got := strings.Join(m.upgrade.lines, ","); got != "first,second" {
+		t.Errorf("expected streamed lines, got %q", got)
+	}
+	if !strings.Contains(m.logs, "upgraded successfully") || !strings.Contains(m.logs, "Reboot to finish updating linux") {
+		t.Errorf("unexpected summary %q", m.logs)
+	}
+}
+
+func TestRunUpgrade_Failure(t *testing.T) {
+	installer := mockScriptInstaller{upgradeCmd: func() *exec.Cmd {
+		return exec.Command("sh", "-c", "echo 'error: failed to commit transaction'; exit 1")
+	}}
+	m := NewScript(installer)
+	m, _ = m.Update(RunUpgrade{})
+	m, _ = m.Update(upgradeValidated{})
+	cmd := waitForOutput(m.upgrade.output)
+	for cmd != nil {
+		var next tea.Cmd
+		for _, msg := range runCmd(cmd) {
+			switch msg.(type) {
+			case upgradeOutput, upgradeFinished:
+				m, next = m.Update(msg)
+			}
+		}
+		cmd = next
+	}
+	if !strings.Contains(m.logs, "System upgrade failed") || !strings.Contains(m.logs, "failed to commit transaction") {
+		t.Errorf("expected the failure and output tail, got %q", m.logs)
+	}
+}
+
+func TestRunUpgrade_NoCredentialCache(t *testing.T) {
+	m := NewScript(mockScriptInstaller{cacheErr: errors.New("run0 does not cache credentials")})
+	m, _ = m.Update(RunUpgrade{})
+	m, cmd := m.Update(upgradeValidated{})
+	if cmd != nil || m.scriptRunning || m.keepalive {
+		t.Error("expected the upgrade not to start without a credential cache")
+	}
+	if !strings.Contains(m.logs, "Nothing was upgraded: run0 does not cache credentials") {
+		t.Errorf("unexpected logs %q", m.logs)
+	}
+}
+
+func TestRunUpgrade_KeepsCredentialsAlive(t *testing.T) {
+	installer := mockScriptInstaller{upgradeCmd: func() *exec.Cmd { return exec.Command("sleep", "1") }}
+	m := NewScript(installer)
+	m, _ = m.Update(RunUpgrade{})
+	m, _ = m.Update(upgradeValidated{})
+	if !m.keepalive {
+		t.Fatal("expected the credentials to be kept alive during the upgrade")
+	}
+	if _, cmd := m.Update(keepaliveTick{run: m.keepaliveRun}); cmd == nil {
+		t.Error("expected the keepalive to refresh the credentials")
+	}
+
+	m, cmd := m.Update(keepaliveChecked{run: m.keepaliveRun, err: exec.ErrNotFound})
+	if !m.validatingSudo || cmd == nil {
+		t.Fatal("expected a re-authentication prompt after a failed refresh")
+	}
+	if view := m.View(); !strings.Contains(view, "Upgrade running") {
+		t.Errorf("unexpected view %q", view)
+	}
+	m, _ = m.Update(upgradeReauthenticated{})
+	if m.validatingSudo || !m.keepalive {
+		t.Error("expected the upgrade to go on after re-authentication")
+	}
+
+	for i := 0; i < maxReauthAttempts; i++ {
+		m, _ = m.Update(upgradeReauthenticated{err: exec.ErrNotFound})
+	}
+	if m.keepalive || !strings.Contains(strings.Join(m.upgrade.lines, "\n"), "authentication failed") {
+		t.Error("expected the keepalive to stop after repeated authentication failures")
+	}
+}
+
+func TestView_MaintenanceVerbs(t *testing.T) {
+	m := NewItems([]string{"libfoo"}, mockScriptInstaller{})
+	m.itemLogs = true
+	m.itemType = InstallOrphans
+	if view := m.View(); !strings.Contains(view, "Removing") {
+		t.Errorf("expected orphans to be removed, got %q", view)
+	}
+	m.itemType = InstallConfigs
+	if view := m.View(); !strings.Contains(view, "Resolving") {
+		t.Errorf("expected config files to be resolved, got %q", view)
+	}
+}
+
+func TestSnapshot_BeforeItems(t *testing.T) {
+	defer session.Clear()
+	m := NewItems([]string{"pkg1"}, mockScriptInstaller{}).WithSnapshot("snapper", "archutils: before packages run of 1 item(s)")
+	m, _ = m.Update(InstallItems(InstallPackages))
+	m, _ = m.Update(SudoValidated{})
+	if !m.creatingSnapshot || !strings.Contains(m.View(), "Creating a snapper snapshot") {
+		t.Fatalf("expected the snapshot to be taken first, view %q", m.View())
+	}
+	m, cmd := m.Update(m.createSnapshot()())
+	if m.creatingSnapshot || cmd == nil {
+		t.Fatal("expected the items to start after the snapshot")
+	}
+	if saved, _, _ := session.Load(); saved.Snapshot == nil || saved.Snapshot.ID != "42" {
+		t.Errorf("expected the snapshot in the saved session, got %+v", saved.Snapshot)
+	}
+
+	m, _ = m.Update(successInstalledItem("pkg1: ok"))
+	m, cmd = m.Update(finishedInstallItems("pkg1"))
+	var summary DisableLogs
+	for _, msg := range runCmd(cmd) {
+		if msg, ok := msg.(DisableLogs); ok {
+			summary = msg
+		}
+	}
+	if !strings.Contains(string(summary), "Snapshot before the run: snapper #42") {
+		t.Errorf("expected the snapshot in the results, got %q", summary)
+	}
+	records, _ := session.Archived()
+	if last := records[len(records)-1]; last.Snapshot == nil || last.Snapshot.ID != "42" {
+		t.Errorf("expected the snapshot in the archive, got %+v", last)
+	}
+}
+
+func TestSnapshot_FailureStopsRun(t *testing.T) {
+	defer session.Clear()
+	installer := mockScriptInstaller{snapshotErr: errors.New("no space left")}
+	m := NewItems([]string{"pkg1"}, installer).WithSnapshot("timeshift", "before")
+	m, _ = m.Update(InstallItems(InstallPackages))
+	m, _ = m.Update(SudoValidated{})
+	m, cmd := m.Update(m.createSnapshot()())
+	if m.IsActive() || cmd == nil {
+		t.Fatal("expected the run to stop")
+	}
+	if msg, ok := cmd().(DisableLogs); !ok || !strings.Contains(string(msg), "Snapshot failed, nothing was changed: no space left") {
+		t.Errorf("unexpected message %v", msg)
+	}
+	if _, ok, _ := session.Load(); ok {
+		t.Error("expected no session to resume")
+	}
+}
+
+func TestSnapshot_BeforeUpgrade(t *testing.T) {
+	installer := mockScriptInstaller{upgradeCmd: func() *exec.Cmd { return exec.Command("true") }}
+	m := NewScript(installer).WithSnapshot("snapper", "before")
+	m, _ = m.Update(RunUpgrade{})
+	m, _ = m.Update(upgradeValidated{})
+	if !m.creatingSnapshot || m.upgrade.running {
+		t.Fatal("expected the snapshot to be taken before the upgrade")
+	}
+	cmd := m.createSnapshot()
+	for cmd != nil {
+		var next tea.Cmd
+		for _, msg := range runCmd(cmd) {
+			switch msg.(type) {
+			case snapshotCreated, upgradeOutput, upgradeFinished:
+				m, next = m.Update(msg)
+			}
+		}
+		cmd = next
+	}
+	if !strings.Contains(m.logs, "upgraded successfully\n\nSnapshot before the upgrade: snapper #42") {
+		t.Errorf("unexpected summary %q", m.logs)
+	}
+	records, _ := session.Archived()
+	if last := records[len(records)-1]; last.Source != session.SourceUpgrade || last.Snapshot == nil {
+		t.Errorf("expected the upgrade in the archive, got %+v", last)
+	}
+}
@@ -0,0 +1,86 @@
+package logsview
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fcarp10/archutils/internal/session"
+)
+
+type snapshotCreated struct {
+	snapshot session.Snapshot
+	err      error
+}
+
+// snapshotRequest is the snapshot to take once credentials are validated.
+type snapshotRequest struct {
+	tool        string
+	description string
+}
+
+// WithSnapshot takes a snapshot with tool, labelled with description, before
+// the run starts. A run whose session already has a snapshot takes none.
+func (m Model) WithSnapshot(tool, description string) Model {
+	m.snapshot = snapshotRequest{tool: tool, description: description}
+	return m
+}
+
+// wantsSnapshot reports whether a snapshot is still to be taken.
+func (m Model) wantsSnapshot() bool {
+	return m.snapshot.tool != "" && m.session.Snapshot == nil
+}
+
+func (m Model) createSnapshot() tea.Cmd {
+	installer, req := m.installer, m.snapshot
+	return func() tea.Msg {
+		snapshot, err := installer.CreateSnapshot(req.tool, req.description)
+		return snapshotCreated{snapshot: snapshot, err: err}
+	}
+}
+
+// handleSnapshotCreated starts the item run or the upgrade the snapshot was
+// taken for. Without its snapshot, neither starts.
+func (m Model) handleSnapshotCreated(msg snapshotCreated) (Model, tea.Cmd) {
+	m.creatingSnapshot = false
+	if m.itemLogs {
+		if msg.err != nil {
+			m.itemLogs = false
+			m.keepalive = false
+			session.Clear()
+			return m, func() tea.Msg {
+				return DisableLogs(fmt.Sprintf("%s Snapshot failed, nothing was changed: %v", CrossMark, msg.err))
+			}
+		}
+		m.session.Snapshot = &msg.snapshot
+		m.saveSession()
+		return m, func() tea.Msg { return m.installItem(m.itemType) }
+	}
+
+	if msg.err != nil {
+		m.scriptRunning = false
+		m.keepalive = false
+		m.logs = fmt.Sprintf("%s Snapshot failed, nothing was upgraded: %v", CrossMark, msg.err)
+		return m, nil
+	}
+	m.upgrade.snapshot = &msg.snapshot
+	return m.startUpgrade()
+}
+
+// archiveUpgrade records the finished upgrade in the session archive with
+// its snapshot and updates, so that its transactions show as an archutils
+// run.
+func (m Model) archiveUpgrade(err error) {
+	s := session.State{Source: session.SourceUpgrade, StartedAt: m.upgrade.startedAt, Snapshot: m.upgrade.snapshot}
+	for _, u := range m.upgrade.updates {
+		s = s.Record(u.Name, err == nil, u.From+" -> "+u.To)
+	}
+	if err := session.Archive(s, time.Now()); err != nil {
+		log.Printf("warning: failed to archive the upgrade in %s: %v", session.ArchivePath(), err)
+	}
+}
+
+func (m Model) snapshotView() string {
+	return m.spinner.View() + fmt.Sprintf(" Creating a %s snapshot before the run...", m.snapshot.tool)
+}
@@ -0,0 +1,204 @@
+package logsview
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/fcarp10/archutils/internal/scripts"
+	"github.com/fcarp10/archutils/internal/session"
+)
+
+// RunUpgrade starts the system upgrade; Updates are the packages it changes.
+type RunUpgrade struct {
+	Updates []scripts.PackageUpdate
+}
+
+type upgradeValidated struct{ err error }
+type upgradeOutput string
+type upgradeFinished struct{ err error }
+type upgradeReauthenticated struct{ err error }
+
+// upgradeTailLines is how many lines of upgrade output the pane shows.
+const upgradeTailLines = 15
+
+var rebootStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Bold(true)
+
+// upgradeState is the state of a running system upgrade.
+type upgradeState struct {
+	running   bool
+	updates   []scripts.PackageUpdate
+	lines     []string
+	output    chan tea.Msg
+	startedAt time.Time
+	// snapshot is the snapshot taken before the upgrade, if any.
+	snapshot *session.Snapshot
+}
+
+// RebootUpdates returns the names of the updates that need a reboot.
+func RebootUpdates(updates []scripts.PackageUpdate) []string {
+	var names []string
+	for _, u := range updates {
+		if u.NeedsReboot() {
+			names = append(names, u.Name)
+		}
+	}
+	return names
+}
+
+// FormatUpdates lists the updates one per line, highlighting the ones that
+// need a reboot.
+func FormatUpdates(updates []scripts.PackageUpdate) string {
+	var s string
+	for _, u := range updates {
+		line := fmt.Sprintf("%s %s -> %s", u.Name, u.From, u.To)
+		if u.AUR {
+			line += " (AUR)"
+		}
+		if u.NeedsReboot() {
+			line = rebootStyle.Render(line + " [reboot]")
+		}
+		s += "  " + line + "\n"
+	}
+	return s
+}
+
+func (m Model) handleUpgrade(msg tea.Msg) (Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case RunUpgrade:
+		m.upgrade = upgradeState{updates: msg.Updates}
+		m.validatingSudo = true
+		return m, tea.ExecProcess(m.installer.PrivilegeValidateCmd(), func(err error) tea.Msg {
+			return upgradeValidated{err: err}
+		})
+
+	case upgradeValidated:
+		m.validatingSudo = false
+		if msg.err != nil {
+			m.logs = fmt.Sprintf("%s %s authentication failed, nothing was upgraded", CrossMark, m.installer.PrivilegeTool())
+			return m, nil
+		}
+		if err := m.installer.CheckPrivilegeCache(); err != nil {
+			m.logs = fmt.Sprintf("%s Nothing was upgraded: %v", CrossMark, err)
+			return m, nil
+		}
+		m.scriptRunning = true
+		m.upgrade.startedAt = time.Now()
+		// AUR helpers call the privilege tool again after each build, so the
+		// credentials are kept alive as for item runs.
+		m.keepalive = true
+		m.keepaliveRun++
+		if m.wantsSnapshot() {
+			m.creatingSnapshot = true
+			return m, tea.Batch(m.spinner.Tick, keepaliveCmd(m.keepaliveRun), m.createSnapshot())
+		}
+		m, cmd := m.startUpgrade()
+		return m, tea.Batch(m.spinner.Tick, keepaliveCmd(m.keepaliveRun), cmd)
+
+	case upgradeReauthenticated:
+		m.validatingSudo = false
+		if msg.err == nil {
+			m.reauthAttempts = 0
+			return m, nil
+		}
+		if m.reauthAttempts++; m.reauthAttempts < maxReauthAttempts {
+			return m.reauthenticateUpgrade()
+		}
+		// The upgrade cannot be paused; it goes on and fails at its next
+		// privileged step.
+		m.keepalive = false
+		m.upgrade.lines = append(m.upgrade.lines, fmt.Sprintf("%s %s authentication failed, privileged steps of the upgrade will fail", CrossMark, m.installer.PrivilegeTool()))
+		return m, nil
+
+	case upgradeOutput:
+		m.upgrade.lines = append(m.upgrade.lines, string(msg))
+		if len(m.upgrade.lines) > upgradeTailLines {
+			m.upgrade.lines = m.upgrade.lines[len(m.upgrade.lines)-upgradeTailLines:]
+		}
+		return m, waitForOutput(m.upgrade.output)
+
+	case upgradeFinished:
+		m.scriptRunning = false
+		m.upgrade.running = false
+		m.keepalive = false
+		m.reauthAttempts = 0
+		m.archiveUpgrade(msg.err)
+		var snapshot string
+		if m.upgrade.snapshot != nil {
+			snapshot = fmt.Sprintf("\n\nSnapshot before the upgrade: %s", m.upgrade.snapshot)
+		}
+		tail := strings.Join(m.upgrade.lines, "\n")
+		if msg.err != nil {
+			m.logs = fmt.Sprintf("%s System upgrade failed: %v%s\n\n%s", CrossMark, msg.err, snapshot, tail)
+			return m, nil
+		}
+		m.logs = fmt.Sprintf("%s System upgraded successfully%s", CheckMark, snapshot)
+		if reboot := RebootUpdates(m.upgrade.updates); len(reboot) > 0 {
+			m.logs += "\n\n" + rebootStyle.Render("Reboot to finish updating "+strings.Join(reboot, ", ")+".")
+		}
+		return m, nil
+	}
+	return m, nil
+}
+
+// reauthenticateUpgrade prompts for credentials through the terminal while
+// the upgrade keeps running.
+func (m Model) reauthenticateUpgrade() (Model, tea.Cmd) {
+	m.validatingSudo = true
+	return m, tea.ExecProcess(m.installer.PrivilegeValidateCmd(), func(err error) tea.Msg {
+		return upgradeReauthenticated{err: err}
+	})
+}
+
+// startUpgrade streams the output of the upgrade command.
+func (m Model) startUpgrade() (Model, tea.Cmd) {
+	m.upgrade.running = true
+	m.upgrade.output = streamCmd(m.installer.UpgradeCmd())
+	return m, waitForOutput(m.upgrade.output)
+}
+
+// streamCmd starts cmd and sends each line of its combined output, then its
+// exit status, on the returned channel.
+func streamCmd(cmd *exec.Cmd) chan tea.Msg {
+	ch := make(chan tea.Msg)
+	go func() {
+		defer close(ch)
+		pr, pw := io.Pipe()
+		cmd.Stdout = pw
+		cmd.Stderr = pw
+		if err := cmd.Start(); err != nil {
+			ch <- upgradeFinished{err: err}
+			return
+		}
+		waitErr := make(chan error, 1)
+		go func() {
+			err := cmd.Wait()
+			pw.Close()
+			waitErr <- err
+		}()
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			ch <- upgradeOutput(scanner.Text())
+		}
+		// Drain anything left after an overlong line so Wait can finish.
+		io.Copy(io.Discard, pr)
+		ch <- upgradeFinished{err: <-waitErr}
+	}()
+	return ch
+}
+
+func waitForOutput(ch chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+func (m Model) upgradeView() string {
+	s := m.spinner.View() + " Upgrading the system...\n\n"
+	return s + strings.Join(m.upgrade.lines, "\n")
+}
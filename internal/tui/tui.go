@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/fcarp10/archutils/internal/hardware"
 	"github.com/fcarp10/archutils/internal/scripts"
 	hlp "github.com/fcarp10/archutils/internal/tui/helpkeys"
 	"github.com/fcarp10/archutils/internal/tui/listview"
@@ -22,10 +23,12 @@ type mainModel struct {
 	help     help.Model
 }
 
-func InitialModel() mainModel {
+// InitialModel starts at the main menu; hw is the detected hardware the
+// categories are evaluated against.
+func InitialModel(installer scripts.Installer, hw hardware.Info) mainModel {
 	return mainModel{
 		help:     help.New(),
-		listView: listview.New(scripts.Runner{}),
+		listView: listview.New(installer).WithHardware(hw),
 	}
 }
 